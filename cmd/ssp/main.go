@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"fmt"
 	"net/http"
 	"runtime/debug"
@@ -11,6 +12,7 @@ import (
 	"github.com/justinas/alice"
 	"github.com/lox/httpcache"
 	"github.com/ripta/ssp/config"
+	"github.com/ripta/ssp/proxy/clientip"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"gopkg.in/yaml.v2"
@@ -25,6 +27,13 @@ func init() {
 func main() {
 	opts := parseOptions()
 	log := opts.Log
+	if opts.Sign != nil {
+		if err := sign(opts.Sign, opts.Config); err != nil {
+			log.Fatal().Err(err).Msg("could not sign URL")
+		}
+		return
+	}
+
 	log.Debug().Interface("options", opts).Msg("parsed options")
 	if version := opts.Version(); version != "" {
 		log.Info().Msgf("ssp %s (built %s)", version, BuildDate)
@@ -56,15 +65,65 @@ func main() {
 		}
 	}
 
+	chain := newHandlerChain(log, cfg)
+	h := chain.Then(r)
+
+	if addr := cfg.Proxy.AdminAddress; addr != "" {
+		srv := newServer(addr, newAdminHandler(), cfg)
+		go func() {
+			log.Info().Msg(fmt.Sprintf("Ready to serve metrics on %s", addr))
+			if err := srv.ListenAndServe(); err != nil {
+				log.Fatal().Err(err).Msg("cannot listen for admin requests")
+			}
+		}()
+	}
+
+	if cfg.TLS != nil {
+		tc, err := cfg.TLS.ServerConfig()
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not configure TLS")
+		}
+		srv := newServer(":"+strconv.Itoa(cfg.TLS.ListenPort()), h, cfg)
+		srv.TLSConfig = tc
+		go func() {
+			log.Info().Msg(fmt.Sprintf("Ready to serve TLS requests on port %d", cfg.TLS.ListenPort()))
+			if err := srv.ListenAndServeTLS("", ""); err != nil {
+				log.Fatal().Err(err).Msg("cannot listen for TLS")
+			}
+		}()
+	}
+
 	port := strconv.Itoa(opts.Port)
 	log.Info().Msg(fmt.Sprintf("Ready to serve requests on port %s", port))
 
-	chain := newHandlerChain(log, cfg)
-	if err := http.ListenAndServe(":"+port, chain.Then(r)); err != nil {
+	if err := newServer(":"+port, h, cfg).ListenAndServe(); err != nil {
 		log.Fatal().Err(err).Msg("cannot listen")
 	}
 }
 
+// idleTimeout is how long idle keep-alive connections are kept open.
+const idleTimeout = 2 * time.Minute
+
+// newServer returns a server of h on addr. Only reading request headers is
+// limited by the server; handlers are limited by timeoutHandler until they
+// start responding, and responses may then take as long as they need.
+func newServer(addr string, h http.Handler, cfg *config.ConfigRoot) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: cfg.Proxy.Timeout(),
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// newAdminHandler serves metrics, which are not meant for the clients of
+// any handler.
+func newAdminHandler() http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/varz", expvar.Handler())
+	return m
+}
+
 func accessLogger(r *http.Request, status, size int, dur time.Duration) {
 	hlog.FromRequest(r).Info().
 		Str("scheme", r.URL.Scheme).
@@ -77,7 +136,11 @@ func accessLogger(r *http.Request, status, size int, dur time.Duration) {
 
 func cachingHandlerGenerator(c httpcache.Cache) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
-		return httpcache.NewHandler(c, h)
+		// The cache is shared by all clients, so responses to authenticated
+		// requests must never be stored
+		ch := httpcache.NewHandler(c, h)
+		ch.Shared = true
+		return ch
 	}
 }
 
@@ -108,6 +171,15 @@ func newHandlerChain(log zerolog.Logger, cfg *config.ConfigRoot) alice.Chain {
 	// Inject the logging device as early as possible in the chain
 	chain := alice.New(hlog.NewHandler(log))
 
+	// Resolve the client's address before anything uses it
+	trusted, err := clientip.ParsePrefixes(cfg.Proxy.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not parse trusted_proxies")
+	}
+	if len(trusted) > 0 {
+		chain = chain.Append(clientip.Handler(trusted))
+	}
+
 	// Add all handlers that inject further information for the access logger
 	chain = chain.Append(
 		hlog.MethodHandler("method"),
@@ -119,17 +191,31 @@ func newHandlerChain(log zerolog.Logger, cfg *config.ConfigRoot) alice.Chain {
 	)
 
 	if h := cfg.Proxy.TrustForwardedHeaders; h != nil && *h {
-		chain = chain.Append(proxyHeaderRewriteHandler)
+		chain = chain.Append(proxyHeaderRewriteHandler(len(trusted) > 0))
 	}
 
 	chain = chain.Append(hlog.AccessHandler(accessLogger))
 
-	// Enforce a timeout on anything further in the chain
-	d := 10 * time.Second
-	if t := cfg.Proxy.TimeoutDuration; t != nil {
-		d = *t
+	// Limit each client across all handlers, so that refused requests are
+	// still logged
+	l, err := cfg.Proxy.RateLimit.NewLimiter()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not configure proxy_settings")
+	}
+	if l != nil {
+		chain = chain.Append(l.Handler)
 	}
-	chain = chain.Append(timeoutHandler(d, "timed out"))
+	bl, err := cfg.Proxy.Bandwidth.NewLimit("global")
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not configure proxy_settings")
+	}
+	if bl != nil {
+		chain = chain.Append(bl.Handler)
+	}
+
+	// Enforce a timeout on anything further in the chain, until it starts
+	// responding
+	chain = chain.Append(timeoutHandler(cfg.Proxy.Timeout(), "timed out"))
 
 	// In-memory caching is optional
 	if e := cfg.Cache.Enable; e != nil && *e {
@@ -145,20 +231,21 @@ func newHandlerChain(log zerolog.Logger, cfg *config.ConfigRoot) alice.Chain {
 // proxyHeaderRewriteHandler is a partial reimplementation of gorilla toolkit's
 // handlers.ProxyHeaders that _only_ looks at X-Forwarded-Host. Rewriting the other
 // headers seem to break matching in gorilla mux, even with the route.Schemes(...)
-// set to ["http", "https"].
-func proxyHeaderRewriteHandler(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		if v := r.Header.Get(http.CanonicalHeaderKey("X-Forwarded-Host")); v != "" {
-			r.Host = v
+// set to ["http", "https"]. When onlyTrusted is set, the header is only believed
+// on requests from trusted proxies.
+func proxyHeaderRewriteHandler(onlyTrusted bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if onlyTrusted && !clientip.ViaTrustedProxy(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if v := r.Header.Get(http.CanonicalHeaderKey("X-Forwarded-Host")); v != "" {
+				r.Host = v
+			}
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
-	}
-	return http.HandlerFunc(fn)
-}
-
-func timeoutHandler(dt time.Duration, msg string) func(http.Handler) http.Handler {
-	return func(h http.Handler) http.Handler {
-		return http.TimeoutHandler(h, dt, msg)
+		return http.HandlerFunc(fn)
 	}
 }
 
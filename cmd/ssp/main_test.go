@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+
+	"github.com/ripta/ssp/config"
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+// newTestServer serves h through the same chain of handlers as main, with a
+// timeout of d.
+func newTestServer(t *testing.T, d time.Duration, bw *config.ConfigBandwidth, h http.Handler) *httptest.Server {
+	t.Helper()
+
+	cfg := &config.ConfigRoot{Proxy: config.ProxySettings{TimeoutDuration: &d, Bandwidth: bw}}
+	r := mux.NewRouter()
+	r.PathPrefix("/").Handler(h)
+	srv := httptest.NewServer(newHandlerChain(zerolog.Nop(), cfg).Then(r))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestThrottledDownloadOutlastsTimeout(t *testing.T) {
+	body := strings.Repeat("x", 40000)
+	h, err := proxy.NewHandler(proxytest.NewMemoryBackend([]proxytest.Object{{Key: "big.bin", Body: body}}), proxy.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// At 100000 bytes per second, everything after the first 10000 bytes
+	// takes 300ms to send, well past the timeout
+	srv := newTestServer(t, 100*time.Millisecond, &config.ConfigBandwidth{BytesPerSecond: 100000, BurstBytes: 10000}, h)
+
+	start := time.Now()
+	res, err := http.Get(srv.URL + "/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	// The response is streamed as it is throttled, rather than buffered
+	// until the handler is done
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(res.Body, first); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("first byte took %v, want it before the download is throttled", d)
+	}
+
+	rest, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(first) + len(rest); got != len(body) {
+		t.Errorf("read %d bytes, want %d", got, len(body))
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("download took %v, want it throttled to at least 250ms", d)
+	}
+}
+
+func TestTimeoutBeforeResponse(t *testing.T) {
+	canceled := make(chan struct{})
+	srv := newTestServer(t, 50*time.Millisecond, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(canceled)
+		w.Header().Set("X-Late", "1")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	res, err := http.Get(srv.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusServiceUnavailable || string(body) != "timed out" {
+		t.Errorf("response = %d %q, want %d %q", res.StatusCode, body, http.StatusServiceUnavailable, "timed out")
+	}
+	if res.Header.Get("X-Late") != "" {
+		t.Error("headers of the timed out handler were sent")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("context of the timed out handler was not canceled")
+	}
+}
+
+func TestTimeoutHeadersWithoutBody(t *testing.T) {
+	srv := newTestServer(t, time.Second, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Header", "1")
+	}))
+
+	res, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-Header") != "1" {
+		t.Errorf("response = %d with X-Header %q, want %d with %q", res.StatusCode, res.Header.Get("X-Header"), http.StatusOK, "1")
+	}
+}
+
+// slowBody returns a request body that sends chunks, waiting for gap before
+// each of them.
+func slowBody(gap time.Duration, chunks ...string) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, c := range chunks {
+			time.Sleep(gap)
+			if _, err := io.WriteString(pw, c); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+func TestSlowUploadOutlastsTimeout(t *testing.T) {
+	upload := true
+	b := proxytest.NewMemoryBackend(nil)
+	h, err := proxy.NewHandler(b, proxy.Options{AllowUpload: &upload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, 100*time.Millisecond, nil, h)
+
+	// Uploads may take as long as they need while the client keeps sending
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/slow.txt", slowBody(50*time.Millisecond, "a", "b", "c", "d", "e", "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("slow upload status = %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	if obj, err := b.Get(context.Background(), "slow.txt", nil); err != nil || obj.Length != 6 {
+		t.Errorf("slow upload was not stored: %v", err)
+	}
+
+	// But not once it stops sending for longer than the timeout
+	req, err = http.NewRequest(http.MethodPut, srv.URL+"/stalled.txt", slowBody(300*time.Millisecond, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := http.DefaultClient.Do(req); err == nil {
+		res.Body.Close()
+		if res.StatusCode == http.StatusCreated {
+			t.Errorf("stalled upload status = %d", res.StatusCode)
+		}
+	}
+	if _, err := b.Stat(context.Background(), "stalled.txt"); !proxy.IsNotExist(err) {
+		t.Errorf("stalled upload was stored: %v", err)
+	}
+}
+
+// slowBackend is a MemoryBackend whose listings, after the first, and copies
+// each take delay.
+type slowBackend struct {
+	*proxytest.MemoryBackend
+	delay time.Duration
+	lists int
+}
+
+func (b *slowBackend) List(ctx context.Context, prefix string, opts proxy.ListOptions) (*proxy.ListResult, error) {
+	if b.lists++; b.lists > 1 {
+		time.Sleep(b.delay)
+	}
+	return b.MemoryBackend.List(ctx, prefix, opts)
+}
+
+func (b *slowBackend) Copy(ctx context.Context, src, dst string, opts proxy.CopyOptions) (*proxy.ObjectInfo, error) {
+	time.Sleep(b.delay)
+	return b.MemoryBackend.Copy(ctx, src, dst, opts)
+}
+
+func TestSlowWebDAVOutlastsTimeout(t *testing.T) {
+	yes := true
+	b := &slowBackend{MemoryBackend: proxytest.NewMemoryBackend(proxytest.Objects), delay: 150 * time.Millisecond}
+	h, err := proxy.NewHandler(b, proxy.Options{WebDAV: &yes, AllowUpload: &yes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, 100*time.Millisecond, nil, h)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			// The collection itself is sent before its members are listed
+			name: "propfind", method: proxy.MethodPropfind, path: "/dir/", header: http.Header{"Depth": {"1"}},
+			wantStatus: http.StatusMultiStatus, wantBody: "<D:href>/dir/b.json</D:href>",
+		},
+		{
+			// Copying each of the three objects takes longer than the timeout
+			name: "copy collection", method: proxy.MethodCopy, path: "/dir/", header: http.Header{"Destination": {"/copy/"}},
+			wantStatus: http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header = tt.header
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.wantStatus || !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("response = %d %q, want %d containing %q", res.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	newAdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/varz", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"bandwidth"`) {
+		t.Errorf("GET /varz = %d %q, want the bandwidth metrics", w.Code, w.Body.String())
+	}
+}
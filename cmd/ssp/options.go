@@ -3,6 +3,7 @@ package main
 import (
 	"io"
 	"os"
+	"time"
 
 	arg "github.com/alexflint/go-arg"
 	"github.com/rs/zerolog"
@@ -27,9 +28,19 @@ type options struct {
 	Environment string `arg:"--env,env:SSP_ENV,help:Environment name 'dev' or 'prod'"`
 	Port        int    `arg:"--port,env:SSP_PORT,help:Port to listen on"`
 
+	Sign *signOptions `arg:"subcommand:sign" help:"Print a signed URL"`
+
 	Log zerolog.Logger `arg:"-"`
 }
 
+type signOptions struct {
+	URL     string        `arg:"positional,required,help:URL to sign"`
+	KeyID   string        `arg:"--key-id,required,help:ID of the signing key"`
+	Key     string        `arg:"--key,env:SSP_SIGNING_KEY,help:Signing key as env:NAME or file:/path; defaults to the key in the config"`
+	Expires time.Duration `arg:"--expires,help:How long the URL is valid for" default:"24h"`
+	IP      string        `arg:"--ip,help:Only allow clients with this address to use the URL"`
+}
+
 func (o *options) Version() string {
 	return BuildVersion
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ripta/ssp/config"
+	"github.com/ripta/ssp/proxy/auth"
+)
+
+// sign prints a URL signed with the key named by o.KeyID. The key is taken
+// from o.Key, or else from the first handler in the config that has it.
+func sign(o *signOptions, configFile string) error {
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return errors.Wrap(err, "could not parse URL")
+	}
+	if !u.IsAbs() {
+		return errors.Errorf("URL %q must be absolute", o.URL)
+	}
+
+	var ip netip.Addr
+	if o.IP != "" {
+		if ip, err = netip.ParseAddr(o.IP); err != nil {
+			return errors.Wrap(err, "could not parse IP address")
+		}
+	}
+
+	ref := o.Key
+	if ref == "" {
+		if ref, err = findSigningKey(configFile, o.KeyID); err != nil {
+			return err
+		}
+	}
+	keys, err := auth.ResolveSigningKeys(map[string]string{o.KeyID: ref})
+	if err != nil {
+		return err
+	}
+
+	if o.Expires <= 0 {
+		return errors.New("--expires must be positive")
+	}
+	fmt.Println(auth.SignURL(u, o.KeyID, keys[o.KeyID], time.Now().Add(o.Expires), ip))
+	return nil
+}
+
+// findSigningKey returns the reference to the signing key with ID id in the
+// config.
+func findSigningKey(configFile, id string) (string, error) {
+	if configFile == "" {
+		return "", errors.New("either --key or --config is required")
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return "", errors.Wrap(err, "could not load config")
+	}
+	for _, ch := range cfg.Handlers {
+		if ref, ok := ch.SigningKeys[id]; ok {
+			return ref, nil
+		}
+	}
+	return "", errors.Errorf("no handler in %s has signing key %q", configFile, id)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// timeoutHandler responds with 503 Service Unavailable and msg to requests
+// whose handler has not started responding within dt, and cancels their
+// context. Unlike http.TimeoutHandler, responses are not buffered, so once
+// they have started, they are streamed for as long as they take, e.g., at a
+// throttled bandwidth.
+//
+// Requests that may change objects, such as uploads, only respond once they
+// are done, so they are instead timed out when the client stops sending the
+// request body for dt.
+func timeoutHandler(dt time.Duration, msg string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if proxy.IsWriteMethod(r.Method) {
+				r.Body = &idleTimeoutReader{ReadCloser: r.Body, rc: http.NewResponseController(w), d: dt}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, header: http.Header{}}
+			fired := make(chan struct{})
+			t := time.AfterFunc(dt, func() {
+				defer close(fired)
+				if tw.timeout(msg) {
+					cancel()
+				}
+			})
+			stop := sync.OnceFunc(func() {
+				// Wait for the timeout response to be written, if it is being
+				if !t.Stop() {
+					<-fired
+				}
+			})
+			defer stop()
+
+			h.ServeHTTP(tw, r.WithContext(ctx))
+			stop()
+			// Send the headers of handlers that wrote no body
+			tw.start(http.StatusOK)
+		})
+	}
+}
+
+// timeoutWriter passes a response through, unless the timeout was reached
+// before it started. Headers are kept apart until then, so that they are not
+// changed by the handler while the timeout response is being written.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu       sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// start sends the response headers with code, unless they were sent already,
+// and reports whether the response may be written.
+func (tw *timeoutWriter) start(code int) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return false
+	}
+	if !tw.started {
+		tw.started = true
+		dst := tw.w.Header()
+		for k, vs := range tw.header {
+			dst[k] = vs
+		}
+		tw.w.WriteHeader(code)
+	}
+	return true
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	// Informational responses do not start the final response
+	if code >= 100 && code < 200 {
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if !tw.started && !tw.timedOut {
+			tw.w.WriteHeader(code)
+		}
+		return
+	}
+	tw.start(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	if !tw.start(http.StatusOK) {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	if !tw.start(http.StatusOK) {
+		return
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// timeout responds with 503 Service Unavailable and msg, unless the response
+// has already started, and reports whether it did.
+func (tw *timeoutWriter) timeout(msg string) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.started {
+		return false
+	}
+	tw.timedOut = true
+	tw.w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(tw.w, msg)
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return true
+}
+
+// idleTimeoutReader is a request body whose reads fail once the client has
+// not sent anything for d.
+type idleTimeoutReader struct {
+	io.ReadCloser
+	rc *http.ResponseController
+	d  time.Duration
+}
+
+func (ir *idleTimeoutReader) Read(p []byte) (int, error) {
+	// Writers that cannot set deadlines are read without them
+	ir.rc.SetReadDeadline(time.Now().Add(ir.d))
+	n, err := ir.ReadCloser.Read(p)
+	if err == io.EOF {
+		// Once the whole body has been read, the deadline would otherwise
+		// cancel the request while the handler finishes up
+		ir.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/auth"
+	"github.com/ripta/ssp/proxy/origin"
+)
+
+// ConfigAuth controls how requests to a handler are authenticated. A handler
+// with no authentication methods configured is public.
+type ConfigAuth struct {
+	Realm string `json:"realm,omitempty" yaml:"realm,omitempty"`
+
+	// Htpasswd is a local htpasswd file, or "backend:" followed by the key of
+	// one in the handler's backend.
+	Htpasswd       string         `json:"htpasswd,omitempty" yaml:"htpasswd,omitempty"`
+	ReloadInterval *time.Duration `json:"reload_interval,omitempty" yaml:"reload_interval,omitempty"`
+
+	OIDC *ConfigOIDC `json:"oidc,omitempty" yaml:"oidc,omitempty"`
+	JWT  *ConfigJWT  `json:"jwt,omitempty" yaml:"jwt,omitempty"`
+
+	ClientCert *ConfigClientCert `json:"client_cert,omitempty" yaml:"client_cert,omitempty"`
+}
+
+// ConfigOIDC configures single sign-on with an OpenID Connect issuer. Secrets
+// are references, i.e., "env:NAME" or "file:/path".
+type ConfigOIDC struct {
+	Issuer       string   `json:"issuer" yaml:"issuer"`
+	ClientID     string   `json:"client_id" yaml:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty" yaml:"client_secret,omitempty"`
+	RedirectURL  string   `json:"redirect_url" yaml:"redirect_url"`
+	Scopes       []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+
+	CookieName      string         `json:"cookie_name,omitempty" yaml:"cookie_name,omitempty"`
+	CookieSecret    string         `json:"cookie_secret" yaml:"cookie_secret"`
+	SessionDuration *time.Duration `json:"session_duration,omitempty" yaml:"session_duration,omitempty"`
+
+	AllowedDomains []string `json:"allowed_domains,omitempty" yaml:"allowed_domains,omitempty"`
+	AllowedEmails  []string `json:"allowed_emails,omitempty" yaml:"allowed_emails,omitempty"`
+	AllowedGroups  []string `json:"allowed_groups,omitempty" yaml:"allowed_groups,omitempty"`
+	GroupsClaim    string   `json:"groups_claim,omitempty" yaml:"groups_claim,omitempty"`
+}
+
+// config returns the settings of single sign-on with o.
+func (o *ConfigOIDC) config() auth.OIDCConfig {
+	c := auth.OIDCConfig{
+		Issuer:         o.Issuer,
+		ClientID:       o.ClientID,
+		ClientSecret:   o.ClientSecret,
+		RedirectURL:    o.RedirectURL,
+		Scopes:         o.Scopes,
+		CookieName:     o.CookieName,
+		CookieSecret:   o.CookieSecret,
+		AllowedDomains: o.AllowedDomains,
+		AllowedEmails:  o.AllowedEmails,
+		AllowedGroups:  o.AllowedGroups,
+		GroupsClaim:    o.GroupsClaim,
+	}
+	if d := o.SessionDuration; d != nil {
+		c.SessionDuration = *d
+	}
+	return c
+}
+
+// ConfigJWT configures bearer tokens. Keys are reloaded every
+// reload_interval, like htpasswd files.
+type ConfigJWT struct {
+	// Keys are local PEM or JWKS files, or JWKS URLs.
+	Keys       []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	HMACSecret string   `json:"hmac_secret,omitempty" yaml:"hmac_secret,omitempty"`
+
+	Issuer    string         `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+	Audience  string         `json:"audience,omitempty" yaml:"audience,omitempty"`
+	ClockSkew *time.Duration `json:"clock_skew,omitempty" yaml:"clock_skew,omitempty"`
+
+	// Prefixes restrict users to keys under them, after substituting
+	// "{claim}" with claims from the token.
+	Prefixes []string `json:"prefixes,omitempty" yaml:"prefixes,omitempty"`
+}
+
+// ConfigClientCert configures TLS client certificates, which are only
+// presented when HTTPS is served natively, and client certificates are
+// requested for the handler's host.
+type ConfigClientCert struct {
+	CAFile string `json:"ca_file" yaml:"ca_file"`
+
+	CommonNames         []string `json:"common_names,omitempty" yaml:"common_names,omitempty"`
+	SANs                []string `json:"sans,omitempty" yaml:"sans,omitempty"`
+	OrganizationalUnits []string `json:"organizational_units,omitempty" yaml:"organizational_units,omitempty"`
+
+	// Prefixes restrict clients to keys under them, after substituting
+	// "{cn}", "{ou}" and the like with names from the certificate.
+	Prefixes []string `json:"prefixes,omitempty" yaml:"prefixes,omitempty"`
+}
+
+// backendOptions returns the options of the handler's backends, in which an
+// htpasswd file stored alongside the objects they serve is kept private.
+func (ch *ConfigHandler) backendOptions() proxy.Options {
+	opts := ch.Options
+	if ch.Auth == nil {
+		return opts
+	}
+	if key, ok := strings.CutPrefix(ch.Auth.Htpasswd, auth.BackendPrefix); ok {
+		opts.PrivateKeys = append(slices.Clip(opts.PrivateKeys), strings.TrimPrefix(key, "/"))
+	}
+	return opts
+}
+
+// credentials returns what clients authenticate to the handler with, which is
+// not forwarded to HTTP origins.
+func (ch *ConfigHandler) credentials() origin.Credentials {
+	var c origin.Credentials
+	if len(ch.SigningKeys) > 0 {
+		c.Query = auth.SignedURLParams
+	}
+	if ch.Auth == nil || ch.RequireSignature != nil && *ch.RequireSignature {
+		return c
+	}
+	if ch.Auth.Htpasswd != "" || ch.Auth.JWT != nil {
+		c.Headers = []string{"Authorization"}
+	}
+	if o := ch.Auth.OIDC; o != nil {
+		c.Cookies = o.config().CookieNames()
+	}
+	return c
+}
+
+// newAuthenticator returns the authenticator for requests to the handler, or
+// nil when it is public. Credentials stored in a bucket are loaded from b.
+// Handlers that require signatures only accept signed URLs, whatever other
+// authentication is configured.
+func (ch *ConfigHandler) newAuthenticator(b proxy.Backend) (auth.Authenticator, error) {
+	if ch.RequireSignature != nil && *ch.RequireSignature {
+		return ch.newSignedURL()
+	}
+
+	as, err := ch.Auth.newAuthenticators(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(as) == 0 {
+		return nil, nil
+	}
+
+	if len(ch.SigningKeys) > 0 {
+		a, err := ch.newSignedURL()
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+	return auth.Any(as...), nil
+}
+
+// newSignedURL returns the authenticator of URLs signed with the handler's
+// signing keys.
+func (ch *ConfigHandler) newSignedURL() (auth.Authenticator, error) {
+	keys, err := auth.ResolveSigningKeys(ch.SigningKeys)
+	if err != nil {
+		return nil, err
+	}
+	a, err := auth.NewSignedURL(keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize signed URLs")
+	}
+	return a, nil
+}
+
+// newAuthenticators returns the authentication methods configured in ca.
+// When several are configured, the first of single sign-on, htpasswd, bearer
+// tokens and client certificates challenges users without credentials, while
+// clients may still send credentials for any of the others.
+func (ca *ConfigAuth) newAuthenticators(b proxy.Backend) ([]auth.Authenticator, error) {
+	if ca == nil {
+		return nil, nil
+	}
+
+	var as []auth.Authenticator
+	if o := ca.OIDC; o != nil {
+		a, err := auth.NewOIDC(o.config())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize oidc authentication")
+		}
+		as = append(as, a)
+	}
+
+	if ca.Htpasswd != "" {
+		c := auth.BasicConfig{
+			Realm:    ca.Realm,
+			Htpasswd: ca.Htpasswd,
+			Backend:  b,
+		}
+		if d := ca.ReloadInterval; d != nil {
+			c.ReloadInterval = *d
+		}
+		a, err := auth.NewBasic(c)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize basic authentication")
+		}
+		as = append(as, a)
+	}
+
+	if j := ca.JWT; j != nil {
+		c := auth.JWTConfig{
+			Realm:      ca.Realm,
+			Keys:       j.Keys,
+			HMACSecret: j.HMACSecret,
+			Issuer:     j.Issuer,
+			Audience:   j.Audience,
+			Prefixes:   j.Prefixes,
+		}
+		if d := ca.ReloadInterval; d != nil {
+			c.ReloadInterval = *d
+		}
+		if d := j.ClockSkew; d != nil {
+			c.ClockSkew = *d
+		}
+		a, err := auth.NewJWT(c)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize jwt authentication")
+		}
+		as = append(as, a)
+	}
+
+	if cc := ca.ClientCert; cc != nil {
+		a, err := auth.NewClientCert(auth.ClientCertConfig{
+			CAFile:              cc.CAFile,
+			CommonNames:         cc.CommonNames,
+			SANs:                cc.SANs,
+			OrganizationalUnits: cc.OrganizationalUnits,
+			Prefixes:            cc.Prefixes,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize client certificate authentication")
+		}
+		as = append(as, a)
+	}
+
+	return as, nil
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/azure"
+	"github.com/ripta/ssp/proxy/gcs"
+	"github.com/ripta/ssp/proxy/origin"
+	"github.com/ripta/ssp/proxy/s3"
+)
+
+// ConfigBackend holds the settings of all kinds of backends. Backends are
+// enabled by the presence of their settings, e.g., gcs_bucket.
+type ConfigBackend struct {
+	// Name identifies the backend in failover response headers.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	AzureAccount          string `json:"azure_account,omitempty" yaml:"azure_account,omitempty"`
+	AzureContainer        string `json:"azure_container,omitempty" yaml:"azure_container,omitempty"`
+	AzurePrefix           string `json:"azure_prefix,omitempty" yaml:"azure_prefix,omitempty"`
+	AzureEndpoint         string `json:"azure_endpoint,omitempty" yaml:"azure_endpoint,omitempty"`
+	AzureAccountKey       string `json:"azure_account_key,omitempty" yaml:"azure_account_key,omitempty"`
+	AzureSASToken         string `json:"azure_sas_token,omitempty" yaml:"azure_sas_token,omitempty"`
+	AzureConnectionString string `json:"azure_connection_string,omitempty" yaml:"azure_connection_string,omitempty"`
+
+	HTTPOrigin                      string               `json:"http_origin,omitempty" yaml:"http_origin,omitempty"`
+	HTTPOriginPrefix                string               `json:"http_origin_prefix,omitempty" yaml:"http_origin_prefix,omitempty"`
+	HTTPOriginDialTimeout           *time.Duration       `json:"http_origin_dial_timeout,omitempty" yaml:"http_origin_dial_timeout,omitempty"`
+	HTTPOriginResponseHeaderTimeout *time.Duration       `json:"http_origin_response_header_timeout,omitempty" yaml:"http_origin_response_header_timeout,omitempty"`
+	HTTPOriginRequestHeaders        *origin.HeaderFilter `json:"http_origin_request_headers,omitempty" yaml:"http_origin_request_headers,omitempty"`
+	HTTPOriginResponseHeaders       *origin.HeaderFilter `json:"http_origin_response_headers,omitempty" yaml:"http_origin_response_headers,omitempty"`
+
+	GCSBucket  string `json:"gcs_bucket,omitempty" yaml:"gcs_bucket,omitempty"`
+	GCSPrefix  string `json:"gcs_prefix,omitempty" yaml:"gcs_prefix,omitempty"`
+	GCSKeyFile string `json:"gcs_key_file,omitempty" yaml:"gcs_key_file,omitempty"`
+	S3Bucket   string `json:"s3_bucket,omitempty" yaml:"s3_bucket,omitempty"`
+	S3Prefix   string `json:"s3_prefix,omitempty" yaml:"s3_prefix,omitempty"`
+	S3Region   string `json:"s3_region,omitempty" yaml:"s3_region,omitempty"`
+
+	S3Endpoint           string `json:"s3_endpoint,omitempty" yaml:"s3_endpoint,omitempty"`
+	S3ForcePathStyle     *bool  `json:"s3_force_path_style,omitempty" yaml:"s3_force_path_style,omitempty"`
+	S3CAFile             string `json:"s3_ca_file,omitempty" yaml:"s3_ca_file,omitempty"`
+	S3InsecureSkipVerify *bool  `json:"s3_insecure_skip_verify,omitempty" yaml:"s3_insecure_skip_verify,omitempty"`
+
+	S3Profile               string `json:"s3_profile,omitempty" yaml:"s3_profile,omitempty"`
+	S3AccessKeyID           string `json:"s3_access_key_id,omitempty" yaml:"s3_access_key_id,omitempty"`
+	S3SecretAccessKey       string `json:"s3_secret_access_key,omitempty" yaml:"s3_secret_access_key,omitempty"`
+	S3AssumeRoleARN         string `json:"s3_assume_role_arn,omitempty" yaml:"s3_assume_role_arn,omitempty"`
+	S3AssumeRoleExternalID  string `json:"s3_assume_role_external_id,omitempty" yaml:"s3_assume_role_external_id,omitempty"`
+	S3AssumeRoleSessionName string `json:"s3_assume_role_session_name,omitempty" yaml:"s3_assume_role_session_name,omitempty"`
+}
+
+func (cb *ConfigBackend) setDefaults(d *ConfigBackend) {
+	if cb.AzureAccount == "" {
+		cb.AzureAccount = d.AzureAccount
+	}
+	if cb.AzureContainer == "" {
+		cb.AzureContainer = d.AzureContainer
+	}
+	if cb.AzurePrefix == "" {
+		cb.AzurePrefix = d.AzurePrefix
+	}
+	if cb.AzureEndpoint == "" {
+		cb.AzureEndpoint = d.AzureEndpoint
+	}
+	if cb.AzureAccountKey == "" {
+		cb.AzureAccountKey = d.AzureAccountKey
+	}
+	if cb.AzureSASToken == "" {
+		cb.AzureSASToken = d.AzureSASToken
+	}
+	if cb.AzureConnectionString == "" {
+		cb.AzureConnectionString = d.AzureConnectionString
+	}
+
+	if cb.GCSBucket == "" {
+		cb.GCSBucket = d.GCSBucket
+	}
+	if cb.GCSKeyFile == "" {
+		cb.GCSKeyFile = d.GCSKeyFile
+	}
+	if cb.GCSPrefix == "" {
+		cb.GCSPrefix = d.GCSPrefix
+	}
+
+	if cb.HTTPOrigin == "" {
+		cb.HTTPOrigin = d.HTTPOrigin
+	}
+	if cb.HTTPOriginPrefix == "" {
+		cb.HTTPOriginPrefix = d.HTTPOriginPrefix
+	}
+	if cb.HTTPOriginDialTimeout == nil {
+		cb.HTTPOriginDialTimeout = d.HTTPOriginDialTimeout
+	}
+	if cb.HTTPOriginResponseHeaderTimeout == nil {
+		cb.HTTPOriginResponseHeaderTimeout = d.HTTPOriginResponseHeaderTimeout
+	}
+	if cb.HTTPOriginRequestHeaders == nil {
+		cb.HTTPOriginRequestHeaders = d.HTTPOriginRequestHeaders
+	}
+	if cb.HTTPOriginResponseHeaders == nil {
+		cb.HTTPOriginResponseHeaders = d.HTTPOriginResponseHeaders
+	}
+
+	if cb.S3Bucket == "" {
+		cb.S3Bucket = d.S3Bucket
+	}
+	if cb.S3Prefix == "" {
+		cb.S3Prefix = d.S3Prefix
+	}
+	if cb.S3Region == "" {
+		cb.S3Region = d.S3Region
+	}
+
+	if cb.S3Endpoint == "" {
+		cb.S3Endpoint = d.S3Endpoint
+	}
+	if cb.S3ForcePathStyle == nil {
+		cb.S3ForcePathStyle = d.S3ForcePathStyle
+	}
+	if cb.S3CAFile == "" {
+		cb.S3CAFile = d.S3CAFile
+	}
+	if cb.S3InsecureSkipVerify == nil {
+		cb.S3InsecureSkipVerify = d.S3InsecureSkipVerify
+	}
+
+	if cb.S3Profile == "" {
+		cb.S3Profile = d.S3Profile
+	}
+	if cb.S3AccessKeyID == "" {
+		cb.S3AccessKeyID = d.S3AccessKeyID
+	}
+	if cb.S3SecretAccessKey == "" {
+		cb.S3SecretAccessKey = d.S3SecretAccessKey
+	}
+	if cb.S3AssumeRoleARN == "" {
+		cb.S3AssumeRoleARN = d.S3AssumeRoleARN
+	}
+	if cb.S3AssumeRoleExternalID == "" {
+		cb.S3AssumeRoleExternalID = d.S3AssumeRoleExternalID
+	}
+	if cb.S3AssumeRoleSessionName == "" {
+		cb.S3AssumeRoleSessionName = d.S3AssumeRoleSessionName
+	}
+}
+
+// newHandlers creates one request handler for each kind of backend configured
+// in cb, in a stable order.
+func (cb *ConfigBackend) newHandlers(ch *ConfigHandler) ([]proxy.NamedHandler, error) {
+	var hs []proxy.NamedHandler
+	if cb.S3Region != "" || cb.S3Endpoint != "" {
+		b, err := s3.NewBackend(cb.s3Config())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize S3 request handler")
+		}
+		h, err := proxy.NewHandler(b, ch.backendOptions())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize S3 request handler")
+		}
+		h = ch.keyHandler(h, cb.S3Prefix)
+		hs = append(hs, proxy.NamedHandler{Name: cb.name("s3", cb.S3Bucket), Handler: h, Backend: b})
+	}
+	if cb.GCSBucket != "" {
+		b, err := gcs.NewBackend(cb.GCSBucket, cb.GCSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize GCS request handler")
+		}
+		h, err := proxy.NewHandler(b, ch.backendOptions())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize GCS request handler")
+		}
+		h = ch.keyHandler(h, cb.GCSPrefix)
+		hs = append(hs, proxy.NamedHandler{Name: cb.name("gcs", cb.GCSBucket), Handler: h, Backend: b})
+	}
+	if cb.AzureContainer != "" {
+		b, err := azure.NewBackend(cb.azureConfig())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize Azure request handler")
+		}
+		h, err := proxy.NewHandler(b, ch.backendOptions())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize Azure request handler")
+		}
+		h = ch.keyHandler(h, cb.AzurePrefix)
+		hs = append(hs, proxy.NamedHandler{Name: cb.name("azure", cb.AzureContainer), Handler: h, Backend: b})
+	}
+	if cb.HTTPOrigin != "" {
+		oc := cb.originConfig()
+		oc.Credentials = ch.credentials()
+		h, err := origin.NewHandler(oc)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not initialize HTTP origin request handler")
+		}
+		h = ch.keyHandler(h, cb.HTTPOriginPrefix)
+		hs = append(hs, proxy.NamedHandler{Name: cb.name("http_origin", cb.HTTPOrigin), Handler: h})
+	}
+	return hs, nil
+}
+
+// name identifies a backend in responses and logs, preferring its configured name.
+func (cb *ConfigBackend) name(kind, id string) string {
+	if cb.Name != "" {
+		return cb.Name
+	}
+	return kind + ":" + id
+}
+
+func (cb *ConfigBackend) azureConfig() azure.Config {
+	return azure.Config{
+		Account:          cb.AzureAccount,
+		Container:        cb.AzureContainer,
+		Endpoint:         cb.AzureEndpoint,
+		AccountKey:       cb.AzureAccountKey,
+		SASToken:         cb.AzureSASToken,
+		ConnectionString: cb.AzureConnectionString,
+	}
+}
+
+func (cb *ConfigBackend) originConfig() origin.Config {
+	c := origin.Config{
+		URL: cb.HTTPOrigin,
+	}
+	if t := cb.HTTPOriginDialTimeout; t != nil {
+		c.DialTimeout = *t
+	}
+	if t := cb.HTTPOriginResponseHeaderTimeout; t != nil {
+		c.ResponseHeaderTimeout = *t
+	}
+	if f := cb.HTTPOriginRequestHeaders; f != nil {
+		c.RequestHeaders = *f
+	}
+	if f := cb.HTTPOriginResponseHeaders; f != nil {
+		c.ResponseHeaders = *f
+	}
+	return c
+}
+
+func (cb *ConfigBackend) s3Config() s3.Config {
+	return s3.Config{
+		Region:             cb.S3Region,
+		Bucket:             cb.S3Bucket,
+		Endpoint:           cb.S3Endpoint,
+		ForcePathStyle:     isTrue(cb.S3ForcePathStyle),
+		CAFile:             cb.S3CAFile,
+		InsecureSkipVerify: isTrue(cb.S3InsecureSkipVerify),
+
+		Profile:               cb.S3Profile,
+		AccessKeyID:           cb.S3AccessKeyID,
+		SecretAccessKey:       cb.S3SecretAccessKey,
+		AssumeRoleARN:         cb.S3AssumeRoleARN,
+		AssumeRoleExternalID:  cb.S3AssumeRoleExternalID,
+		AssumeRoleSessionName: cb.S3AssumeRoleSessionName,
+	}
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/ripta/ssp/proxy/bandwidth"
+)
+
+// ConfigBandwidth limits how fast objects are downloaded, in bytes per
+// second. Directory listings and archives are not limited.
+type ConfigBandwidth struct {
+	// BytesPerSecond is shared by all downloads, while
+	// ClientBytesPerSecond is shared by all downloads of each client
+	// address, however many it makes at once.
+	BytesPerSecond       int64 `json:"bytes_per_second,omitempty" yaml:"bytes_per_second,omitempty"`
+	ClientBytesPerSecond int64 `json:"client_bytes_per_second,omitempty" yaml:"client_bytes_per_second,omitempty"`
+
+	// BurstBytes is how much may be sent at full speed before downloads are
+	// throttled, which defaults to one second's worth, and which refills
+	// while they are idle.
+	BurstBytes int64 `json:"burst_bytes,omitempty" yaml:"burst_bytes,omitempty"`
+}
+
+// NewLimit returns a bandwidth limit whose metrics are published under name,
+// or nil when there is none.
+func (cb *ConfigBandwidth) NewLimit(name string) (*bandwidth.Limit, error) {
+	if cb == nil || (cb.BytesPerSecond == 0 && cb.ClientBytesPerSecond == 0) {
+		return nil, nil
+	}
+	l, err := bandwidth.New(name, bandwidth.Config{
+		Rate:       cb.BytesPerSecond,
+		ClientRate: cb.ClientBytesPerSecond,
+		Burst:      cb.BurstBytes,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not configure bandwidth")
+	}
+	return l, nil
+}
+
+// limitBandwidth throttles downloads from h by l, unless it is nil.
+func limitBandwidth(l *bandwidth.Limit, h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return l.Handler(h)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -10,8 +11,10 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/ripta/ssp/proxy"
-	"github.com/ripta/ssp/proxy/gcs"
-	"github.com/ripta/ssp/proxy/s3"
+	"github.com/ripta/ssp/proxy/audit"
+	"github.com/ripta/ssp/proxy/auth"
+	"github.com/ripta/ssp/proxy/clientip"
+	"github.com/ripta/ssp/proxy/ratelimit"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -28,13 +31,40 @@ type ConfigHandler struct {
 	Path       string `json:"path,omitempty" yaml:"path,omitempty"`
 	PathPrefix string `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
 
-	GCSBucket  string `json:"gcs_bucket,omitempty" yaml:"gcs_bucket,omitempty"`
-	GCSPrefix  string `json:"gcs_prefix,omitempty" yaml:"gcs_prefix,omitempty"`
-	GCSKeyFile string `json:"gcs_key_file,omitempty" yaml:"gcs_key_file,omitempty"`
-	S3Bucket   string `json:"s3_bucket,omitempty" yaml:"s3_bucket,omitempty"`
-	S3Prefix   string `json:"s3_prefix,omitempty" yaml:"s3_prefix,omitempty"`
-	S3Region   string `json:"s3_region,omitempty" yaml:"s3_region,omitempty"`
+	// Backends, when not empty, is an ordered list of backends that are tried
+	// in turn until one of them returns a non-failover status. The handler's
+	// own backend settings are ignored in that case.
+	Backends         []*ConfigBackend `json:"backends,omitempty" yaml:"backends,omitempty"`
+	FailoverStatuses []int            `json:"failover_statuses,omitempty" yaml:"failover_statuses,omitempty"`
+	BackendHeader    string           `json:"backend_header,omitempty" yaml:"backend_header,omitempty"`
 
+	// AllowIPs, when not empty, are the only addresses or CIDRs from which
+	// clients may use the handler, while DenyIPs are those from which they
+	// may not.
+	AllowIPs []string `json:"allow_ips,omitempty" yaml:"allow_ips,omitempty"`
+	DenyIPs  []string `json:"deny_ips,omitempty" yaml:"deny_ips,omitempty"`
+
+	Auth *ConfigAuth `json:"auth,omitempty" yaml:"auth,omitempty"`
+
+	// SigningKeys are references to the keys that sign URLs, by key ID.
+	// Signed URLs are accepted alongside any other authentication, and when
+	// RequireSignature is set, the handler is only served through them, and
+	// Auth is ignored.
+	SigningKeys      map[string]string `json:"signing_keys,omitempty" yaml:"signing_keys,omitempty"`
+	RequireSignature *bool             `json:"require_signature,omitempty" yaml:"require_signature,omitempty"`
+
+	RateLimit *ConfigRateLimit `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	Bandwidth *ConfigBandwidth `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
+
+	// WritePrefixes, when not empty, are the only key prefixes under which
+	// users may upload or delete objects, after substituting "{user}" with
+	// the authenticated user.
+	WritePrefixes []string `json:"write_prefixes,omitempty" yaml:"write_prefixes,omitempty"`
+	// AuditLog is a file to which a record of every upload and delete is
+	// appended. By default, they are written to the log.
+	AuditLog string `json:"audit_log,omitempty" yaml:"audit_log,omitempty"`
+
+	ConfigBackend `yaml:",inline"`
 	proxy.Options `yaml:",inline"`
 }
 
@@ -44,13 +74,53 @@ type ConfigRoot struct {
 
 	Cache CacheSettings `json:"cache_settings,omitempty" yaml:"cache_settings,omitempty"`
 	Proxy ProxySettings `json:"proxy_settings,omitempty" yaml:"proxy_settings,omitempty"`
+	TLS   *ConfigTLS    `json:"tls,omitempty" yaml:"tls,omitempty"`
 
 	Debug bool `json:"debug,omitempty" yaml:"debug,omitempty"`
 }
 
+// DefaultTimeout is how long handlers may take to start responding, unless
+// configured otherwise.
+const DefaultTimeout = 10 * time.Second
+
 type ProxySettings struct {
+	// TimeoutDuration is how long handlers may take to start responding,
+	// and clients to send request headers. Responses that have started are
+	// streamed for as long as they take.
 	TimeoutDuration       *time.Duration `json:"timeout_duration,omitempty" yaml:"timeout_duration,omitempty"`
 	TrustForwardedHeaders *bool          `json:"trust_forwarded_headers,omitempty" yaml:"trust_forwarded_headers,omitempty"`
+
+	// AdminAddress, when set, is the address, e.g., "127.0.0.1:9090", on
+	// which metrics are served at /varz. It is kept apart from the handlers
+	// so that it need not be reachable by their clients.
+	AdminAddress string `json:"admin_address,omitempty" yaml:"admin_address,omitempty"`
+
+	// TrustedProxies are the addresses or CIDRs of proxies, such as load
+	// balancers, that are believed about the clients they forward requests
+	// for.
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"`
+
+	// RateLimit and Bandwidth limit clients across all handlers, in addition
+	// to the limits of each handler.
+	RateLimit *ConfigRateLimit `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	Bandwidth *ConfigBandwidth `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
+}
+
+// Timeout returns how long handlers may take to start responding.
+func (ps *ProxySettings) Timeout() time.Duration {
+	if ps.TimeoutDuration == nil {
+		return DefaultTimeout
+	}
+	return *ps.TimeoutDuration
+}
+
+// validate returns why the proxy settings cannot be used. Since global limits
+// apply before any handler authenticates users, they cannot tell users apart.
+func (ps *ProxySettings) validate() error {
+	if ps.RateLimit != nil && ratelimit.Key(ps.RateLimit.Key) == ratelimit.KeyUser {
+		return errors.New(`proxy_settings.rate_limit cannot use key "user", as users are not authenticated yet; set it in the rate_limit of handlers instead`)
+	}
+	return nil
 }
 
 func Load(filename string) (*ConfigRoot, error) {
@@ -64,6 +134,9 @@ func Load(filename string) (*ConfigRoot, error) {
 		return nil, err
 	}
 
+	if err := cfg.Proxy.validate(); err != nil {
+		return nil, err
+	}
 	for _, ch := range cfg.Handlers {
 		ch.setDefaults(cfg.Defaults)
 	}
@@ -78,6 +151,63 @@ func (ch *ConfigHandler) setDefaults(d *ConfigHandler) {
 	if ch.Autoindex == nil {
 		ch.Autoindex = d.Autoindex
 	}
+	if ch.AutoindexPageSize == nil {
+		ch.AutoindexPageSize = d.AutoindexPageSize
+	}
+	if ch.AutoindexTemplate == "" {
+		ch.AutoindexTemplate = d.AutoindexTemplate
+	}
+	if len(ch.AutoindexHeader) == 0 {
+		ch.AutoindexHeader = d.AutoindexHeader
+	}
+	if len(ch.AutoindexReadme) == 0 {
+		ch.AutoindexReadme = d.AutoindexReadme
+	}
+	if len(ch.AutoindexHide) == 0 {
+		ch.AutoindexHide = d.AutoindexHide
+	}
+	if ch.AutoindexHideDotfiles == nil {
+		ch.AutoindexHideDotfiles = d.AutoindexHideDotfiles
+	}
+	if ch.AutoindexDirsFirst == nil {
+		ch.AutoindexDirsFirst = d.AutoindexDirsFirst
+	}
+	if ch.AutoindexSort == "" {
+		ch.AutoindexSort = d.AutoindexSort
+	}
+	if ch.AutoindexOrder == "" {
+		ch.AutoindexOrder = d.AutoindexOrder
+	}
+	if ch.AutoindexArchive == nil {
+		ch.AutoindexArchive = d.AutoindexArchive
+	}
+	if ch.AutoindexArchiveMaxObjects == nil {
+		ch.AutoindexArchiveMaxObjects = d.AutoindexArchiveMaxObjects
+	}
+	if ch.AutoindexArchiveMaxBytes == nil {
+		ch.AutoindexArchiveMaxBytes = d.AutoindexArchiveMaxBytes
+	}
+	if ch.AllowUpload == nil {
+		ch.AllowUpload = d.AllowUpload
+	}
+	if ch.UploadMaxBytes == nil {
+		ch.UploadMaxBytes = d.UploadMaxBytes
+	}
+	if ch.AllowOverwrite == nil {
+		ch.AllowOverwrite = d.AllowOverwrite
+	}
+	if ch.AllowDelete == nil {
+		ch.AllowDelete = d.AllowDelete
+	}
+	if ch.UploadPostPolicy == nil {
+		ch.UploadPostPolicy = d.UploadPostPolicy
+	}
+	if ch.AutoindexUploadForm == nil {
+		ch.AutoindexUploadForm = d.AutoindexUploadForm
+	}
+	if ch.WebDAV == nil {
+		ch.WebDAV = d.WebDAV
+	}
 	if ch.Host == "" {
 		ch.Host = d.Host
 	}
@@ -91,51 +221,227 @@ func (ch *ConfigHandler) setDefaults(d *ConfigHandler) {
 		ch.PathPrefix = d.PathPrefix
 	}
 
-	if ch.GCSBucket == "" {
-		ch.GCSBucket = d.GCSBucket
+	if len(ch.FailoverStatuses) == 0 {
+		ch.FailoverStatuses = d.FailoverStatuses
 	}
-	if ch.GCSKeyFile == "" {
-		ch.GCSKeyFile = d.GCSKeyFile
+	if ch.BackendHeader == "" {
+		ch.BackendHeader = d.BackendHeader
 	}
-	if ch.GCSPrefix == "" {
-		ch.GCSPrefix = d.GCSPrefix
+	if len(ch.AllowIPs) == 0 {
+		ch.AllowIPs = d.AllowIPs
 	}
-
-	if ch.S3Bucket == "" {
-		ch.S3Bucket = d.S3Bucket
+	if len(ch.DenyIPs) == 0 {
+		ch.DenyIPs = d.DenyIPs
+	}
+	if ch.Auth == nil {
+		ch.Auth = d.Auth
+	}
+	if len(ch.SigningKeys) == 0 {
+		ch.SigningKeys = d.SigningKeys
+	}
+	if ch.RequireSignature == nil {
+		ch.RequireSignature = d.RequireSignature
+	}
+	if ch.RateLimit == nil {
+		ch.RateLimit = d.RateLimit
 	}
-	if ch.S3Prefix == "" {
-		ch.S3Prefix = d.S3Prefix
+	if ch.Bandwidth == nil {
+		ch.Bandwidth = d.Bandwidth
 	}
-	if ch.S3Region == "" {
-		ch.S3Region = d.S3Region
+	if len(ch.WritePrefixes) == 0 {
+		ch.WritePrefixes = d.WritePrefixes
 	}
+	if ch.AuditLog == "" {
+		ch.AuditLog = d.AuditLog
+	}
+
+	ch.ConfigBackend.setDefaults(&d.ConfigBackend)
 }
 
 func (ch *ConfigHandler) InjectRoute(r *mux.Router) error {
-	if ch.S3Region != "" {
-		h, err := s3.NewHandler(ch.S3Region, ch.S3Bucket, ch.Options)
+	if len(ch.Backends) == 0 {
+		hs, err := ch.ConfigBackend.newHandlers(ch)
+		if err != nil {
+			return err
+		}
+		a, err := ch.newAuthenticator(firstBackend(hs))
 		if err != nil {
-			return errors.Wrap(err, "could not initialize S3 request handler")
+			return err
 		}
-		if ch.S3Prefix != "" {
-			h = ch.rewriteHandler(h)
+		if err := ch.checkWrites(a, hs); err != nil {
+			return err
 		}
-		ch.buildRoute(r).Handler(h)
+		g, err := ch.newGuard(a)
+		if err != nil {
+			return err
+		}
+		for _, nh := range hs {
+			ch.buildRoute(r).Handler(g(nh.Handler))
+		}
+		return nil
 	}
-	if ch.GCSBucket != "" {
-		h, err := gcs.NewHandler(ch.GCSBucket, ch.GCSKeyFile, ch.Options)
+
+	var fbs []proxy.NamedHandler
+	for _, cb := range ch.Backends {
+		hs, err := cb.newHandlers(ch)
 		if err != nil {
-			return errors.Wrap(err, "could not initialize GCS request handler")
+			return err
 		}
-		if ch.GCSPrefix != "" {
-			h = ch.rewriteHandler(h)
+		fbs = append(fbs, hs...)
+	}
+	if len(fbs) == 0 {
+		return errors.New("none of the backends are configured")
+	}
+	a, err := ch.newAuthenticator(firstBackend(fbs))
+	if err != nil {
+		return err
+	}
+	if err := ch.checkWrites(a, fbs); err != nil {
+		return err
+	}
+	g, err := ch.newGuard(a)
+	if err != nil {
+		return err
+	}
+
+	ch.buildRoute(r).Handler(g(proxy.NewFailoverHandler(fbs, ch.FailoverStatuses, ch.BackendHeader)))
+	return nil
+}
+
+// checkWrites returns why objects cannot be uploaded to or deleted from the
+// handler, when either is enabled. Only authenticated users may change
+// objects, and only in a single backend, since the request cannot be replayed
+// to another. Backends cannot be kept from overwriting objects that are
+// uploaded to them directly.
+func (ch *ConfigHandler) checkWrites(a auth.Authenticator, hs []proxy.NamedHandler) error {
+	if ch.PostPolicyEnabled() && !ch.OverwriteEnabled() {
+		return errors.New("upload_post_policy cannot be used when allow_overwrite is false")
+	}
+	for _, w := range []struct {
+		option  string
+		enabled bool
+		ok      func(proxy.Backend) bool
+	}{
+		{"allow_upload", ch.UploadEnabled(), func(b proxy.Backend) bool { _, ok := b.(proxy.Uploader); return ok }},
+		{"allow_delete", ch.DeleteEnabled(), func(b proxy.Backend) bool { _, ok := b.(proxy.Deleter); return ok }},
+		{"upload_post_policy", ch.PostPolicyEnabled(), func(b proxy.Backend) bool { _, ok := b.(proxy.PostSigner); return ok }},
+	} {
+		if !w.enabled {
+			continue
+		}
+		if a == nil {
+			return errors.Errorf("%s requires authentication", w.option)
+		}
+		if len(ch.Backends) > 0 {
+			return errors.Errorf("%s is not supported with failover backends", w.option)
+		}
+		for _, nh := range hs {
+			if !w.ok(nh.Backend) {
+				return errors.Errorf("%s is not supported by backend %q", w.option, nh.Name)
+			}
 		}
-		ch.buildRoute(r).Handler(h)
 	}
 	return nil
 }
 
+// firstBackend returns the first object store among hs, if any.
+func firstBackend(hs []proxy.NamedHandler) proxy.Backend {
+	for _, nh := range hs {
+		if nh.Backend != nil {
+			return nh.Backend
+		}
+	}
+	return nil
+}
+
+// newGuard returns a function that wraps the handler's routes with its client
+// address restrictions, rate and bandwidth limits, authentication by a, and
+// auditing of uploads and deletes.
+func (ch *ConfigHandler) newGuard(a auth.Authenticator) (func(http.Handler) http.Handler, error) {
+	acl, err := ch.newACL()
+	if err != nil {
+		return nil, err
+	}
+	al, err := ch.newAuditLog()
+	if err != nil {
+		return nil, err
+	}
+	l, err := ch.RateLimit.NewLimiter()
+	if err != nil {
+		return nil, err
+	}
+	bl, err := ch.Bandwidth.NewLimit(ch.routeName())
+	if err != nil {
+		return nil, err
+	}
+	return func(h http.Handler) http.Handler {
+		h = audit.Identify(limitBandwidth(bl, h))
+		// Clients are only known by their user once authenticated; otherwise,
+		// limiting before authentication also slows down guessing passwords
+		if l != nil && ratelimit.Key(ch.RateLimit.Key) == ratelimit.KeyUser {
+			h = restrict(acl, protect(a, throttle(l, h)))
+		} else {
+			h = restrict(acl, throttle(l, protect(a, h)))
+		}
+		// Uploads and deletes are audited even when they are refused
+		return audit.Handler(al, h)
+	}, nil
+}
+
+// protect requires requests to h to be authenticated by a, unless it is nil.
+func protect(a auth.Authenticator, h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return auth.Require(a, h)
+}
+
+// newAuditLog returns the log to which uploads and deletes are recorded, or nil
+// when they are recorded in the request log.
+func (ch *ConfigHandler) newAuditLog() (*audit.Log, error) {
+	if ch.AuditLog == "" {
+		return nil, nil
+	}
+	return audit.Open(ch.AuditLog)
+}
+
+// newACL returns the client addresses allowed to use the handler, or nil
+// when anyone may.
+func (ch *ConfigHandler) newACL() (*clientip.ACL, error) {
+	if len(ch.AllowIPs) == 0 && len(ch.DenyIPs) == 0 {
+		return nil, nil
+	}
+	allow, err := clientip.ParsePrefixes(ch.AllowIPs)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse allow_ips")
+	}
+	deny, err := clientip.ParsePrefixes(ch.DenyIPs)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse deny_ips")
+	}
+	return &clientip.ACL{Allow: allow, Deny: deny}, nil
+}
+
+// restrict only lets clients allowed by acl through to h, unless it is nil.
+func restrict(acl *clientip.ACL, h http.Handler) http.Handler {
+	if acl == nil {
+		return h
+	}
+	return acl.Handler(h)
+}
+
+// routeName identifies the handler in metrics by the host and path it serves.
+func (ch *ConfigHandler) routeName() string {
+	host := ch.Host
+	if host == "" {
+		host = "*"
+	}
+	if ch.Path != "" {
+		return host + ch.Path
+	}
+	return host + ch.PathPrefix
+}
+
 func (ch *ConfigHandler) buildRoute(r *mux.Router) *mux.Route {
 	rt := r.NewRoute()
 	if ch.Host != "" {
@@ -146,26 +452,97 @@ func (ch *ConfigHandler) buildRoute(r *mux.Router) *mux.Route {
 	} else if ch.PathPrefix != "" {
 		rt = rt.PathPrefix(ch.PathPrefix)
 	}
-	return rt.Methods("GET")
+	methods := []string{"GET"}
+	if ch.UploadEnabled() {
+		methods = append(methods, "PUT")
+	}
+	if ch.DeleteEnabled() {
+		methods = append(methods, "DELETE")
+	}
+	if ch.PostPolicyEnabled() {
+		methods = append(methods, "POST")
+	}
+	if ch.WebDAVEnabled() {
+		methods = append(methods, proxy.WebDAVMethods...)
+	}
+	return rt.Methods(methods...)
+}
+
+// keyHandler wraps h, which serves the keys of a backend, so that request
+// paths are rewritten into keys before users are kept to their prefixes.
+func (ch *ConfigHandler) keyHandler(h http.Handler, prefix string) http.Handler {
+	h = auth.RequirePrefixes(auth.RequireWritePrefixes(ch.WritePrefixes, h))
+	if prefix != "" {
+		h = ch.rewriteHandler(h, prefix)
+	}
+	if ch.WebDAVEnabled() {
+		h = ch.destinationHandler(h, prefix)
+	}
+	return h
 }
 
-func (ch *ConfigHandler) rewriteHandler(h http.Handler) http.Handler {
+func (ch *ConfigHandler) rewriteHandler(h http.Handler, prefix string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		// rewrite path
-		p := req.URL.Path
+		p := ch.rewritePath(req.URL.Path, prefix, mux.Vars(req))
+
+		// deep copy the request so we can reinject the rewritten path without
+		// affecting any other backend that may later serve the same request
+		req = req.Clone(req.Context())
+		req.URL.Path = p
+		req.URL.RawPath = ""
+		h.ServeHTTP(w, req)
+	})
+}
+
+// rewritePath returns the path of the key with prefix that is served for the
+// request path p, given the variables v of the route.
+func (ch *ConfigHandler) rewritePath(p, prefix string, v map[string]string) string {
+	if ch.PathPrefix != "" {
+		p = strings.TrimPrefix(p, substituteParams(ch.PathPrefix, v))
+	}
+	return substituteParams(prefix, v) + p
+}
+
+// destinationHandler rewrites the Destination header of WebDAV copies and
+// moves into the path of a key, like the request path, so that both are kept
+// to the user's prefixes. Since objects are only copied within a backend,
+// destinations outside of the handler's route are refused.
+func (ch *ConfigHandler) destinationHandler(h http.Handler, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, ok := proxy.Destination(req)
+		if !ok {
+			h.ServeHTTP(w, req)
+			return
+		}
+
 		v := mux.Vars(req)
-		if ch.PathPrefix != "" {
-			p = strings.TrimPrefix(p, substituteParams(ch.PathPrefix, v))
+		if u, _ := url.Parse(req.Header.Get("Destination")); u.Host != "" && !strings.EqualFold(u.Host, req.Host) || !ch.servesPath(p, v) {
+			http.Error(w, "The destination is not served by this handler.", http.StatusBadGateway)
+			return
+		}
+		if prefix != "" {
+			p = ch.rewritePath(p, prefix, v)
 		}
-		p = substituteParams(ch.S3Prefix, v) + p
 
-		// deep copy the request so we can reinject the rewritten path
-		req = req.WithContext(req.Context())
-		req.URL.Path = p
+		req = req.Clone(req.Context())
+		req.Header.Set("Destination", (&url.URL{Path: p}).String())
 		h.ServeHTTP(w, req)
 	})
 }
 
+// servesPath reports whether the handler's route matches the request path p,
+// given the variables v that it matched the request with.
+func (ch *ConfigHandler) servesPath(p string, v map[string]string) bool {
+	switch {
+	case ch.Path != "":
+		return p == substituteParams(ch.Path, v)
+	case ch.PathPrefix != "":
+		return strings.HasPrefix(p, substituteParams(ch.PathPrefix, v))
+	}
+	return true
+}
+
 func substituteParams(s string, params map[string]string) string {
 	return reVarSubsitution.ReplaceAllStringFunc(s, func(in string) string {
 		k := in[1 : len(in)-1]
@@ -0,0 +1,802 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/auth"
+	"github.com/ripta/ssp/proxy/origin"
+	"github.com/ripta/ssp/proxy/proxytest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(name, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+		check   func(t *testing.T, cfg *ConfigRoot)
+	}{
+		{
+			name: "defaults",
+			text: `
+defaults:
+  autoindex: true
+  index_files: [index.html]
+  s3_bucket: bucket
+  s3_region: us-west-2
+handlers:
+- host: a.example.com
+- host: b.example.com
+  s3_bucket: other
+  autoindex: false
+`,
+			check: func(t *testing.T, cfg *ConfigRoot) {
+				if len(cfg.Handlers) != 2 {
+					t.Fatalf("got %d handlers, want 2", len(cfg.Handlers))
+				}
+				a, b := cfg.Handlers[0], cfg.Handlers[1]
+				if a.S3Bucket != "bucket" || b.S3Bucket != "other" {
+					t.Errorf("s3_bucket = %q, %q; want %q, %q", a.S3Bucket, b.S3Bucket, "bucket", "other")
+				}
+				if a.S3Region != "us-west-2" || b.S3Region != "us-west-2" {
+					t.Errorf("s3_region = %q, %q; want both %q", a.S3Region, b.S3Region, "us-west-2")
+				}
+				if !*a.Autoindex || *b.Autoindex {
+					t.Errorf("autoindex = %v, %v; want true, false", *a.Autoindex, *b.Autoindex)
+				}
+				if !reflect.DeepEqual(b.IndexFiles, []string{"index.html"}) {
+					t.Errorf("index_files = %q", b.IndexFiles)
+				}
+			},
+		},
+		{
+			name: "no defaults",
+			text: `
+handlers:
+- path_prefix: /static
+  gcs_bucket: bucket
+`,
+			check: func(t *testing.T, cfg *ConfigRoot) {
+				if cfg.Defaults != nil {
+					t.Errorf("defaults = %+v, want nil", cfg.Defaults)
+				}
+				if h := cfg.Handlers[0]; h.PathPrefix != "/static" || h.GCSBucket != "bucket" {
+					t.Errorf("handler = %+v", h)
+				}
+			},
+		},
+		{
+			name: "failover backends",
+			text: `
+handlers:
+- backends:
+  - name: primary
+    s3_bucket: one
+    s3_region: us-east-1
+  - name: secondary
+    gcs_bucket: two
+  failover_statuses: [404]
+`,
+			check: func(t *testing.T, cfg *ConfigRoot) {
+				h := cfg.Handlers[0]
+				if len(h.Backends) != 2 || h.Backends[0].Name != "primary" || h.Backends[1].GCSBucket != "two" {
+					t.Errorf("backends = %+v", h.Backends)
+				}
+				if !reflect.DeepEqual(h.FailoverStatuses, []int{404}) {
+					t.Errorf("failover_statuses = %v", h.FailoverStatuses)
+				}
+			},
+		},
+		{
+			name: "proxy settings",
+			text: `
+proxy_settings:
+  timeout_duration: 1m
+  admin_address: 127.0.0.1:9090
+handlers: []
+`,
+			check: func(t *testing.T, cfg *ConfigRoot) {
+				if got := cfg.Proxy.Timeout(); got != time.Minute {
+					t.Errorf("Timeout() = %v, want %v", got, time.Minute)
+				}
+				if cfg.Proxy.AdminAddress != "127.0.0.1:9090" {
+					t.Errorf("admin_address = %q", cfg.Proxy.AdminAddress)
+				}
+				if got := (&ProxySettings{}).Timeout(); got != DefaultTimeout {
+					t.Errorf("default Timeout() = %v, want %v", got, DefaultTimeout)
+				}
+			},
+		},
+		{
+			name: "global rate limit by user",
+			text: `
+proxy_settings:
+  rate_limit:
+    requests_per_second: 10
+    key: user
+handlers: []
+`,
+			wantErr: true,
+		},
+		{
+			name: "handler rate limit by user",
+			text: `
+proxy_settings:
+  rate_limit:
+    requests_per_second: 50
+handlers:
+- s3_bucket: bucket
+  rate_limit:
+    requests_per_second: 10
+    key: user
+`,
+			check: func(t *testing.T, cfg *ConfigRoot) {
+				if k := cfg.Handlers[0].RateLimit.Key; k != "user" {
+					t.Errorf("rate_limit.key = %q, want %q", k, "user")
+				}
+			},
+		},
+		{
+			name:    "unknown field",
+			text:    "handlers:\n- s3_buckt: typo\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			text:    "handlers: [",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Load() of a missing file succeeded")
+	}
+}
+
+func TestLoadExamples(t *testing.T) {
+	names, err := filepath.Glob("../examples/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no examples found")
+	}
+	for _, name := range names {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			if _, err := Load(name); err != nil {
+				t.Errorf("Load() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	yes, no := true, false
+	size := 10
+	uploadMax := int64(1 << 20)
+
+	tests := []struct {
+		name     string
+		handler  ConfigHandler
+		defaults *ConfigHandler
+		want     ConfigHandler
+	}{
+		{
+			name:    "nil defaults",
+			handler: ConfigHandler{Host: "example.com"},
+			want:    ConfigHandler{Host: "example.com"},
+		},
+		{
+			name:    "inherited",
+			handler: ConfigHandler{Host: "example.com"},
+			defaults: &ConfigHandler{
+				PathPrefix:    "/files",
+				ConfigBackend: ConfigBackend{S3Bucket: "bucket", S3Region: "us-west-2"},
+				Options:       proxy.Options{Autoindex: &yes, AutoindexPageSize: &size, IndexFiles: []string{"index.html"}},
+			},
+			want: ConfigHandler{
+				Host:          "example.com",
+				PathPrefix:    "/files",
+				ConfigBackend: ConfigBackend{S3Bucket: "bucket", S3Region: "us-west-2"},
+				Options:       proxy.Options{Autoindex: &yes, AutoindexPageSize: &size, IndexFiles: []string{"index.html"}},
+			},
+		},
+		{
+			name: "overridden",
+			handler: ConfigHandler{
+				Host:          "example.com",
+				ConfigBackend: ConfigBackend{S3Bucket: "mine"},
+				Options:       proxy.Options{Autoindex: &no, IndexFiles: []string{"default.htm"}},
+			},
+			defaults: &ConfigHandler{
+				Host:          "default.example.com",
+				ConfigBackend: ConfigBackend{S3Bucket: "bucket", S3Region: "us-west-2"},
+				Options:       proxy.Options{Autoindex: &yes, IndexFiles: []string{"index.html"}},
+			},
+			want: ConfigHandler{
+				Host:          "example.com",
+				ConfigBackend: ConfigBackend{S3Bucket: "mine", S3Region: "us-west-2"},
+				Options:       proxy.Options{Autoindex: &no, IndexFiles: []string{"default.htm"}},
+			},
+		},
+		{
+			name:     "auth inherited",
+			handler:  ConfigHandler{},
+			defaults: &ConfigHandler{Auth: &ConfigAuth{Htpasswd: "/etc/htpasswd"}},
+			want:     ConfigHandler{Auth: &ConfigAuth{Htpasswd: "/etc/htpasswd"}},
+		},
+		{
+			name:     "auth disabled",
+			handler:  ConfigHandler{Auth: &ConfigAuth{}},
+			defaults: &ConfigHandler{Auth: &ConfigAuth{Htpasswd: "/etc/htpasswd"}},
+			want:     ConfigHandler{Auth: &ConfigAuth{}},
+		},
+		{
+			name:     "address lists inherited separately",
+			handler:  ConfigHandler{DenyIPs: []string{"192.0.2.1"}},
+			defaults: &ConfigHandler{AllowIPs: []string{"192.0.2.0/24"}, DenyIPs: []string{"192.0.2.2"}},
+			want:     ConfigHandler{AllowIPs: []string{"192.0.2.0/24"}, DenyIPs: []string{"192.0.2.1"}},
+		},
+		{
+			name:     "signing keys inherited",
+			handler:  ConfigHandler{RequireSignature: &yes},
+			defaults: &ConfigHandler{SigningKeys: map[string]string{"k1": "env:KEY"}, RequireSignature: &no},
+			want:     ConfigHandler{SigningKeys: map[string]string{"k1": "env:KEY"}, RequireSignature: &yes},
+		},
+		{
+			name:     "rate limit inherited",
+			handler:  ConfigHandler{},
+			defaults: &ConfigHandler{RateLimit: &ConfigRateLimit{RequestsPerSecond: 10}},
+			want:     ConfigHandler{RateLimit: &ConfigRateLimit{RequestsPerSecond: 10}},
+		},
+		{
+			name:     "bandwidth overridden",
+			handler:  ConfigHandler{Bandwidth: &ConfigBandwidth{ClientBytesPerSecond: 1 << 20}},
+			defaults: &ConfigHandler{Bandwidth: &ConfigBandwidth{BytesPerSecond: 1 << 30}},
+			want:     ConfigHandler{Bandwidth: &ConfigBandwidth{ClientBytesPerSecond: 1 << 20}},
+		},
+		{
+			name:     "upload inherited",
+			handler:  ConfigHandler{Options: proxy.Options{UploadMaxBytes: &uploadMax}},
+			defaults: &ConfigHandler{Options: proxy.Options{AllowUpload: &yes}},
+			want:     ConfigHandler{Options: proxy.Options{AllowUpload: &yes, UploadMaxBytes: &uploadMax}},
+		},
+		{
+			name:     "write policy inherited",
+			handler:  ConfigHandler{Options: proxy.Options{AllowDelete: &no}},
+			defaults: &ConfigHandler{WritePrefixes: []string{"/users/{user}/"}, AuditLog: "/var/log/ssp/audit.log", Options: proxy.Options{AllowOverwrite: &no, AllowDelete: &yes}},
+			want:     ConfigHandler{WritePrefixes: []string{"/users/{user}/"}, AuditLog: "/var/log/ssp/audit.log", Options: proxy.Options{AllowOverwrite: &no, AllowDelete: &no}},
+		},
+		{
+			name:     "webdav inherited",
+			handler:  ConfigHandler{},
+			defaults: &ConfigHandler{Options: proxy.Options{WebDAV: &yes}},
+			want:     ConfigHandler{Options: proxy.Options{WebDAV: &yes}},
+		},
+		{
+			name:     "upload form inherited",
+			handler:  ConfigHandler{Options: proxy.Options{UploadPostPolicy: &yes}},
+			defaults: &ConfigHandler{Options: proxy.Options{UploadPostPolicy: &no, AutoindexUploadForm: &yes}},
+			want:     ConfigHandler{Options: proxy.Options{UploadPostPolicy: &yes, AutoindexUploadForm: &yes}},
+		},
+		{
+			name:     "backend name is not inherited",
+			handler:  ConfigHandler{},
+			defaults: &ConfigHandler{ConfigBackend: ConfigBackend{Name: "default", GCSBucket: "bucket"}},
+			want:     ConfigHandler{ConfigBackend: ConfigBackend{GCSBucket: "bucket"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := tt.handler
+			ch.setDefaults(tt.defaults)
+			if !reflect.DeepEqual(ch, tt.want) {
+				t.Errorf("setDefaults() = %+v, want %+v", ch, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRoute(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name     string
+		handler  ConfigHandler
+		method   string
+		url      string
+		want     bool
+		wantVars map[string]string
+	}{
+		{"any", ConfigHandler{}, "GET", "http://example.com/a/b", true, map[string]string{}},
+		{"host", ConfigHandler{Host: "example.com"}, "GET", "http://example.com/a", true, map[string]string{}},
+		{"other host", ConfigHandler{Host: "example.com"}, "GET", "http://example.org/a", false, nil},
+		{"host variable", ConfigHandler{Host: "{user}.example.com"}, "GET", "http://ripta.example.com/", true, map[string]string{"user": "ripta"}},
+		{"path", ConfigHandler{Path: "/a"}, "GET", "http://example.com/a", true, map[string]string{}},
+		{"path is exact", ConfigHandler{Path: "/a"}, "GET", "http://example.com/a/b", false, nil},
+		{"path prefix", ConfigHandler{PathPrefix: "/a"}, "GET", "http://example.com/a/b", true, map[string]string{}},
+		{"other path prefix", ConfigHandler{PathPrefix: "/a"}, "GET", "http://example.com/b", false, nil},
+		{"path prefix variable", ConfigHandler{PathPrefix: "/~{user}"}, "GET", "http://example.com/~ripta/x", true, map[string]string{"user": "ripta"}},
+		{"path wins over prefix", ConfigHandler{Path: "/a", PathPrefix: "/b"}, "GET", "http://example.com/b/c", false, nil},
+		{"method", ConfigHandler{}, "POST", "http://example.com/", false, nil},
+		{"upload disabled", ConfigHandler{}, "PUT", "http://example.com/a", false, nil},
+		{"upload", ConfigHandler{Options: proxy.Options{AllowUpload: &yes}}, "PUT", "http://example.com/a", true, map[string]string{}},
+		{"delete disabled", ConfigHandler{Options: proxy.Options{AllowUpload: &yes}}, "DELETE", "http://example.com/a", false, nil},
+		{"delete", ConfigHandler{Options: proxy.Options{AllowDelete: &yes}}, "DELETE", "http://example.com/a", true, map[string]string{}},
+		{"post policy", ConfigHandler{Options: proxy.Options{AllowUpload: &yes, UploadPostPolicy: &yes}}, "POST", "http://example.com/a/", true, map[string]string{}},
+		{"webdav disabled", ConfigHandler{}, "PROPFIND", "http://example.com/a/", false, nil},
+		{"webdav", ConfigHandler{Options: proxy.Options{WebDAV: &yes}}, "PROPFIND", "http://example.com/a/", true, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			tt.handler.buildRoute(r).Handler(http.NotFoundHandler())
+
+			var m mux.RouteMatch
+			got := r.Match(httptest.NewRequest(tt.method, tt.url, nil), &m) && m.MatchErr == nil
+			if got != tt.want {
+				t.Fatalf("Match() = %v, want %v", got, tt.want)
+			}
+			if got && !reflect.DeepEqual(m.Vars, tt.wantVars) {
+				t.Errorf("vars = %v, want %v", m.Vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestSubstituteParams(t *testing.T) {
+	tests := []struct {
+		s      string
+		params map[string]string
+		want   string
+	}{
+		{"", nil, ""},
+		{"/users", nil, "/users"},
+		{"/users/{username}", map[string]string{"username": "ripta"}, "/users/ripta"},
+		{"/{a}/{b}/{a}", map[string]string{"a": "x", "b": "y"}, "/x/y/x"},
+		{"/users/{missing}/files", map[string]string{"username": "ripta"}, "/users//files"},
+		{"/users/{username}", nil, "/users/"},
+		{"/{unterminated", map[string]string{"unterminated": "x"}, "/{unterminated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := substituteParams(tt.s, tt.params); got != tt.want {
+				t.Errorf("substituteParams(%q, %v) = %q, want %q", tt.s, tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		pathPrefix string
+		prefix     string
+		url        string
+		want       string
+	}{
+		{"no prefix", "", "", "/a/b", "/a/b"},
+		{"backend prefix", "", "/users", "/a/b", "/users/a/b"},
+		{"path prefix", "/static", "", "/static/a", "/a"},
+		{"both", "/~{user}", "/users/{user}", "/~ripta/a.txt", "/users/ripta/a.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &ConfigHandler{PathPrefix: tt.pathPrefix}
+
+			var got string
+			h := ch.rewriteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Path
+			}), tt.prefix)
+
+			r := mux.NewRouter()
+			ch.buildRoute(r).Handler(h)
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("rewritten path = %q, want %q", got, tt.want)
+			}
+			if req.URL.Path != tt.url {
+				t.Errorf("original request was modified to %q", req.URL.Path)
+			}
+		})
+	}
+}
+
+func TestDestinationHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		pathPrefix  string
+		prefix      string
+		path        string
+		destination string
+		wantStatus  int
+		want        string
+	}{
+		{"no prefix", "", "", "/a.txt", "http://example.com/a/b%20c", http.StatusOK, "/a/b%20c"},
+		{"backend prefix", "", "/users", "/a.txt", "/a/b", http.StatusOK, "/users/a/b"},
+		{"both", "/~{user}", "/users/{user}", "/~ripta/a.txt", "http://example.com/~ripta/b.txt", http.StatusOK, "/users/ripta/b.txt"},
+		{"unclean", "/~{user}", "/users/{user}", "/~ripta/a.txt", "/~ripta/../~other/b.txt", http.StatusBadGateway, ""},
+		{"other route", "/~{user}", "/users/{user}", "/~ripta/a.txt", "/~other/b.txt", http.StatusBadGateway, ""},
+		{"other host", "", "", "/a.txt", "http://example.org/a/b", http.StatusBadGateway, ""},
+		{"none", "", "", "/a.txt", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yes := true
+			ch := &ConfigHandler{PathPrefix: tt.pathPrefix, Options: proxy.Options{WebDAV: &yes}}
+
+			var got string
+			h := ch.destinationHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("Destination")
+			}), tt.prefix)
+
+			r := mux.NewRouter()
+			ch.buildRoute(r).Handler(h)
+			req := httptest.NewRequest(proxy.MethodCopy, "http://example.com"+tt.path, nil)
+			if tt.destination != "" {
+				req.Header.Set("Destination", tt.destination)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got != tt.want {
+				t.Errorf("rewritten destination = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	t.Setenv("SSP_TEST_COOKIE_SECRET", "0123456789abcdef0123456789abcdef")
+	t.Setenv("SSP_TEST_SHORT_KEY", "short")
+	oidc := &ConfigOIDC{
+		Issuer:       "https://login.example.com",
+		ClientID:     "ssp",
+		RedirectURL:  "https://docs.example.com/_auth/callback",
+		CookieSecret: "env:SSP_TEST_COOKIE_SECRET",
+	}
+
+	keys := map[string]string{"k1": "env:SSP_TEST_COOKIE_SECRET"}
+	yes := true
+
+	tests := []struct {
+		name    string
+		handler ConfigHandler
+		want    bool
+		wantErr bool
+	}{
+		{"nil", ConfigHandler{}, false, false},
+		{"empty", ConfigHandler{Auth: &ConfigAuth{}}, false, false},
+		{"oidc", ConfigHandler{Auth: &ConfigAuth{OIDC: oidc}}, true, false},
+		{"oidc without cookie secret", ConfigHandler{Auth: &ConfigAuth{OIDC: &ConfigOIDC{Issuer: oidc.Issuer, ClientID: oidc.ClientID, RedirectURL: oidc.RedirectURL}}}, false, true},
+		{"jwt", ConfigHandler{Auth: &ConfigAuth{JWT: &ConfigJWT{HMACSecret: "env:SSP_TEST_COOKIE_SECRET", Prefixes: []string{"/users/{sub}/"}}}}, true, false},
+		{"jwt without keys", ConfigHandler{Auth: &ConfigAuth{JWT: &ConfigJWT{}}}, false, true},
+		{"missing htpasswd", ConfigHandler{Auth: &ConfigAuth{Htpasswd: filepath.Join(t.TempDir(), "missing"), OIDC: oidc}}, false, true},
+		{"signing keys alone", ConfigHandler{SigningKeys: keys}, false, false},
+		{"signing keys with oidc", ConfigHandler{SigningKeys: keys, Auth: &ConfigAuth{OIDC: oidc}}, true, false},
+		{"require signature", ConfigHandler{SigningKeys: keys, RequireSignature: &yes}, true, false},
+		{"require signature without keys", ConfigHandler{RequireSignature: &yes}, false, true},
+		{"short signing key", ConfigHandler{SigningKeys: map[string]string{"k1": "env:SSP_TEST_SHORT_KEY"}, RequireSignature: &yes}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := tt.handler.newAuthenticator(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newAuthenticator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := a != nil; got != tt.want {
+				t.Errorf("newAuthenticator() = %v, want authenticator %v", a, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSignatureRefusesOtherCredentials(t *testing.T) {
+	t.Setenv("SSP_TEST_SIGNING_KEY", "0123456789abcdef0123456789abcdef")
+	hash, err := bcrypt.GenerateFromPassword([]byte("wonderland"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("alice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	yes := true
+	ch := ConfigHandler{
+		Auth:             &ConfigAuth{Htpasswd: htpasswd},
+		SigningKeys:      map[string]string{"k1": "env:SSP_TEST_SIGNING_KEY"},
+		RequireSignature: &yes,
+	}
+	a, err := ch.newAuthenticator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := protect(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	basic := httptest.NewRequest(http.MethodGet, "/report.pdf", nil)
+	basic.SetBasicAuth("alice", "wonderland")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, basic)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status with basic credentials = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	u := auth.SignURL(&url.URL{Path: "/report.pdf"}, "k1", []byte("0123456789abcdef0123456789abcdef"), time.Now().Add(time.Hour), netip.Addr{})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status with a signed URL = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestGuardAuditsRefusedWrites(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("wonderland"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	htpasswd := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("alice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := ConfigHandler{
+		Auth:     &ConfigAuth{Htpasswd: htpasswd},
+		AuditLog: filepath.Join(dir, "audit.log"),
+	}
+	a, err := ch.newAuthenticator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := ch.newGuard(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := g(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/notes.md", strings.NewReader("hi")))
+	r := httptest.NewRequest(http.MethodPut, "/notes.md", strings.NewReader("hi"))
+	r.SetBasicAuth("alice", "wonderland")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	p, err := os.ReadFile(ch.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d records, want 2:\n%s", len(lines), p)
+	}
+	if !strings.Contains(lines[0], `"status":401`) || strings.Contains(lines[0], `"user"`) {
+		t.Errorf("record of refused upload = %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"status":201`) || !strings.Contains(lines[1], `"user":"alice"`) {
+		t.Errorf("record of upload = %s", lines[1])
+	}
+}
+
+func TestBackendOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler ConfigHandler
+		want    []string
+	}{
+		{"public", ConfigHandler{}, nil},
+		{"local htpasswd", ConfigHandler{Auth: &ConfigAuth{Htpasswd: "/etc/ssp/htpasswd"}}, nil},
+		{"htpasswd in backend", ConfigHandler{Auth: &ConfigAuth{Htpasswd: "backend:_auth/htpasswd"}}, []string{"_auth/htpasswd"}},
+		{"htpasswd in backend with slash", ConfigHandler{Auth: &ConfigAuth{Htpasswd: "backend:/_auth/htpasswd"}}, []string{"_auth/htpasswd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.handler.backendOptions().PrivateKeys; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PrivateKeys = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHandlersTemplate(t *testing.T) {
+	// An autoindex template that cannot be parsed fails the configuration
+	// instead of the first listing
+	name := filepath.Join(t.TempDir(), "listing.tmpl")
+	if err := os.WriteFile(name, []byte("{{ .Path "), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cb := &ConfigBackend{S3Region: "us-east-1", S3Bucket: "example"}
+	if _, err := cb.newHandlers(&ConfigHandler{}); err != nil {
+		t.Fatalf("newHandlers() = %v", err)
+	}
+	ch := &ConfigHandler{Options: proxy.Options{AutoindexTemplate: name}}
+	if _, err := cb.newHandlers(ch); err == nil {
+		t.Error("newHandlers() succeeded with an invalid template")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	yes := true
+	keys := map[string]string{"k1": "env:SSP_TEST_SIGNING_KEY"}
+	tests := []struct {
+		name    string
+		handler ConfigHandler
+		want    origin.Credentials
+	}{
+		{"public", ConfigHandler{}, origin.Credentials{}},
+		{"htpasswd", ConfigHandler{Auth: &ConfigAuth{Htpasswd: "/etc/ssp/htpasswd"}}, origin.Credentials{Headers: []string{"Authorization"}}},
+		{"jwt", ConfigHandler{Auth: &ConfigAuth{JWT: &ConfigJWT{}}}, origin.Credentials{Headers: []string{"Authorization"}}},
+		{"oidc", ConfigHandler{Auth: &ConfigAuth{OIDC: &ConfigOIDC{CookieName: "docs"}}}, origin.Credentials{Cookies: []string{"docs", "docs_login"}}},
+		{
+			name:    "signed urls",
+			handler: ConfigHandler{Auth: &ConfigAuth{OIDC: &ConfigOIDC{}}, SigningKeys: keys},
+			want:    origin.Credentials{Cookies: []string{"ssp_session", "ssp_session_login"}, Query: auth.SignedURLParams},
+		},
+		{
+			name:    "signatures required",
+			handler: ConfigHandler{Auth: &ConfigAuth{Htpasswd: "/etc/ssp/htpasswd"}, SigningKeys: keys, RequireSignature: &yes},
+			want:    origin.Credentials{Query: auth.SignedURLParams},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.handler.credentials(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("credentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewACL(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler ConfigHandler
+		want    bool
+		wantErr bool
+	}{
+		{"none", ConfigHandler{}, false, false},
+		{"allow", ConfigHandler{AllowIPs: []string{"10.0.0.0/8"}}, true, false},
+		{"deny", ConfigHandler{DenyIPs: []string{"192.0.2.1"}}, true, false},
+		{"invalid allow", ConfigHandler{AllowIPs: []string{"10.0.0.0/40"}}, false, true},
+		{"invalid deny", ConfigHandler{DenyIPs: []string{"localhost"}}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl, err := tt.handler.newACL()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newACL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := acl != nil; got != tt.want {
+				t.Errorf("newACL() = %v, want ACL %v", acl, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	burst := 5
+	tests := []struct {
+		name    string
+		limit   *ConfigRateLimit
+		want    bool
+		wantErr bool
+	}{
+		{"none", nil, false, false},
+		{"no limits", &ConfigRateLimit{Key: "user"}, false, false},
+		{"rate", &ConfigRateLimit{RequestsPerSecond: 2, Burst: &burst}, true, false},
+		{"concurrency", &ConfigRateLimit{MaxConcurrent: 4, Key: "host"}, true, false},
+		{"unknown key", &ConfigRateLimit{RequestsPerSecond: 2, Key: "cookie"}, false, true},
+		{"negative rate", &ConfigRateLimit{RequestsPerSecond: -1}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := tt.limit.NewLimiter()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewLimiter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := l != nil; got != tt.want {
+				t.Errorf("NewLimiter() = %v, want limiter %v", l, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBandwidthLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		bandwidth *ConfigBandwidth
+		want      bool
+		wantErr   bool
+	}{
+		{"none", nil, false, false},
+		{"no limits", &ConfigBandwidth{BurstBytes: 1 << 20}, false, false},
+		{"shared", &ConfigBandwidth{BytesPerSecond: 1 << 30}, true, false},
+		{"per client", &ConfigBandwidth{ClientBytesPerSecond: 10 << 20, BurstBytes: 1 << 20}, true, false},
+		{"negative", &ConfigBandwidth{ClientBytesPerSecond: -1}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := tt.bandwidth.NewLimit("test-" + tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := l != nil; got != tt.want {
+				t.Errorf("NewLimit() = %v, want limit %v", l, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckWrites(t *testing.T) {
+	yes, no := true, false
+	a, err := auth.NewSignedURL(auth.SigningKeys{"k1": make([]byte, auth.MinSigningKeyLength)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := []proxy.NamedHandler{{Name: "memory", Backend: proxytest.NewMemoryBackend(nil)}}
+	origin := []proxy.NamedHandler{{Name: "http_origin:https://example.com", Handler: http.NotFoundHandler()}}
+
+	tests := []struct {
+		name    string
+		handler ConfigHandler
+		a       auth.Authenticator
+		hs      []proxy.NamedHandler
+		wantErr bool
+	}{
+		{"disabled", ConfigHandler{}, nil, origin, false},
+		{"allowed", ConfigHandler{Options: proxy.Options{AllowUpload: &yes}}, a, memory, false},
+		{"unauthenticated", ConfigHandler{Options: proxy.Options{AllowUpload: &yes}}, nil, memory, true},
+		{"failover", ConfigHandler{Options: proxy.Options{AllowUpload: &yes}, Backends: []*ConfigBackend{{}, {}}}, a, memory, true},
+		{"origin", ConfigHandler{Options: proxy.Options{AllowUpload: &yes}}, a, origin, true},
+		{"delete allowed", ConfigHandler{Options: proxy.Options{AllowDelete: &yes}}, a, memory, false},
+		{"delete unauthenticated", ConfigHandler{Options: proxy.Options{AllowDelete: &yes}}, nil, memory, true},
+		{"delete failover", ConfigHandler{Options: proxy.Options{AllowDelete: &yes}, Backends: []*ConfigBackend{{}, {}}}, a, memory, true},
+		{"delete origin", ConfigHandler{Options: proxy.Options{AllowDelete: &yes}}, a, origin, true},
+		{"post policy", ConfigHandler{Options: proxy.Options{AllowUpload: &yes, UploadPostPolicy: &yes}}, a, memory, true},
+		{"post policy without overwrites", ConfigHandler{Options: proxy.Options{AllowUpload: &yes, UploadPostPolicy: &yes, AllowOverwrite: &no}}, a, memory, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.handler.checkWrites(tt.a, tt.hs); (err != nil) != tt.wantErr {
+				t.Errorf("checkWrites() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/ripta/ssp/proxy/ratelimit"
+)
+
+// ConfigRateLimit limits how often, and how many requests at once, each
+// client may make. Clients over their limits are refused with 429 Too Many
+// Requests.
+type ConfigRateLimit struct {
+	// RequestsPerSecond is the average rate at which each client may make
+	// requests, and Burst how many requests it may make at once, which
+	// defaults to the rate rounded up.
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty" yaml:"requests_per_second,omitempty"`
+	Burst             *int    `json:"burst,omitempty" yaml:"burst,omitempty"`
+
+	// MaxConcurrent is how many requests each client may have in progress
+	// at once.
+	MaxConcurrent int `json:"max_concurrent,omitempty" yaml:"max_concurrent,omitempty"`
+
+	// Key is what identifies a client: "ip" (the default), "user", which
+	// falls back to the address of unauthenticated clients, or "host".
+	// Only the limits of handlers can use "user".
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+}
+
+// NewLimiter returns a limiter for the configured limits, or nil when there
+// are none.
+func (crl *ConfigRateLimit) NewLimiter() (*ratelimit.Limiter, error) {
+	if crl == nil || (crl.RequestsPerSecond == 0 && crl.MaxConcurrent == 0) {
+		return nil, nil
+	}
+	c := ratelimit.Config{
+		Rate:          crl.RequestsPerSecond,
+		MaxConcurrent: crl.MaxConcurrent,
+		Key:           ratelimit.Key(crl.Key),
+	}
+	if crl.Burst != nil {
+		c.Burst = *crl.Burst
+	}
+	l, err := ratelimit.New(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not configure rate_limit")
+	}
+	return l, nil
+}
+
+// throttle limits requests to h by l, unless it is nil.
+func throttle(l *ratelimit.Limiter, h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return l.Handler(h)
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTLSPort is the port that HTTPS is served on, when it is configured.
+const DefaultTLSPort = 8443
+
+// ConfigTLS configures serving HTTPS natively, alongside plain HTTP.
+type ConfigTLS struct {
+	Port *int `json:"port,omitempty" yaml:"port,omitempty"`
+
+	// Certificates are served to clients by the host names they ask for.
+	Certificates []ConfigCertificate `json:"certificates" yaml:"certificates"`
+
+	// ClientCertificates request certificates from clients of some hosts.
+	ClientCertificates []ConfigClientCertificates `json:"client_certificates,omitempty" yaml:"client_certificates,omitempty"`
+}
+
+type ConfigCertificate struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+}
+
+// ConfigClientCertificates requests certificates issued by the authorities in
+// CAFile from clients of Hosts, which may include wildcards like
+// "*.routed.cloud". Unless Required is set, clients may connect without a
+// certificate, and handlers decide whether they need one.
+type ConfigClientCertificates struct {
+	Hosts    []string `json:"hosts" yaml:"hosts"`
+	CAFile   string   `json:"ca_file" yaml:"ca_file"`
+	Required *bool    `json:"required,omitempty" yaml:"required,omitempty"`
+}
+
+// ListenPort returns the port that HTTPS is served on.
+func (ct *ConfigTLS) ListenPort() int {
+	if ct.Port == nil {
+		return DefaultTLSPort
+	}
+	return *ct.Port
+}
+
+// ServerConfig returns the TLS configuration of the server.
+func (ct *ConfigTLS) ServerConfig() (*tls.Config, error) {
+	if len(ct.Certificates) == 0 {
+		return nil, errors.New("tls requires at least one certificate")
+	}
+
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	for _, cc := range ct.Certificates {
+		cert, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load certificate %s", cc.CertFile)
+		}
+		base.Certificates = append(base.Certificates, cert)
+	}
+
+	type hostConfig struct {
+		hosts  []string
+		config *tls.Config
+	}
+	var hcs []hostConfig
+	for _, ccc := range ct.ClientCertificates {
+		pem, err := ioutil.ReadFile(ccc.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read client CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in client CA file %q", ccc.CAFile)
+		}
+
+		c := base.Clone()
+		c.ClientCAs = pool
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		if isTrue(ccc.Required) {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		hcs = append(hcs, hostConfig{hosts: ccc.Hosts, config: c})
+	}
+
+	if len(hcs) > 0 {
+		base.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			for _, hc := range hcs {
+				for _, h := range hc.hosts {
+					if matchHost(h, hello.ServerName) {
+						return hc.config, nil
+					}
+				}
+			}
+			return nil, nil
+		}
+	}
+	return base, nil
+}
+
+// matchHost reports whether host matches pattern, which is either a host name
+// or a wildcard matching any one label, as in certificates.
+func matchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		label, rest, ok := strings.Cut(host, ".")
+		return ok && label != "" && rest == suffix
+	}
+	return pattern == host
+}
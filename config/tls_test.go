@@ -0,0 +1,105 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+func TestTLSServerConfig(t *testing.T) {
+	ca := proxytest.NewCA(t, "Internal CA")
+	serverCert := ca.Issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "routed.cloud"},
+		DNSNames:    []string{"public.routed.cloud", "internal.routed.cloud", "strict.routed.cloud"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert := ca.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "svc-reports"}})
+	certFile, keyFile := proxytest.WriteKeyPair(t, serverCert)
+	caFile := ca.WriteFile(t)
+	yes := true
+
+	ct := &ConfigTLS{
+		Certificates: []ConfigCertificate{{CertFile: certFile, KeyFile: keyFile}},
+		ClientCertificates: []ConfigClientCertificates{
+			{Hosts: []string{"internal.routed.cloud"}, CAFile: caFile},
+			{Hosts: []string{"strict.routed.cloud"}, CAFile: caFile, Required: &yes},
+		},
+	}
+	tc, err := ct.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) > 0 {
+			io.WriteString(w, r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		}
+	}))
+	srv.TLS = tc
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+
+	tests := []struct {
+		name    string
+		host    string
+		cert    bool
+		want    string
+		wantErr bool
+	}{
+		{"no client certificates", "public.routed.cloud", true, "", false},
+		{"requested", "internal.routed.cloud", true, "svc-reports", false},
+		{"requested but not given", "internal.routed.cloud", false, "", false},
+		{"required", "strict.routed.cloud", true, "svc-reports", false},
+		{"required but not given", "strict.routed.cloud", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &tls.Config{RootCAs: roots, ServerName: tt.host}
+			if tt.cert {
+				cc.Certificates = []tls.Certificate{clientCert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cc}}
+
+			resp, err := client.Get(srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.want {
+				t.Errorf("verified client = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"routed.cloud", "routed.cloud", true},
+		{"routed.cloud", "ROUTED.cloud.", true},
+		{"routed.cloud", "www.routed.cloud", false},
+		{"*.routed.cloud", "www.routed.cloud", true},
+		{"*.routed.cloud", "routed.cloud", false},
+		{"*.routed.cloud", "a.b.routed.cloud", false},
+	}
+	for _, tt := range tests {
+		if got := matchHost(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("matchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
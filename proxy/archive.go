@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	pathpkg "path"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Formats in which a directory can be downloaded as an archive.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ArchiveFormats are the supported archive formats, in the order they are
+// offered in directory listings.
+var ArchiveFormats = []string{ArchiveZip, ArchiveTarGz}
+
+// Default limits on the contents of a directory archive.
+const (
+	DefaultArchiveMaxObjects = 1000
+	DefaultArchiveMaxBytes   = 256 << 20
+)
+
+// errArchiveTooLarge is returned when a directory exceeds the archive limits.
+var errArchiveTooLarge = errors.New("directory is too large to download as an archive")
+
+// archiveEntry is an object to be added to an archive, along with its name
+// relative to the archived directory, which never leaves it.
+type archiveEntry struct {
+	ObjectInfo
+	Name string
+}
+
+// serveArchive streams every object under path, recursively, as an archive in
+// the requested format. Entries hidden from listings are left out, as are the
+// contents of hidden directories.
+func (h *handler) serveArchive(w http.ResponseWriter, r *http.Request, path, format string) {
+	log := hlog.FromRequest(r)
+
+	if format != ArchiveZip && format != ArchiveTarGz {
+		http.Error(w, fmt.Sprintf("Unsupported archive format %q.", format), http.StatusBadRequest)
+		return
+	}
+
+	// Walk the whole directory before writing anything, so that the limits
+	// are enforced before the response is committed
+	entries, err := h.walkArchive(r.Context(), path, requestPath(r))
+	if err != nil {
+		var be *BackendError
+		switch {
+		case errors.Is(err, errArchiveTooLarge):
+			http.Error(w, "Directory is too large to download as an archive.", http.StatusForbidden)
+		case errors.As(err, &be):
+			log.Error().Err(err).Fields(be.Fields).Msg("archive listing error")
+			http.Error(w, be.Message, be.StatusCode)
+		default:
+			log.Error().Err(err).Msg("generic archive listing error")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	root := archiveRoot(r)
+	filename := root + "." + format
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	switch format {
+	case ArchiveZip:
+		w.Header().Set("Content-Type", "application/zip")
+		err = h.writeZip(r.Context(), w, root, entries)
+	case ArchiveTarGz:
+		w.Header().Set("Content-Type", "application/gzip")
+		err = h.writeTarGz(r.Context(), w, root, entries)
+	}
+
+	// The response has already been committed, so the only way to tell the
+	// client that the archive is incomplete is to abort the connection
+	if err != nil {
+		log.Error().Err(err).Str("archive", filename).Msg("archive download error")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// walkArchive lists every object under prefix, descending into each
+// subdirectory. The public path of prefix, reqPath, is used to apply hide
+// rules. Objects whose keys would be extracted outside of the archived
+// directory are left out. It fails with errArchiveTooLarge as soon as any
+// limit is exceeded.
+func (h *handler) walkArchive(ctx context.Context, prefix, reqPath string) ([]archiveEntry, error) {
+	maxObjects, maxBytes := h.Options.ArchiveMaxObjects(), h.Options.ArchiveMaxBytes()
+
+	var entries []archiveEntry
+	var total int64
+	dirs := []string{""}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		opts := ListOptions{Limit: MaxPageSize}
+		for {
+			res, err := h.Backend.List(ctx, prefix+dir, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, p := range res.Prefixes {
+				name := strings.TrimPrefix(p, prefix)
+				if !h.Rules.Hidden(reqPath + name) {
+					dirs = append(dirs, name)
+				}
+			}
+			for _, obj := range res.Objects {
+				name := strings.TrimPrefix(obj.Key, prefix)
+				// Skip placeholders for directories
+				if name == "" || strings.HasSuffix(name, "/") || h.Options.Private(obj.Key) || h.Rules.Hidden(reqPath+name) {
+					continue
+				}
+				name, ok := archiveName(name)
+				if !ok {
+					continue
+				}
+
+				total += obj.Size
+				entries = append(entries, archiveEntry{ObjectInfo: obj, Name: name})
+				if len(entries) > maxObjects || total > maxBytes {
+					return nil, errArchiveTooLarge
+				}
+			}
+
+			if !res.IsTruncated || res.NextToken == "" {
+				break
+			}
+			opts.Token = res.NextToken
+		}
+	}
+	return entries, nil
+}
+
+func (h *handler) writeZip(ctx context.Context, w io.Writer, root string, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		fh := &zip.FileHeader{
+			Name:     root + "/" + e.Name,
+			Method:   zip.Deflate,
+			Modified: e.ModTime,
+		}
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if err := h.copyObject(ctx, fw, e); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (h *handler) writeTarGz(ctx context.Context, w io.Writer, root string, entries []archiveEntry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		obj, err := h.Backend.Get(ctx, e.Key, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Key, err)
+		}
+
+		// Tar headers need the size of an object before its contents, so it
+		// is taken from the object that is actually read, in case it has
+		// changed since it was listed
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     root + "/" + e.Name,
+			Size:     obj.Length,
+			Mode:     0o644,
+			ModTime:  obj.ModTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			obj.Body.Close()
+			return err
+		}
+		_, err = io.Copy(tw, obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Key, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// copyObject copies the contents of the object of e to w.
+func (h *handler) copyObject(ctx context.Context, w io.Writer, e archiveEntry) error {
+	obj, err := h.Backend.Get(ctx, e.Key, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.Key, err)
+	}
+	defer obj.Body.Close()
+
+	if _, err := io.Copy(w, obj.Body); err != nil {
+		return fmt.Errorf("%s: %w", e.Key, err)
+	}
+	return nil
+}
+
+// archiveName returns name, relative to the archived directory, cleaned of
+// leading slashes and of empty, "." and ".." segments, or false when it would
+// be extracted outside of the directory. Backslashes are refused too, since
+// some extractors take them to separate directories.
+func archiveName(name string) (string, bool) {
+	clean := pathpkg.Clean(strings.TrimLeft(name, "/"))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(clean, `\`) {
+		return "", false
+	}
+	return clean, true
+}
+
+// archiveRoot returns the name of the top-level directory in an archive of the
+// requested directory, which is also the base name of the archive itself.
+func archiveRoot(r *http.Request) string {
+	if name := pathpkg.Base(strings.TrimSuffix(requestPath(r), "/")); name != "." && name != ".." && name != "/" && !strings.Contains(name, `\`) {
+		return name
+	}
+	if host, _, _ := strings.Cut(r.Host, ":"); host != "" {
+		return host
+	}
+	return "archive"
+}
@@ -0,0 +1,215 @@
+package proxy_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+func newArchiveHandler(t *testing.T, b proxy.Backend, opts proxy.Options) http.Handler {
+	t.Helper()
+
+	enabled := true
+	opts.Autoindex, opts.AutoindexArchive = &enabled, &enabled
+	h, err := proxy.NewHandler(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// readArchive returns the contents of the zip or tar.gz archive in body, by
+// entry name.
+func readArchive(t *testing.T, format string, body []byte) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	switch format {
+	case proxy.ArchiveZip:
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name] = string(p)
+		}
+	case proxy.ArchiveTarGz:
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gr)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[hdr.Name] = string(p)
+		}
+	}
+	return files
+}
+
+func names(files map[string]string) []string {
+	var ns []string
+	for n := range files {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+func TestHandlerArchive(t *testing.T) {
+	tests := []struct {
+		format          string
+		wantContentType string
+	}{
+		{proxy.ArchiveZip, "application/zip"},
+		{proxy.ArchiveTarGz, "application/gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			h := newArchiveHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), proxy.Options{})
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dir/?archive="+tt.format, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if got, want := w.Header().Get("Content-Disposition"), `attachment; filename=dir.`+tt.format; got != want {
+				t.Errorf("Content-Disposition = %q, want %q", got, want)
+			}
+
+			want := map[string]string{
+				"dir/a.txt":     "a",
+				"dir/b.json":    `{"b":2}`,
+				"dir/sub/c.txt": "c",
+			}
+			if got := readArchive(t, tt.format, w.Body.Bytes()); !reflect.DeepEqual(got, want) {
+				t.Errorf("archive = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestHandlerArchiveHidden(t *testing.T) {
+	hideDotfiles := true
+	h := newArchiveHandler(t, proxytest.NewMemoryBackend([]proxytest.Object{
+		{Key: "dir/a.txt", Body: "a"},
+		{Key: "dir/b.json", Body: "b"},
+		{Key: "dir/.secret", Body: "s"},
+		{Key: "dir/sub/c.txt", Body: "c"},
+		{Key: "dir/keep/sub.txt", Body: "k"},
+	}), proxy.Options{
+		AutoindexHide:         []string{"*.json", "sub/"},
+		AutoindexHideDotfiles: &hideDotfiles,
+	})
+
+	for _, format := range proxy.ArchiveFormats {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dir/?archive="+format, nil))
+		got := names(readArchive(t, format, w.Body.Bytes()))
+		// Hidden directories are left out along with everything in them,
+		// while files named like them are not
+		if want := []string{"dir/a.txt", "dir/keep/sub.txt"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s entries = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestHandlerArchiveNames(t *testing.T) {
+	h := newArchiveHandler(t, proxytest.NewMemoryBackend([]proxytest.Object{
+		{Key: "dir/ok.txt", Body: "ok"},
+		{Key: "dir//slash.txt", Body: "slash"},
+		{Key: "dir/x/../dotdot.txt", Body: "dotdot"},
+		{Key: "dir/./dot.txt", Body: "dot"},
+		{Key: "dir/../../evil.txt", Body: "evil"},
+		{Key: "dir/x/../../../evil.txt", Body: "evil"},
+		{Key: `dir/..\evil.txt`, Body: "evil"},
+	}), proxy.Options{})
+
+	for _, format := range proxy.ArchiveFormats {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dir/?archive="+format, nil))
+		want := map[string]string{
+			"dir/ok.txt":     "ok",
+			"dir/slash.txt":  "slash",
+			"dir/dotdot.txt": "dotdot",
+			"dir/dot.txt":    "dot",
+		}
+		if got := readArchive(t, format, w.Body.Bytes()); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s archive = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestHandlerArchiveRoot(t *testing.T) {
+	h := newArchiveHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), proxy.Options{})
+
+	r := httptest.NewRequest(http.MethodGet, "/?archive=zip", nil)
+	r.Host = "files.example.com:8080"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Header().Get("Content-Disposition"), `attachment; filename=files.example.com.zip`; got != want {
+		t.Errorf("Content-Disposition = %q, want %q", got, want)
+	}
+	if got := readArchive(t, proxy.ArchiveZip, w.Body.Bytes()); got["files.example.com/hello.txt"] != "hello, world\n" {
+		t.Errorf("archive entries = %q, want them under the host name", names(got))
+	}
+}
+
+func TestHandlerArchiveErrors(t *testing.T) {
+	maxObjects, maxBytes := 2, int64(2)
+	tests := []struct {
+		name       string
+		opts       proxy.Options
+		target     string
+		wantStatus int
+	}{
+		{"unsupported format", proxy.Options{}, "/dir/?archive=rar", http.StatusBadRequest},
+		{"too many objects", proxy.Options{AutoindexArchiveMaxObjects: &maxObjects}, "/dir/?archive=zip", http.StatusForbidden},
+		{"too many bytes", proxy.Options{AutoindexArchiveMaxBytes: &maxBytes}, "/dir/?archive=zip", http.StatusForbidden},
+		{"within limits", proxy.Options{AutoindexArchiveMaxObjects: &maxObjects}, "/dir/sub/?archive=zip", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newArchiveHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), tt.opts)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Package audit records requests that change objects, such as uploads and
+// deletes, whether or not they succeed.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/auth"
+	"github.com/ripta/ssp/proxy/clientip"
+)
+
+// Log writes one JSON record per request.
+type Log struct {
+	log zerolog.Logger
+}
+
+// New returns a Log that writes records to w, which must be safe to write to
+// concurrently.
+func New(w io.Writer) *Log {
+	return &Log{log: zerolog.New(w).With().Timestamp().Logger()}
+}
+
+var (
+	filesMu sync.Mutex
+	files   = map[string]*Log{}
+)
+
+// Open returns a Log that appends records to the named file, which is created
+// if necessary. Handlers that audit to the same file share one Log.
+func Open(name string) (*Log, error) {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	if l, ok := files[name]; ok {
+		return l, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	l := New(f)
+	files[name] = l
+	return l, nil
+}
+
+type contextKey struct{}
+
+// entry is what is learned about a request while it is being served.
+type entry struct {
+	user string
+}
+
+// Handler records requests to h that may change objects once they have been
+// served. Records are written to l, or when it is nil, to the request logger.
+// So that requests refused by authentication, address restrictions or rate
+// limits are recorded too, it should be placed before them, with Identify
+// after authentication to record who made each request.
+func Handler(l *Log, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !proxy.IsWriteMethod(r.Method) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		e := &entry{}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, e)))
+		if user, ok := auth.User(r.Context()); ok {
+			e.user = user
+		}
+
+		// The request logger already knows who made the request, and how
+		var ev *zerolog.Event
+		if l == nil {
+			ev = hlog.FromRequest(r).Info()
+		} else {
+			ev = l.log.Log().
+				Str("method", r.Method).
+				Str("host", r.Host).
+				Str("path", r.URL.Path)
+			if id, ok := hlog.IDFromRequest(r); ok {
+				ev = ev.Str("request_id", id.String())
+			}
+			if e.user != "" {
+				ev = ev.Str("user", e.user)
+			}
+		}
+		if r.ContentLength >= 0 {
+			ev = ev.Int64("content_length", r.ContentLength)
+		}
+		if dst := r.Header.Get("Destination"); dst != "" {
+			ev = ev.Str("destination", dst)
+		}
+		ev.Bool("audit", true).
+			Str("client_ip", clientip.Addr(r).String()).
+			Int("status", sw.status()).
+			Str("etag", sw.Header().Get("ETag")).
+			Dur("duration_ms", time.Since(start)).
+			Msg("audit")
+	})
+}
+
+// Identify records who made requests to h, once they have been authenticated,
+// in the records of the Handler that they are served by.
+func Identify(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e, ok := r.Context().Value(contextKey{}).(*entry); ok {
+			if user, ok := auth.User(r.Context()); ok {
+				e.user = user
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// statusWriter remembers the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
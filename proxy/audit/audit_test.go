@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ripta/ssp/proxy/auth"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := Handler(New(&buf), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	get := httptest.NewRequest(http.MethodGet, "/reports/q3.csv", nil)
+	h.ServeHTTP(httptest.NewRecorder(), get)
+	if buf.Len() > 0 {
+		t.Fatalf("GET was audited: %s", buf.String())
+	}
+
+	put := httptest.NewRequest(http.MethodPut, "/reports/q3.csv", strings.NewReader("a,b\n"))
+	put.RemoteAddr = "192.0.2.10:54321"
+	put = put.WithContext(auth.WithIdentity(put.Context(), auth.Identity{User: "ripta"}))
+	h.ServeHTTP(httptest.NewRecorder(), put)
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("could not parse audit record %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"audit":          true,
+		"method":         "PUT",
+		"host":           "example.com",
+		"path":           "/reports/q3.csv",
+		"user":           "ripta",
+		"client_ip":      "192.0.2.10",
+		"status":         float64(http.StatusCreated),
+		"etag":           `"abc"`,
+		"content_length": float64(4),
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("audit record %s = %v, want %v", k, rec[k], v)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	l1, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l1 != l2 {
+		t.Errorf("Open(%q) twice returned different logs", name)
+	}
+
+	Handler(l1, http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/a.txt", nil))
+	p, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(p, []byte(`"method":"DELETE"`)) {
+		t.Errorf("audit log = %q, want a DELETE record", p)
+	}
+
+	// WebDAV copies and moves record where objects were copied to
+	move := httptest.NewRequest("MOVE", "/a.txt", nil)
+	move.Header.Set("Destination", "https://example.com/b.txt")
+	Handler(l1, http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), move)
+	if p, err = os.ReadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(p, []byte(`"destination":"https://example.com/b.txt"`)) {
+		t.Errorf("audit log = %q, want a MOVE record with its destination", p)
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing", "audit.log")); err == nil {
+		t.Error("Open() in a missing directory succeeded")
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	var buf bytes.Buffer
+	ok := Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// Stands in for authentication, which refuses requests without a user
+	h := Handler(New(&buf), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-User")
+		if user == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ok.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{User: user})))
+	}))
+
+	tests := []struct {
+		user       string
+		wantUser   interface{}
+		wantStatus float64
+	}{
+		{"ripta", "ripta", http.StatusNoContent},
+		{"", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodDelete, "/a.txt", nil)
+		r.Header.Set("X-User", tt.user)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		var rec map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+			t.Fatalf("could not parse audit record %q: %v", buf.String(), err)
+		}
+		if rec["user"] != tt.wantUser || rec["status"] != tt.wantStatus {
+			t.Errorf("audit record user, status = %v, %v; want %v, %v", rec["user"], rec["status"], tt.wantUser, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package auth authenticates requests before they reach a backend.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request does
+	// not carry any credentials that it understands.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned by an Authenticator when the request
+	// carries credentials, but they are not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity is who a request was authenticated as.
+type Identity struct {
+	User string
+	// Prefixes, when not nil, are the only key prefixes that the user may
+	// access. See RequirePrefixes.
+	Prefixes []string
+}
+
+// Allows reports whether the identity may access key p. Prefixes are treated
+// as directories, so that "/users/ripta" allows "/users/ripta/a.txt" but not
+// "/users/riptide/a.txt".
+func (id Identity) Allows(p string) bool {
+	if id.Prefixes == nil {
+		return true
+	}
+	for _, prefix := range id.Prefixes {
+		d := strings.TrimSuffix(prefix, "/")
+		if p == d || strings.HasPrefix(p, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the user making a request.
+type Authenticator interface {
+	// Authenticate identifies the user making the request. It returns
+	// ErrNoCredentials or ErrInvalidCredentials when the user cannot be
+	// identified, and any other error when authentication itself failed.
+	Authenticate(r *http.Request) (Identity, error)
+	// Challenge responds to a request that could not be authenticated, e.g.,
+	// with a WWW-Authenticate header.
+	Challenge(w http.ResponseWriter, r *http.Request)
+}
+
+type contextKey struct {
+	name string
+}
+
+var contextKeyIdentity = &contextKey{"identity"}
+
+// FromContext returns the authenticated identity of a request, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKeyIdentity).(Identity)
+	return id, ok
+}
+
+// User returns the authenticated user of a request, if any.
+func User(ctx context.Context) (string, bool) {
+	id, ok := FromContext(ctx)
+	return id.User, ok
+}
+
+// WithIdentity returns a copy of ctx carrying the authenticated identity.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKeyIdentity, id)
+}
+
+// Require only lets requests through to h once a authenticates them. The
+// authenticated user is added to the request's context and logger, and so
+// appears in the access log. Responses are marked private, since shared
+// caches cannot tell that requests authenticated by cookies or signed URLs
+// were authenticated at all.
+func Require(a Authenticator, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := hlog.FromRequest(r)
+		if i, ok := a.(Interceptor); ok && i.Intercept(w, r) {
+			return
+		}
+
+		id, err := a.Authenticate(r)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrNoCredentials), errors.Is(err, ErrInvalidCredentials):
+			if errors.Is(err, ErrInvalidCredentials) {
+				log.Warn().Err(err).Msg("authentication failed")
+			}
+			a.Challenge(w, r)
+			return
+		default:
+			log.Error().Err(err).Msg("authentication error")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		log.UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Str("user", id.User)
+		})
+		h.ServeHTTP(&privateResponseWriter{ResponseWriter: w}, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// privateResponseWriter marks a response as private before it is written.
+type privateResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *privateResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		h.Set("Cache-Control", privateCacheControl(h.Get("Cache-Control")))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *privateResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *privateResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// privateCacheControl returns cc with "public" replaced by "private".
+func privateCacheControl(cc string) string {
+	ds := []string{"private"}
+	for _, d := range strings.Split(cc, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" || strings.EqualFold(d, "public") || strings.EqualFold(d, "private") {
+			continue
+		}
+		ds = append(ds, d)
+	}
+	return strings.Join(ds, ", ")
+}
+
+// RequirePrefixes refuses requests for keys outside of the authenticated
+// user's prefixes, including the destination of WebDAV copies and moves.
+// Since it checks the request path as a key, it must be placed after the path
+// is rewritten.
+func RequirePrefixes(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := FromContext(r.Context()); ok {
+			for _, key := range requestKeys(r) {
+				if !id.Allows(key) {
+					hlog.FromRequest(r).Warn().Str("key", key).Msg("key is outside of the user's prefixes")
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestKeys returns the keys that r refers to, as paths: that of the request
+// URL, followed by the destination of a WebDAV copy or move, if any.
+func requestKeys(r *http.Request) []string {
+	keys := []string{r.URL.Path}
+	if dst, ok := proxy.Destination(r); ok {
+		keys = append(keys, dst)
+	}
+	return keys
+}
+
+// RequireWritePrefixes refuses requests that change objects, such as uploads
+// and deletes, for keys outside of prefixes, after "{user}" in them is
+// substituted with the authenticated user. WebDAV copies only change their
+// destination, while moves change both their source and destination.
+// Unauthenticated requests, and those of users none of whose prefixes can be
+// expanded, may not change any object, and other requests outside of prefixes
+// are marked as read-only. Like RequirePrefixes, it must be placed after the
+// path is rewritten.
+func RequireWritePrefixes(prefixes []string, h http.Handler) http.Handler {
+	if len(prefixes) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := requestKeys(r)
+		if r.Method == proxy.MethodCopy {
+			keys = keys[1:]
+		}
+
+		// Names that are empty or contain a slash expand to no prefixes
+		id, ok := FromContext(r.Context())
+		writable := Identity{Prefixes: expandPrefixes(prefixes, map[string]interface{}{"user": id.User})}
+		denied := r.URL.Path
+		if ok && len(writable.Prefixes) > 0 {
+			denied = ""
+			for _, key := range keys {
+				if !writable.Allows(key) {
+					denied = key
+					break
+				}
+			}
+		}
+		if denied == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !proxy.IsWriteMethod(r.Method) {
+			h.ServeHTTP(w, r.WithContext(proxy.WithReadOnly(r.Context())))
+			return
+		}
+		hlog.FromRequest(r).Warn().Str("key", denied).Msg("key is outside of the user's write prefixes")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+type anyOf []Authenticator
+
+// Any combines authenticators, the first of which to recognize a request's
+// credentials authenticates it. Requests without any credentials are
+// challenged by the first authenticator.
+func Any(as ...Authenticator) Authenticator {
+	if len(as) == 1 {
+		return as[0]
+	}
+	return anyOf(as)
+}
+
+func (as anyOf) Authenticate(r *http.Request) (Identity, error) {
+	for _, a := range as {
+		id, err := a.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return id, err
+		}
+	}
+	return Identity{}, ErrNoCredentials
+}
+
+func (as anyOf) Challenge(w http.ResponseWriter, r *http.Request) {
+	as[0].Challenge(w, r)
+}
+
+func (as anyOf) Intercept(w http.ResponseWriter, r *http.Request) bool {
+	for _, a := range as {
+		if i, ok := a.(Interceptor); ok && i.Intercept(w, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+type staticAuthenticator struct {
+	id  Identity
+	err error
+}
+
+func (a staticAuthenticator) Authenticate(*http.Request) (Identity, error) {
+	return a.id, a.err
+}
+
+func (a staticAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func TestRequirePrivate(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         string
+	}{
+		{"", "private"},
+		{"public, max-age=60", "private, max-age=60"},
+		{"max-age=60, PUBLIC", "private, max-age=60"},
+		{"private, no-store", "private, no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cacheControl, func(t *testing.T) {
+			h := Require(staticAuthenticator{id: Identity{User: "ripta"}}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.cacheControl != "" {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+				}
+				w.Write([]byte("hello"))
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got := w.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequirePrefixes(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		destination string
+		wantStatus  int
+	}{
+		{"inside", "/ci/a.txt", "", http.StatusOK},
+		{"outside", "/qa/a.txt", "", http.StatusForbidden},
+		{"destination inside", "/ci/a.txt", "/ci/b.txt", http.StatusOK},
+		{"destination outside", "/ci/a.txt", "/qa/b.txt", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(proxy.MethodCopy, tt.path, nil)
+			if tt.destination != "" {
+				r.Header.Set("Destination", tt.destination)
+			}
+			r = r.WithContext(WithIdentity(r.Context(), Identity{User: "ci", Prefixes: []string{"/ci/"}}))
+			w := httptest.NewRecorder()
+			RequirePrefixes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAny(t *testing.T) {
+	none := staticAuthenticator{err: ErrNoCredentials}
+	invalid := staticAuthenticator{err: ErrInvalidCredentials}
+	ripta := staticAuthenticator{id: Identity{User: "ripta"}}
+
+	tests := []struct {
+		name     string
+		as       []Authenticator
+		wantUser string
+		wantErr  error
+	}{
+		{"first", []Authenticator{ripta, none}, "ripta", nil},
+		{"second", []Authenticator{none, ripta}, "ripta", nil},
+		{"none", []Authenticator{none, none}, "", ErrNoCredentials},
+		{"invalid stops", []Authenticator{invalid, ripta}, "", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := Any(tt.as...).Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if id.User != tt.wantUser {
+				t.Errorf("user = %q, want %q", id.User, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRequireWritePrefixes(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if proxy.ReadOnly(r.Context()) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		destination string
+		id          *Identity
+		wantStatus  int
+	}{
+		{"download", http.MethodGet, "/users/ripta/a.txt", "", &Identity{User: "ripta"}, http.StatusNoContent},
+		{"download elsewhere", http.MethodGet, "/users/riptide/a.txt", "", &Identity{User: "ripta"}, http.StatusOK},
+		{"upload", http.MethodPut, "/users/ripta/a.txt", "", &Identity{User: "ripta"}, http.StatusNoContent},
+		{"shared", http.MethodDelete, "/shared/a.txt", "", &Identity{User: "ripta"}, http.StatusNoContent},
+		{"upload elsewhere", http.MethodPut, "/users/riptide/a.txt", "", &Identity{User: "ripta"}, http.StatusForbidden},
+		{"unsafe user", http.MethodPut, "/users/../a.txt", "", &Identity{User: ".."}, http.StatusForbidden},
+		{"unauthenticated", http.MethodPut, "/shared/a.txt", "", nil, http.StatusForbidden},
+		{"listing elsewhere", proxy.MethodPropfind, "/users/riptide/", "", &Identity{User: "ripta"}, http.StatusOK},
+		{"copy from elsewhere", proxy.MethodCopy, "/users/riptide/a.txt", "/users/ripta/a.txt", &Identity{User: "ripta"}, http.StatusNoContent},
+		{"copy to elsewhere", proxy.MethodCopy, "/users/ripta/a.txt", "/users/riptide/a.txt", &Identity{User: "ripta"}, http.StatusForbidden},
+		{"move from elsewhere", proxy.MethodMove, "/users/riptide/a.txt", "/users/ripta/a.txt", &Identity{User: "ripta"}, http.StatusForbidden},
+		{"move to elsewhere", proxy.MethodMove, "/users/ripta/a.txt", "http://example.com/users/ripta/../../a.txt", &Identity{User: "ripta"}, http.StatusForbidden},
+		{"move", proxy.MethodMove, "/users/ripta/a.txt", "/shared/a.txt", &Identity{User: "ripta"}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.destination != "" {
+				r.Header.Set("Destination", tt.destination)
+			}
+			if tt.id != nil {
+				r = r.WithContext(WithIdentity(r.Context(), *tt.id))
+			}
+			w := httptest.NewRecorder()
+			RequireWritePrefixes([]string{"/users/{user}/", "/shared/"}, ok).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// Users whose only prefix cannot be expanded may not write anywhere
+	for _, user := range []string{"", ".", "..", "a/b", `a\b`, "alice"} {
+		for method, wantStatus := range map[string]int{http.MethodPut: http.StatusForbidden, http.MethodGet: http.StatusOK} {
+			r := httptest.NewRequest(method, "/anywhere/else.txt", nil)
+			r = r.WithContext(WithIdentity(r.Context(), Identity{User: user}))
+			w := httptest.NewRecorder()
+			RequireWritePrefixes([]string{"/users/{user}/"}, ok).ServeHTTP(w, r)
+			if w.Code != wantStatus {
+				t.Errorf("%s by %q status = %d, want %d", method, user, w.Code, wantStatus)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/reload"
+)
+
+// BackendPrefix marks a credentials source that is an object in the handler's
+// own backend, rather than a local file, as with autoindex templates.
+const BackendPrefix = proxy.BackendTemplatePrefix
+
+const (
+	DefaultRealm          = "ssp"
+	DefaultReloadInterval = time.Minute
+)
+
+// BasicConfig configures HTTP Basic authentication.
+type BasicConfig struct {
+	// Realm is shown to users when they are prompted for credentials.
+	Realm string
+	// Htpasswd is either the path to a local htpasswd file, or "backend:"
+	// followed by the key of an htpasswd object in Backend.
+	Htpasswd string
+	// ReloadInterval is how often the htpasswd file is reloaded.
+	ReloadInterval time.Duration
+
+	Backend proxy.Backend
+}
+
+type basic struct {
+	realm string
+	users *reload.Loader[Htpasswd]
+}
+
+// NewBasic creates an Authenticator for HTTP Basic authentication against an
+// htpasswd file. A local file must exist and be valid at startup, while one
+// in a backend is only loaded once it is needed.
+func NewBasic(c BasicConfig) (Authenticator, error) {
+	interval := c.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	var read func(context.Context) ([]byte, error)
+	key, inBackend := strings.CutPrefix(c.Htpasswd, BackendPrefix)
+	if inBackend {
+		if c.Backend == nil {
+			return nil, fmt.Errorf("htpasswd %q requires an object storage backend", c.Htpasswd)
+		}
+		key = strings.TrimPrefix(key, "/")
+		read = func(ctx context.Context) ([]byte, error) {
+			obj, err := c.Backend.Get(ctx, key, nil)
+			if err != nil {
+				return nil, err
+			}
+			defer obj.Body.Close()
+			return io.ReadAll(obj.Body)
+		}
+	} else {
+		read = func(context.Context) ([]byte, error) {
+			return os.ReadFile(c.Htpasswd)
+		}
+	}
+
+	l := reload.New(interval, func(ctx context.Context) (Htpasswd, error) {
+		data, err := read(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not load htpasswd: %w", err)
+		}
+		users, err := ParseHtpasswd(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse htpasswd: %w", err)
+		}
+		return users, nil
+	})
+	if !inBackend {
+		if _, err := l.Get(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	realm := c.Realm
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	return &basic{realm: realm, users: l}, nil
+}
+
+func (b *basic) Authenticate(r *http.Request) (Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+
+	users, err := b.users.Get(r.Context())
+	if err != nil {
+		if users == nil {
+			return Identity{}, err
+		}
+		hlog.FromRequest(r).Warn().Err(err).Msg("could not reload htpasswd; using previous credentials")
+	}
+
+	if !users.Verify(user, password) {
+		return Identity{}, fmt.Errorf("user %q: %w", user, ErrInvalidCredentials)
+	}
+	return Identity{User: user}, nil
+}
+
+func (b *basic) Challenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(b.realm)+`, charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+func shaHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantUsers int
+		wantErr   bool
+	}{
+		{"empty", "", 0, false},
+		{"comments and blank lines", "# users\n\nalice:{SHA}x\n", 1, false},
+		{"bcrypt variants", "a:$2a$x\nb:$2b$x\nc:$2y$x\n", 3, false},
+		{"missing hash", "alice\n", 0, true},
+		{"missing user", ":{SHA}x\n", 0, true},
+		{"md5", "alice:$apr1$salt$hash\n", 0, true},
+		{"crypt", "alice:abJnggxhB/yWI\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseHtpasswd([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHtpasswd() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(h) != tt.wantUsers {
+				t.Errorf("got %d users, want %d", len(h), tt.wantUsers)
+			}
+		})
+	}
+}
+
+func TestHtpasswdVerify(t *testing.T) {
+	h := Htpasswd{
+		"alice": bcryptHash(t, "wonderland"),
+		"bob":   shaHash("builder"),
+	}
+	tests := []struct {
+		user, password string
+		want           bool
+	}{
+		{"alice", "wonderland", true},
+		{"alice", "Wonderland", false},
+		{"alice", "", false},
+		{"bob", "builder", true},
+		{"bob", "wonderland", false},
+		{"carol", "wonderland", false},
+	}
+	for _, tt := range tests {
+		if got := h.Verify(tt.user, tt.password); got != tt.want {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tt.user, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestRequireBasic(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(name, []byte("alice:"+bcryptHash(t, "wonderland")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewBasic(BasicConfig{Realm: "Private files", Htpasswd: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	var gotUser string
+	h := hlog.NewHandler(zerolog.New(&logs))(
+		hlog.AccessHandler(func(r *http.Request, status, size int, dur time.Duration) {
+			hlog.FromRequest(r).Info().Int("status", status).Msg("request")
+		})(Require(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotUser, _ = User(r.Context())
+		}))),
+	)
+
+	tests := []struct {
+		name       string
+		user       string
+		password   string
+		wantStatus int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "alice", "looking-glass", http.StatusUnauthorized},
+		{"unknown user", "bob", "wonderland", http.StatusUnauthorized},
+		{"valid", "alice", "wonderland", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			gotUser = ""
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized {
+				if got, want := w.Header().Get("WWW-Authenticate"), `Basic realm="Private files", charset="UTF-8"`; got != want {
+					t.Errorf("WWW-Authenticate = %q, want %q", got, want)
+				}
+				return
+			}
+			if gotUser != tt.user {
+				t.Errorf("user = %q, want %q", gotUser, tt.user)
+			}
+			if !strings.Contains(logs.String(), `"user":"alice"`) {
+				t.Errorf("access log does not name the user: %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestBasicMissingFile(t *testing.T) {
+	if _, err := NewBasic(BasicConfig{Htpasswd: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("NewBasic() with a missing htpasswd file succeeded")
+	}
+}
+
+func TestBasicBackendReload(t *testing.T) {
+	b := proxytest.NewMemoryBackend([]proxytest.Object{
+		{Key: "_auth/htpasswd", Body: "alice:" + shaHash("wonderland") + "\n"},
+	})
+	a, err := NewBasic(BasicConfig{Htpasswd: "backend:_auth/htpasswd", Backend: b, ReloadInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticate := func() error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth("alice", "wonderland")
+		_, err := a.Authenticate(r)
+		return err
+	}
+	if err := authenticate(); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	// Previously-loaded credentials are kept when the backend fails
+	b.Err = os.ErrDeadlineExceeded
+	if err := authenticate(); err != nil {
+		t.Errorf("Authenticate() after a failed reload error = %v", err)
+	}
+}
+
+func TestBasicBackendUnavailable(t *testing.T) {
+	b := proxytest.NewMemoryBackend(nil)
+	a, err := NewBasic(BasicConfig{Htpasswd: "backend:_auth/htpasswd", Backend: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "wonderland")
+	w := httptest.NewRecorder()
+	Require(a, http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
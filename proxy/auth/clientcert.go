@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ClientCertConfig configures authentication with TLS client certificates.
+type ClientCertConfig struct {
+	// CAFile is a PEM bundle of the certificate authorities that issue
+	// client certificates. Certificates are verified against it even when
+	// they were already verified during the TLS handshake, since the
+	// handshake may have been for another host, with other authorities.
+	CAFile string
+
+	// Certificates are allowed when their subject's common name is in
+	// CommonNames, any of their subject alternative names (DNS names, email
+	// addresses, URIs or IP addresses) is in SANs, or any of their subject's
+	// organizational units is in OrganizationalUnits. When none of these are
+	// set, any certificate issued by the authorities is allowed.
+	CommonNames         []string
+	SANs                []string
+	OrganizationalUnits []string
+
+	// Prefixes, when set, restrict clients to keys under any of them, as in
+	// JWTConfig. Each may contain "{cn}", "{ou}", "{o}", "{dns}", "{email}"
+	// or "{uri}", which are substituted with the first such value in the
+	// certificate.
+	Prefixes []string
+}
+
+type clientCert struct {
+	config ClientCertConfig
+	roots  *x509.CertPool
+}
+
+// NewClientCert creates an Authenticator for clients that present a
+// certificate during the TLS handshake.
+func NewClientCert(c ClientCertConfig) (Authenticator, error) {
+	if c.CAFile == "" {
+		return nil, errors.New("client certificates require a CA file")
+	}
+	pem, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA file: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %q", c.CAFile)
+	}
+	return &clientCert{config: c, roots: roots}, nil
+}
+
+func (c *clientCert) Authenticate(r *http.Request) (Identity, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, ic := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(ic)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return Identity{}, fmt.Errorf("client certificate %q: %v: %w", cert.Subject, err, ErrInvalidCredentials)
+	}
+
+	names := certNames(cert)
+	if !c.allows(cert, names) {
+		return Identity{}, fmt.Errorf("client certificate %q is not allowed: %w", cert.Subject, ErrInvalidCredentials)
+	}
+
+	id := Identity{User: cert.Subject.CommonName}
+	if id.User == "" && len(names) > 0 {
+		id.User = names[0]
+	}
+	if id.User == "" {
+		id.User = cert.Subject.String()
+	}
+
+	if len(c.config.Prefixes) > 0 {
+		id.Prefixes = expandPrefixes(c.config.Prefixes, certClaims(cert))
+		if len(id.Prefixes) == 0 {
+			return Identity{}, fmt.Errorf("client certificate %q has none of the names in its prefixes: %w", cert.Subject, ErrInvalidCredentials)
+		}
+	}
+	return id, nil
+}
+
+func (c *clientCert) allows(cert *x509.Certificate, names []string) bool {
+	cc := c.config
+	if len(cc.CommonNames) == 0 && len(cc.SANs) == 0 && len(cc.OrganizationalUnits) == 0 {
+		return true
+	}
+	return contains(cc.CommonNames, cert.Subject.CommonName) ||
+		containsAny(cc.SANs, names) ||
+		containsAny(cc.OrganizationalUnits, cert.Subject.OrganizationalUnit)
+}
+
+// Challenge refuses the request, since a client certificate can only be
+// presented during the TLS handshake.
+func (c *clientCert) Challenge(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "A valid client certificate is required.", http.StatusForbidden)
+}
+
+// certNames returns the subject alternative names of a certificate.
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// certClaims returns the values of a certificate that may be substituted
+// into prefixes.
+func certClaims(cert *x509.Certificate) map[string]interface{} {
+	claims := map[string]interface{}{"cn": cert.Subject.CommonName}
+	first := func(name string, vs []string) {
+		if len(vs) > 0 {
+			claims[name] = vs[0]
+		}
+	}
+	first("ou", cert.Subject.OrganizationalUnit)
+	first("o", cert.Subject.Organization)
+	first("dns", cert.DNSNames)
+	first("email", cert.EmailAddresses)
+	if len(cert.URIs) > 0 {
+		claims["uri"] = cert.URIs[0].String()
+	}
+	return claims
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(ss, vs []string) bool {
+	for _, v := range vs {
+		if contains(ss, v) {
+			return true
+		}
+	}
+	return false
+}
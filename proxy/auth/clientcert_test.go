@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+func TestClientCert(t *testing.T) {
+	ca, other := proxytest.NewCA(t, "Internal CA"), proxytest.NewCA(t, "Other CA")
+	spiffe, _ := url.Parse("spiffe://routed.cloud/builder")
+
+	service := ca.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "svc-reports", OrganizationalUnit: []string{"platform"}}})
+	builder := ca.Issue(t, &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"ci"}}, URIs: []*url.URL{spiffe}})
+	intern := ca.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "intern", OrganizationalUnit: []string{"interns"}}})
+	impostor := other.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "svc-reports", OrganizationalUnit: []string{"platform"}}})
+	server := ca.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "svc-reports"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+
+	a, err := NewClientCert(ClientCertConfig{
+		CAFile:              ca.WriteFile(t),
+		SANs:                []string{"spiffe://routed.cloud/builder"},
+		OrganizationalUnits: []string{"platform"},
+		Prefixes:            []string{"/services/{cn}/", "/teams/{ou}/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		cert         *tls.Certificate
+		wantUser     string
+		wantPrefixes []string
+		wantErr      error
+	}{
+		{"no certificate", nil, "", nil, ErrNoCredentials},
+		{"organizational unit", &service, "svc-reports", []string{"/services/svc-reports/", "/teams/platform/"}, nil},
+		{"uri", &builder, "spiffe://routed.cloud/builder", []string{"/teams/ci/"}, nil},
+		{"not allowed", &intern, "", nil, ErrInvalidCredentials},
+		{"other authority", &impostor, "", nil, ErrInvalidCredentials},
+		{"server certificate", &server, "", nil, ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://internal.routed.cloud/", nil)
+			if tt.cert != nil {
+				r.TLS.PeerCertificates = []*x509.Certificate{tt.cert.Leaf}
+			}
+			id, err := a.Authenticate(r)
+			if tt.wantErr != nil {
+				if err == nil || !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if id.User != tt.wantUser || !reflect.DeepEqual(id.Prefixes, tt.wantPrefixes) {
+				t.Errorf("identity = %+v, want user %q with prefixes %q", id, tt.wantUser, tt.wantPrefixes)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	Require(a, http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://internal.routed.cloud/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status without a certificate = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestNewClientCertErrors(t *testing.T) {
+	for _, name := range []string{"", filepath.Join(t.TempDir(), "missing.pem")} {
+		if _, err := NewClientCert(ClientCertConfig{CAFile: name}); err == nil {
+			t.Errorf("NewClientCert(%q) succeeded", name)
+		}
+	}
+}
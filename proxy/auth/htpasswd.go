@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when a user does not exist, so that unknown
+// users take as long to reject as known users with the wrong password.
+const dummyHash = "$2a$10$0QoDIJs6oqu6JWNUwTGIJe.D0pKZRVwvCZ2N1LrEli.XC7l0nZnza"
+
+// Htpasswd holds the users and password hashes of an Apache htpasswd file.
+// Only bcrypt ("$2y$") and SHA-1 ("{SHA}") hashes are supported; the latter
+// only for compatibility, since it is unsalted.
+type Htpasswd map[string]string
+
+// ParseHtpasswd parses the contents of an htpasswd file. Blank lines and lines
+// beginning with "#" are ignored.
+func ParseHtpasswd(data []byte) (Htpasswd, error) {
+	h := Htpasswd{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", n)
+		}
+		if !supportedHash(hash) {
+			return nil, fmt.Errorf("line %d: unsupported hash for user %q; only bcrypt and {SHA} are supported", n, user)
+		}
+		h[user] = hash
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func supportedHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "{SHA}")
+}
+
+// Verify reports whether password is that of user.
+func (h Htpasswd) Verify(user, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+		return false
+	}
+
+	if b64, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(password))
+		want := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(b64), []byte(want)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
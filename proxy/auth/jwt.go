@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/reload"
+)
+
+// DefaultClockSkew is how far the clocks of token issuers and ssp may drift
+// apart before tokens are considered expired or not yet valid.
+const DefaultClockSkew = jwt.DefaultLeeway
+
+var (
+	hmacAlgorithms = []jose.SignatureAlgorithm{jose.HS256, jose.HS384, jose.HS512}
+	keyAlgorithms  = []jose.SignatureAlgorithm{
+		jose.RS256, jose.RS384, jose.RS512,
+		jose.PS256, jose.PS384, jose.PS512,
+		jose.ES256, jose.ES384, jose.ES512,
+		jose.EdDSA,
+	}
+
+	reClaimSubstitution = regexp.MustCompile(`{[^}]+}`)
+)
+
+// JWTConfig configures authentication with JSON Web Tokens, which clients
+// send as bearer tokens.
+type JWTConfig struct {
+	// Realm is named in the challenge to clients without a token.
+	Realm string
+	// Keys verify the signatures of tokens. Each is either the path to a
+	// local file holding PEM-encoded public keys or certificates, or a JWKS;
+	// or the URL of a JWKS. Keys are reloaded every ReloadInterval.
+	Keys           []string
+	ReloadInterval time.Duration
+	// HMACSecret, when set, is a reference to a shared secret that verifies
+	// HMAC-signed tokens.
+	HMACSecret string
+
+	// Issuer and Audience, when set, must match those of tokens.
+	Issuer    string
+	Audience  string
+	ClockSkew time.Duration
+
+	// Prefixes, when set, restrict users to keys under any of them. Each
+	// may contain "{claim}", which is substituted with the value of that
+	// claim in the token, e.g., "/users/{sub}/". A prefix whose claims are
+	// missing, or not single path segments, is ignored.
+	Prefixes []string
+}
+
+type jwtAuth struct {
+	config     JWTConfig
+	algorithms []jose.SignatureAlgorithm
+	hmacKey    []byte
+	keys       *reload.Loader[[]jose.JSONWebKey]
+}
+
+// NewJWT creates an Authenticator for bearer tokens. Local key files must
+// exist and be valid at startup, while those at URLs are only loaded once
+// they are needed.
+func NewJWT(c JWTConfig) (Authenticator, error) {
+	a := &jwtAuth{config: c}
+	if a.config.Realm == "" {
+		a.config.Realm = DefaultRealm
+	}
+	if a.config.ClockSkew <= 0 {
+		a.config.ClockSkew = DefaultClockSkew
+	}
+
+	if c.HMACSecret != "" {
+		secret, err := proxy.ResolveReference(c.HMACSecret)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve hmac secret: %w", err)
+		}
+		if len(secret) < MinCookieSecretLength {
+			return nil, fmt.Errorf("hmac secret must be at least %d bytes", MinCookieSecretLength)
+		}
+		a.hmacKey = []byte(secret)
+		a.algorithms = append(a.algorithms, hmacAlgorithms...)
+	}
+
+	if len(c.Keys) > 0 {
+		for _, src := range c.Keys {
+			if isURL(src) {
+				continue
+			}
+			if _, err := loadKeys(context.Background(), src); err != nil {
+				return nil, err
+			}
+		}
+		interval := c.ReloadInterval
+		if interval <= 0 {
+			interval = DefaultReloadInterval
+		}
+		a.keys = reload.New(interval, func(ctx context.Context) ([]jose.JSONWebKey, error) {
+			return loadAllKeys(ctx, c.Keys)
+		})
+		a.algorithms = append(a.algorithms, keyAlgorithms...)
+	}
+
+	if len(a.algorithms) == 0 {
+		return nil, errors.New("jwt requires keys or an hmac secret")
+	}
+	return a, nil
+}
+
+func (a *jwtAuth) Authenticate(r *http.Request) (Identity, error) {
+	scheme, raw, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Identity{}, ErrNoCredentials
+	}
+
+	tok, err := jwt.ParseSigned(strings.TrimSpace(raw), a.algorithms)
+	if err != nil {
+		return Identity{}, fmt.Errorf("token: %v: %w", err, ErrInvalidCredentials)
+	}
+
+	var claims jwt.Claims
+	var extra map[string]interface{}
+	if err := a.verify(r, tok, &claims, &extra); err != nil {
+		return Identity{}, err
+	}
+
+	expected := jwt.Expected{Issuer: a.config.Issuer, Time: time.Now()}
+	if a.config.Audience != "" {
+		expected.AnyAudience = jwt.Audience{a.config.Audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, a.config.ClockSkew); err != nil {
+		return Identity{}, fmt.Errorf("token for %q: %v: %w", claims.Subject, err, ErrInvalidCredentials)
+	}
+	if claims.Expiry == nil {
+		return Identity{}, fmt.Errorf("token for %q does not expire: %w", claims.Subject, ErrInvalidCredentials)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("token has no subject: %w", ErrInvalidCredentials)
+	}
+
+	id := Identity{User: claims.Subject}
+	if len(a.config.Prefixes) > 0 {
+		id.Prefixes = expandPrefixes(a.config.Prefixes, extra)
+		if len(id.Prefixes) == 0 {
+			return Identity{}, fmt.Errorf("token for %q has none of the claims in its prefixes: %w", claims.Subject, ErrInvalidCredentials)
+		}
+	}
+	return id, nil
+}
+
+// verify checks the signature of tok against each key that could have signed
+// it, and decodes its claims into out.
+func (a *jwtAuth) verify(r *http.Request, tok *jwt.JSONWebToken, out ...interface{}) error {
+	var kid string
+	if len(tok.Headers) > 0 {
+		kid = tok.Headers[0].KeyID
+	}
+
+	var candidates []interface{}
+	if a.hmacKey != nil {
+		candidates = append(candidates, a.hmacKey)
+	}
+	if a.keys != nil {
+		keys, err := a.keys.Get(r.Context())
+		if err != nil {
+			if keys == nil {
+				return err
+			}
+			hlog.FromRequest(r).Warn().Err(err).Msg("could not reload jwt keys; using previous keys")
+		}
+		for _, k := range keys {
+			if kid == "" || k.KeyID == "" || k.KeyID == kid {
+				candidates = append(candidates, k.Key)
+			}
+		}
+	}
+
+	for _, k := range candidates {
+		if err := tok.Claims(k, out...); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("token signature could not be verified: %w", ErrInvalidCredentials)
+}
+
+func (a *jwtAuth) Challenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer realm="+strconv.Quote(a.config.Realm))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// expandPrefixes substitutes claims into prefixes. Prefixes that refer to
+// claims that are missing, or that could escape a single path segment, are
+// dropped.
+func expandPrefixes(prefixes []string, claims map[string]interface{}) []string {
+	var ps []string
+	for _, p := range prefixes {
+		ok := true
+		expanded := reClaimSubstitution.ReplaceAllStringFunc(p, func(in string) string {
+			v, _ := claims[in[1:len(in)-1]].(string)
+			if v == "" || v == "." || v == ".." || strings.ContainsAny(v, "/\\") {
+				ok = false
+			}
+			return v
+		})
+		if ok {
+			ps = append(ps, expanded)
+		}
+	}
+	return ps
+}
+
+// loadAllKeys loads the keys in each of sources.
+func loadAllKeys(ctx context.Context, sources []string) ([]jose.JSONWebKey, error) {
+	var keys []jose.JSONWebKey
+	for _, src := range sources {
+		ks, err := loadKeys(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ks...)
+	}
+	return keys, nil
+}
+
+func isURL(src string) bool {
+	return strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://")
+}
+
+// loadKeys loads the public keys in a local file or at a URL.
+func loadKeys(ctx context.Context, src string) ([]jose.JSONWebKey, error) {
+	var data []byte
+	var err error
+	if isURL(src) {
+		data, err = fetchKeys(ctx, src)
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load jwt keys from %s: %w", src, err)
+	}
+
+	keys, err := parseKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse jwt keys from %s: %w", src, err)
+	}
+	return keys, nil
+}
+
+func fetchKeys(ctx context.Context, u string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseKeys parses either a JWKS or PEM-encoded public keys and certificates.
+// Only the public half of any private key is kept.
+func parseKeys(data []byte) ([]jose.JSONWebKey, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		var set jose.JSONWebKeySet
+		if err := json.Unmarshal(data, &set); err != nil {
+			return nil, err
+		}
+		var keys []jose.JSONWebKey
+		for _, k := range set.Keys {
+			if k.Use == "enc" {
+				continue
+			}
+			if !k.IsPublic() {
+				if k = k.Public(); k.Key == nil {
+					continue
+				}
+			}
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+
+	var keys []jose.JSONWebKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jose.JSONWebKey{Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return keys, nil
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const testHMACSecret = "fedcba9876543210fedcba9876543210"
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writePEM writes the public half of key to a PEM file.
+func writePEM(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func signToken(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	opts := &jose.SignerOptions{}
+	if kid != "" {
+		opts = opts.WithHeader("kid", kid)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts.WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func bearer(tok string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+tok)
+	return r
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	key, other := newRSAKey(t), newRSAKey(t)
+	t.Setenv("SSP_TEST_HMAC_SECRET", testHMACSecret)
+	a, err := NewJWT(JWTConfig{
+		Keys:       []string{writePEM(t, key)},
+		HMACSecret: "env:SSP_TEST_HMAC_SECRET",
+		Issuer:     "https://login.example.com",
+		Audience:   "ssp",
+		ClockSkew:  time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claims := func(modify func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://login.example.com",
+			"aud": []string{"api", "ssp"},
+			"sub": "ripta",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"rsa", signToken(t, jose.RS256, key, "", claims(nil)), false},
+		{"hmac", signToken(t, jose.HS256, []byte(testHMACSecret), "", claims(nil)), false},
+		{"other key", signToken(t, jose.RS256, other, "", claims(nil)), true},
+		{"other secret", signToken(t, jose.HS256, []byte("not-the-secret-not-the-secret-!!"), "", claims(nil)), true},
+		{"expired", signToken(t, jose.RS256, key, "", claims(func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() })), true},
+		{"expired within skew", signToken(t, jose.RS256, key, "", claims(func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() })), false},
+		{"not yet valid", signToken(t, jose.RS256, key, "", claims(func(c map[string]interface{}) { c["nbf"] = now.Add(time.Hour).Unix() })), true},
+		{"no expiry", signToken(t, jose.RS256, key, "", claims(func(c map[string]interface{}) { delete(c, "exp") })), true},
+		{"other issuer", signToken(t, jose.RS256, key, "", claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })), true},
+		{"other audience", signToken(t, jose.RS256, key, "", claims(func(c map[string]interface{}) { c["aud"] = "api" })), true},
+		{"no subject", signToken(t, jose.RS256, key, "", claims(func(c map[string]interface{}) { delete(c, "sub") })), true},
+		{"malformed", "not.a.token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(bearer(tt.token))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && id.User != "ripta" {
+				t.Errorf("user = %q, want %q", id.User, "ripta")
+			}
+		})
+	}
+
+	if _, err := a.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); err != ErrNoCredentials {
+		t.Errorf("Authenticate() without a token error = %v, want %v", err, ErrNoCredentials)
+	}
+}
+
+func TestJWTKeySetURL(t *testing.T) {
+	key, other := newRSAKey(t), newRSAKey(t)
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: key, KeyID: "current", Algorithm: "RS256", Use: "sig"},
+		{Key: other, KeyID: "encryption", Use: "enc"},
+	}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer srv.Close()
+
+	a, err := NewJWT(JWTConfig{Keys: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"matching kid", signToken(t, jose.RS256, key, "current", map[string]interface{}{"sub": "ripta", "exp": exp}), false},
+		{"no kid", signToken(t, jose.RS256, key, "", map[string]interface{}{"sub": "ripta", "exp": exp}), false},
+		{"other kid", signToken(t, jose.RS256, key, "previous", map[string]interface{}{"sub": "ripta", "exp": exp}), true},
+		{"encryption key", signToken(t, jose.RS256, other, "encryption", map[string]interface{}{"sub": "ripta", "exp": exp}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.Authenticate(bearer(tt.token)); (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTKeySetUnavailable(t *testing.T) {
+	key := newRSAKey(t)
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key, KeyID: "current", Algorithm: "RS256", Use: "sig"}}}
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer srv.Close()
+
+	a, err := NewJWT(JWTConfig{Keys: []string{srv.URL}, ReloadInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := signToken(t, jose.RS256, key, "current", map[string]interface{}{"sub": "ripta", "exp": time.Now().Add(time.Hour).Unix()})
+
+	if _, err := a.Authenticate(bearer(tok)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Once the keys expire, the previous keys are used while reloading them
+	// fails, and the issuer is not asked again until the backoff is over
+	for i := 0; i < 5; i++ {
+		if _, err := a.Authenticate(bearer(tok)); err != nil {
+			t.Fatalf("Authenticate() with unavailable key set = %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("key set fetched %d times, want 2", n)
+	}
+}
+
+func TestJWTPrefixes(t *testing.T) {
+	t.Setenv("SSP_TEST_HMAC_SECRET", testHMACSecret)
+	a, err := NewJWT(JWTConfig{
+		HMACSecret: "env:SSP_TEST_HMAC_SECRET",
+		Prefixes:   []string{"/users/{sub}/", "/teams/{team}/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Require(a, RequirePrefixes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	token := func(claims map[string]interface{}) string {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		return signToken(t, jose.HS256, []byte(testHMACSecret), "", claims)
+	}
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		wantStatus int
+	}{
+		{"own prefix", token(map[string]interface{}{"sub": "ripta"}), "/users/ripta/a.txt", http.StatusOK},
+		{"own directory", token(map[string]interface{}{"sub": "ripta"}), "/users/ripta", http.StatusOK},
+		{"other user", token(map[string]interface{}{"sub": "ripta"}), "/users/riptide/a.txt", http.StatusForbidden},
+		{"parent", token(map[string]interface{}{"sub": "ripta"}), "/users/", http.StatusForbidden},
+		{"team prefix", token(map[string]interface{}{"sub": "ripta", "team": "sre"}), "/teams/sre/runbook.md", http.StatusOK},
+		{"no team claim", token(map[string]interface{}{"sub": "ripta"}), "/teams/sre/runbook.md", http.StatusForbidden},
+		{"escaping claim", token(map[string]interface{}{"sub": ".."}), "/users/a.txt", http.StatusUnauthorized},
+		{"slash in claim", token(map[string]interface{}{"sub": "ripta/../../"}), "/users/ripta/a.txt", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bearer(tt.token)
+			r.URL.Path = tt.path
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Bearer realm="ssp"` {
+				t.Errorf("WWW-Authenticate = %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestNewJWTErrors(t *testing.T) {
+	t.Setenv("SSP_TEST_SHORT_SECRET", "short")
+	tests := []struct {
+		name string
+		c    JWTConfig
+	}{
+		{"no keys", JWTConfig{}},
+		{"missing key file", JWTConfig{Keys: []string{filepath.Join(t.TempDir(), "missing.pem")}}},
+		{"short secret", JWTConfig{HMACSecret: "env:SSP_TEST_SHORT_SECRET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewJWT(tt.c); err == nil {
+				t.Error("NewJWT() succeeded")
+			}
+		})
+	}
+}
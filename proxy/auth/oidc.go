@@ -0,0 +1,438 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/oauth2"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+const (
+	DefaultCookieName      = "ssp_session"
+	DefaultSessionDuration = 12 * time.Hour
+	DefaultGroupsClaim     = "groups"
+
+	// loginDuration is how long a user has to log in with the issuer.
+	loginDuration = 10 * time.Minute
+	// discoveryTimeout bounds requests to the issuer's discovery and key
+	// endpoints.
+	discoveryTimeout = 10 * time.Second
+
+	purposeSession = "session"
+	purposeLogin   = "login"
+)
+
+// Interceptor is implemented by an Authenticator that serves some requests
+// itself, such as the callback of a login flow.
+type Interceptor interface {
+	// Intercept reports whether it has responded to the request, in which
+	// case the request goes no further.
+	Intercept(w http.ResponseWriter, r *http.Request) bool
+}
+
+// OIDCConfig configures single sign-on with an OpenID Connect issuer.
+type OIDCConfig struct {
+	// Issuer is the URL of the issuer, from which its endpoints are
+	// discovered.
+	Issuer       string
+	ClientID     string
+	ClientSecret string // reference, as in proxy.ResolveReference
+	// RedirectURL is where the issuer sends users back to after logging in.
+	// Its path is handled by the authenticator itself.
+	RedirectURL string
+	// Scopes are requested in addition to "openid", "email" and "profile".
+	Scopes []string
+
+	// CookieName is the name of the session cookie, which is signed with
+	// CookieSecret, a reference that resolves to at least 32 bytes.
+	CookieName      string
+	CookieSecret    string
+	SessionDuration time.Duration
+
+	// Users are allowed when their verified email address is in
+	// AllowedEmails or in one of AllowedDomains, or when they belong to one
+	// of AllowedGroups, as listed in the GroupsClaim of their ID token. When
+	// none of these are set, any user of the issuer is allowed. Email
+	// addresses are only verified when the ID token says so, with an
+	// email_verified claim.
+	AllowedDomains []string
+	AllowedEmails  []string
+	AllowedGroups  []string
+	GroupsClaim    string
+}
+
+type oidcAuth struct {
+	config         OIDCConfig
+	clientSecret   string
+	callbackPath   string
+	secure         bool
+	cookies        cookieSigner
+	sessionPurpose string
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// session is the value of the session cookie.
+type session struct {
+	cookieValue
+	User string `json:"user"`
+}
+
+// login is the value of the cookie that tracks a login in progress.
+type login struct {
+	cookieValue
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Return   string `json:"return"`
+}
+
+// NewOIDC creates an Authenticator that sends users without a session to log
+// in with an OpenID Connect issuer. The issuer is only contacted once the
+// first user logs in.
+func NewOIDC(c OIDCConfig) (Authenticator, error) {
+	if c.Issuer == "" || c.ClientID == "" {
+		return nil, errors.New("oidc requires an issuer and a client ID")
+	}
+	u, err := url.Parse(c.RedirectURL)
+	if err != nil || !u.IsAbs() || u.Path == "" {
+		return nil, fmt.Errorf("oidc redirect URL %q must be an absolute URL with a path", c.RedirectURL)
+	}
+
+	// Public clients, which rely on PKCE alone, have no secret
+	var secret string
+	if c.ClientSecret != "" {
+		if secret, err = proxy.ResolveReference(c.ClientSecret); err != nil {
+			return nil, fmt.Errorf("could not resolve client secret: %w", err)
+		}
+	}
+	key, err := proxy.ResolveReference(c.CookieSecret)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve cookie secret: %w", err)
+	}
+	if len(key) < MinCookieSecretLength {
+		return nil, fmt.Errorf("cookie secret must be at least %d bytes", MinCookieSecretLength)
+	}
+
+	if c.CookieName == "" {
+		c.CookieName = DefaultCookieName
+	}
+	if c.SessionDuration <= 0 {
+		c.SessionDuration = DefaultSessionDuration
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = DefaultGroupsClaim
+	}
+
+	return &oidcAuth{
+		config:         c,
+		clientSecret:   secret,
+		callbackPath:   u.Path,
+		secure:         u.Scheme == "https",
+		cookies:        cookieSigner{key: []byte(key)},
+		sessionPurpose: sessionPurpose(c),
+	}, nil
+}
+
+// sessionPurpose returns the purpose with which the sessions of c are signed,
+// which covers everything that decides who is allowed in. Users are only
+// authorized when they log in, so that a session of one handler is not
+// accepted by another that shares its secret and cookie name, but allows
+// other users.
+func sessionPurpose(c OIDCConfig) string {
+	h := sha256.New()
+	for _, vs := range [][]string{{c.Issuer, c.ClientID, c.GroupsClaim}, c.AllowedDomains, c.AllowedEmails, c.AllowedGroups} {
+		for _, v := range vs {
+			h.Write([]byte(v))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{1})
+	}
+	return purposeSession + ":" + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// provider discovers the issuer's endpoints, the first time it is called.
+func (o *oidcAuth) provider() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.oauth2 != nil {
+		return o.oauth2, o.verifier, nil
+	}
+
+	// The context outlives the request, since it is also used to fetch the
+	// issuer's signing keys as they rotate.
+	ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: discoveryTimeout})
+	p, err := oidc.NewProvider(ctx, o.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not discover oidc issuer: %w", err)
+	}
+
+	o.oauth2 = &oauth2.Config{
+		ClientID:     o.config.ClientID,
+		ClientSecret: o.clientSecret,
+		Endpoint:     p.Endpoint(),
+		RedirectURL:  o.config.RedirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID, "email", "profile"}, o.config.Scopes...),
+	}
+	o.verifier = p.Verifier(&oidc.Config{ClientID: o.config.ClientID})
+	return o.oauth2, o.verifier, nil
+}
+
+func (o *oidcAuth) Authenticate(r *http.Request) (Identity, error) {
+	c, err := r.Cookie(o.config.CookieName)
+	if err != nil {
+		return Identity{}, ErrNoCredentials
+	}
+
+	var s session
+	if err := o.cookies.Decode(o.sessionPurpose, c.Value, &s); err != nil {
+		return Identity{}, fmt.Errorf("session cookie: %w", ErrInvalidCredentials)
+	}
+	return Identity{User: s.User}, nil
+}
+
+// Challenge sends browsers to log in with the issuer. Other requests, which
+// could not follow the redirect back, are refused.
+func (o *oidcAuth) Challenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cfg, _, err := o.provider()
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("could not start login")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	l := login{
+		cookieValue: cookieValue{Expires: time.Now().Add(loginDuration).Unix()},
+		State:       randomString(),
+		Nonce:       randomString(),
+		Verifier:    oauth2.GenerateVerifier(),
+		Return:      returnURL(r),
+	}
+	value, err := o.cookies.Encode(purposeLogin, l)
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("could not start login")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	o.setCookie(w, o.loginCookieName(), value, loginDuration)
+
+	u := cfg.AuthCodeURL(l.State, oidc.Nonce(l.Nonce), oauth2.S256ChallengeOption(l.Verifier))
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// Intercept handles the issuer's redirect back to the callback path.
+func (o *oidcAuth) Intercept(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != o.callbackPath {
+		return false
+	}
+	o.callback(w, r)
+	return true
+}
+
+func (o *oidcAuth) callback(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	c, err := r.Cookie(o.loginCookieName())
+	if err != nil {
+		http.Error(w, "Login expired; please try again.", http.StatusBadRequest)
+		return
+	}
+	var l login
+	if err := o.cookies.Decode(purposeLogin, c.Value, &l); err != nil {
+		http.Error(w, "Login expired; please try again.", http.StatusBadRequest)
+		return
+	}
+	o.setCookie(w, o.loginCookieName(), "", -1)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Warn().Str("error", e).Str("error_description", q.Get("error_description")).Msg("login failed at issuer")
+		http.Error(w, "Login failed.", http.StatusUnauthorized)
+		return
+	}
+	if q.Get("state") != l.State {
+		http.Error(w, "Login state does not match; please try again.", http.StatusBadRequest)
+		return
+	}
+
+	cfg, verifier, err := o.provider()
+	if err != nil {
+		log.Error().Err(err).Msg("could not complete login")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := oidc.ClientContext(r.Context(), &http.Client{Timeout: discoveryTimeout})
+	token, err := cfg.Exchange(ctx, q.Get("code"), oauth2.VerifierOption(l.Verifier))
+	if err != nil {
+		log.Error().Err(err).Msg("could not exchange authorization code")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Error().Msg("issuer did not return an id token")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	idToken, err := verifier.Verify(ctx, raw)
+	if err != nil || idToken.Nonce != l.Nonce {
+		log.Warn().Err(err).Msg("invalid id token")
+		http.Error(w, "Login failed.", http.StatusUnauthorized)
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		log.Error().Err(err).Msg("could not decode id token claims")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	user, ok := o.authorize(idToken.Subject, claims)
+	if !ok {
+		log.Warn().Str("user", user).Msg("user is not allowed")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	s := session{
+		cookieValue: cookieValue{Expires: time.Now().Add(o.config.SessionDuration).Unix()},
+		User:        user,
+	}
+	value, err := o.cookies.Encode(o.sessionPurpose, s)
+	if err != nil {
+		log.Error().Err(err).Msg("could not create session")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	o.setCookie(w, o.config.CookieName, value, o.config.SessionDuration)
+
+	log.Info().Str("user", user).Msg("logged in")
+	http.Redirect(w, r, l.Return, http.StatusFound)
+}
+
+// authorize returns the name of the user identified by claims, and whether
+// they are allowed in. Users are named by their email address only when it is
+// verified, and by their subject otherwise.
+func (o *oidcAuth) authorize(subject string, claims map[string]interface{}) (string, bool) {
+	email, _ := claims["email"].(string)
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		email = ""
+	}
+	user := email
+	if user == "" {
+		user = subject
+	}
+
+	c := o.config
+	if len(c.AllowedDomains) == 0 && len(c.AllowedEmails) == 0 && len(c.AllowedGroups) == 0 {
+		return user, true
+	}
+
+	if email != "" {
+		for _, e := range c.AllowedEmails {
+			if strings.EqualFold(e, email) {
+				return user, true
+			}
+		}
+		if _, domain, ok := strings.Cut(email, "@"); ok {
+			for _, d := range c.AllowedDomains {
+				if strings.EqualFold(d, domain) {
+					return user, true
+				}
+			}
+		}
+	}
+
+	for _, g := range claimStrings(claims[c.GroupsClaim]) {
+		for _, allowed := range c.AllowedGroups {
+			if g == allowed {
+				return user, true
+			}
+		}
+	}
+	return user, false
+}
+
+// claimStrings returns a claim that is either a string or a list of strings.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var ss []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+func (o *oidcAuth) loginCookieName() string {
+	return loginCookieName(o.config.CookieName)
+}
+
+func loginCookieName(name string) string {
+	return name + "_login"
+}
+
+// CookieNames returns the names of the cookies that users are given while
+// they log in and once they have, which are of no use to anything but ssp.
+func (c OIDCConfig) CookieNames() []string {
+	name := c.CookieName
+	if name == "" {
+		name = DefaultCookieName
+	}
+	return []string{name, loginCookieName(name)}
+}
+
+func (o *oidcAuth) setCookie(w http.ResponseWriter, name, value string, d time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Secure:   o.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if d < 0 {
+		c.MaxAge = -1
+	} else {
+		c.MaxAge = int(d / time.Second)
+	}
+	http.SetCookie(w, c)
+}
+
+// returnURL returns where to send the user after logging in. Only paths on
+// this host are allowed, so that the login flow cannot be used to redirect
+// users elsewhere.
+func returnURL(r *http.Request) string {
+	u := r.URL.RequestURI()
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") {
+		return "/"
+	}
+	return u
+}
@@ -0,0 +1,431 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+const testCookieSecret = "0123456789abcdef0123456789abcdef"
+
+// mockProvider is a minimal OpenID Connect issuer, which logs in whoever is
+// in its claims without asking.
+type mockProvider struct {
+	*httptest.Server
+	t      *testing.T
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockProvider(t *testing.T, claims map[string]interface{}) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{t: t, key: key, claims: claims, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &p.key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := randomString()
+		p.mu.Lock()
+		p.codes[code] = q
+		p.mu.Unlock()
+
+		u, _ := url.Parse(q.Get("redirect_uri"))
+		u.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	q, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   p.URL,
+		"aud":   q.Get("client_id"),
+		"sub":   "1234",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	payload, _ := json.Marshal(claims)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	idToken, _ := jws.CompactSerialize()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func newTestOIDC(t *testing.T, p *mockProvider, c OIDCConfig) Authenticator {
+	t.Helper()
+
+	t.Setenv("SSP_TEST_COOKIE_SECRET", testCookieSecret)
+	t.Setenv("SSP_TEST_CLIENT_SECRET", "shh")
+	c.Issuer = p.URL
+	c.ClientID = "ssp"
+	c.ClientSecret = "env:SSP_TEST_CLIENT_SECRET"
+	c.RedirectURL = "https://docs.example.com/_auth/callback"
+	c.CookieSecret = "env:SSP_TEST_COOKIE_SECRET"
+	a, err := NewOIDC(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// followLogin follows the login flow for a request to target, and returns the
+// response to the callback.
+func followLogin(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	cookies := w.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback := resp.Header.Get("Location")
+	if !strings.HasPrefix(callback, "https://docs.example.com/_auth/callback?") {
+		t.Fatalf("issuer redirected to %q", callback)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, callback, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == DefaultCookieName && c.MaxAge > 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newMockProvider(t, map[string]interface{}{
+		"email":          "alice@example.com",
+		"email_verified": true,
+	})
+	a := newTestOIDC(t, p, OIDCConfig{})
+
+	var gotUser string
+	h := Require(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _ = User(r.Context())
+	}))
+
+	w := followLogin(t, h, "https://docs.example.com/guide/?page=2")
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback status = %d, want %d: %s", w.Code, http.StatusFound, w.Body.String())
+	}
+	if got, want := w.Header().Get("Location"), "/guide/?page=2"; got != want {
+		t.Errorf("callback redirected to %q, want %q", got, want)
+	}
+	c := sessionCookie(w)
+	if c == nil {
+		t.Fatal("callback did not set a session cookie")
+	}
+	if !c.Secure || !c.HttpOnly {
+		t.Errorf("session cookie is not secure: %+v", c)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://docs.example.com/guide/", nil)
+	r.AddCookie(c)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || gotUser != "alice@example.com" {
+		t.Errorf("status = %d, user = %q; want %d, %q", w.Code, gotUser, http.StatusOK, "alice@example.com")
+	}
+
+	// A tampered session sends the user to log in again
+	r = httptest.NewRequest(http.MethodGet, "https://docs.example.com/guide/", nil)
+	r.AddCookie(&http.Cookie{Name: c.Name, Value: strings.Replace(c.Value, ".", "x.", 1)})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Errorf("status with tampered session = %d, want %d", w.Code, http.StatusFound)
+	}
+}
+
+func TestOIDCAuthorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		config OIDCConfig
+		want   bool
+	}{
+		{
+			name:   "domain",
+			claims: map[string]interface{}{"email": "alice@example.com", "email_verified": true},
+			config: OIDCConfig{AllowedDomains: []string{"EXAMPLE.com"}},
+			want:   true,
+		},
+		{
+			name:   "other domain",
+			claims: map[string]interface{}{"email": "alice@example.org", "email_verified": true},
+			config: OIDCConfig{AllowedDomains: []string{"example.com"}},
+		},
+		{
+			name:   "unverified email",
+			claims: map[string]interface{}{"email": "alice@example.com", "email_verified": false},
+			config: OIDCConfig{AllowedDomains: []string{"example.com"}},
+		},
+		{
+			name:   "email without email_verified",
+			claims: map[string]interface{}{"email": "alice@example.com"},
+			config: OIDCConfig{AllowedDomains: []string{"example.com"}, AllowedEmails: []string{"alice@example.com"}},
+		},
+		{
+			name:   "email",
+			claims: map[string]interface{}{"email": "bob@example.org", "email_verified": true},
+			config: OIDCConfig{AllowedDomains: []string{"example.com"}, AllowedEmails: []string{"bob@example.org"}},
+			want:   true,
+		},
+		{
+			name:   "group",
+			claims: map[string]interface{}{"email": "carol@example.org", "groups": []string{"staff", "docs"}},
+			config: OIDCConfig{AllowedGroups: []string{"docs"}},
+			want:   true,
+		},
+		{
+			name:   "custom groups claim",
+			claims: map[string]interface{}{"roles": "docs"},
+			config: OIDCConfig{AllowedGroups: []string{"docs"}, GroupsClaim: "roles"},
+			want:   true,
+		},
+		{
+			name:   "other group",
+			claims: map[string]interface{}{"groups": []string{"staff"}},
+			config: OIDCConfig{AllowedGroups: []string{"docs"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProvider(t, tt.claims)
+			h := Require(newTestOIDC(t, p, tt.config), http.NotFoundHandler())
+
+			w := followLogin(t, h, "https://docs.example.com/")
+			if got := w.Code == http.StatusFound; got != tt.want {
+				t.Errorf("callback status = %d, allowed = %v, want %v", w.Code, got, tt.want)
+			}
+			if got := sessionCookie(w) != nil; got != tt.want {
+				t.Errorf("session cookie set = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCSessionBoundToPolicy(t *testing.T) {
+	p := newMockProvider(t, map[string]interface{}{
+		"email":          "alice@example.com",
+		"email_verified": true,
+	})
+	open := Require(newTestOIDC(t, p, OIDCConfig{AllowedDomains: []string{"example.com"}}), http.NotFoundHandler())
+	c := sessionCookie(followLogin(t, open, "https://docs.example.com/"))
+	if c == nil {
+		t.Fatal("callback did not set a session cookie")
+	}
+
+	// Another handler with the same secret and cookie name, but which only
+	// allows bob, sends alice to log in again
+	closed := Require(newTestOIDC(t, p, OIDCConfig{AllowedEmails: []string{"bob@example.com"}}), http.NotFoundHandler())
+	r := httptest.NewRequest(http.MethodGet, "https://docs.example.com/", nil)
+	r.AddCookie(c)
+	w := httptest.NewRecorder()
+	closed.ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Errorf("status with session of another handler = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "https://docs.example.com/", nil)
+	r.AddCookie(c)
+	w = httptest.NewRecorder()
+	open.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status with own session = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestOIDCCallbackErrors(t *testing.T) {
+	p := newMockProvider(t, nil)
+	h := Require(newTestOIDC(t, p, OIDCConfig{}), http.NotFoundHandler())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://docs.example.com/", nil))
+	loginCookies := w.Result().Cookies()
+
+	tests := []struct {
+		name       string
+		query      string
+		cookies    []*http.Cookie
+		wantStatus int
+	}{
+		{"no login in progress", "code=x&state=y", nil, http.StatusBadRequest},
+		{"state mismatch", "code=x&state=y", loginCookies, http.StatusBadRequest},
+		{"error from issuer", "error=access_denied", loginCookies, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://docs.example.com/_auth/callback?"+tt.query, nil)
+			for _, c := range tt.cookies {
+				r.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOIDCChallenge(t *testing.T) {
+	p := newMockProvider(t, nil)
+	h := Require(newTestOIDC(t, p, OIDCConfig{}), http.NotFoundHandler())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://docs.example.com/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://docs.example.com/a", nil))
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != "ssp" || q.Get("code_challenge_method") != "S256" || q.Get("nonce") == "" {
+		t.Errorf("authorization request = %v", q)
+	}
+}
+
+func TestNewOIDCErrors(t *testing.T) {
+	t.Setenv("SSP_TEST_COOKIE_SECRET", testCookieSecret)
+	valid := OIDCConfig{
+		Issuer:       "https://issuer.example.com",
+		ClientID:     "ssp",
+		RedirectURL:  "https://docs.example.com/_auth/callback",
+		CookieSecret: "env:SSP_TEST_COOKIE_SECRET",
+	}
+	if _, err := NewOIDC(valid); err != nil {
+		t.Fatalf("NewOIDC() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *OIDCConfig)
+	}{
+		{"no issuer", func(c *OIDCConfig) { c.Issuer = "" }},
+		{"relative redirect", func(c *OIDCConfig) { c.RedirectURL = "/_auth/callback" }},
+		{"short secret", func(c *OIDCConfig) { c.CookieSecret = "short" }},
+		{"missing secret", func(c *OIDCConfig) { c.CookieSecret = "env:SSP_TEST_MISSING" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+			if _, err := NewOIDC(c); err == nil {
+				t.Error("NewOIDC() succeeded")
+			}
+		})
+	}
+}
+
+func TestReturnURL(t *testing.T) {
+	tests := []struct{ target, want string }{
+		{"/a/b?c=d", "/a/b?c=d"},
+		{"//evil.example.com/", "/"},
+		{"/\\evil.example.com/", "/%5Cevil.example.com/"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "https://docs.example.com/", nil)
+		r.URL.Path = tt.target
+		r.URL.RawQuery = ""
+		if p, q, ok := strings.Cut(tt.target, "?"); ok {
+			r.URL.Path, r.URL.RawQuery = p, q
+		}
+		if got := returnURL(r); got != tt.want {
+			t.Errorf("returnURL(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
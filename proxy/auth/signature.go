@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/clientip"
+)
+
+// Query parameters of signed URLs. They are prefixed so as not to collide
+// with those that handlers understand, like "archive" or "format".
+const (
+	ExpiresParam   = "ssp_expires"
+	KeyIDParam     = "ssp_key"
+	IPParam        = "ssp_ip"
+	SignatureParam = "ssp_signature"
+)
+
+// SignedURLParams are all of the query parameters of signed URLs.
+var SignedURLParams = []string{ExpiresParam, KeyIDParam, IPParam, SignatureParam}
+
+// MinSigningKeyLength is the minimum length of a key that signs URLs.
+const MinSigningKeyLength = 32
+
+// SigningKeys are the keys that sign URLs, by key ID. Keeping more than one
+// lets keys be rotated: links are signed with the newest key, while those
+// signed with older keys remain valid until their keys are removed.
+type SigningKeys map[string][]byte
+
+// ResolveSigningKeys resolves references to signing keys, as in
+// proxy.ResolveReference.
+func ResolveSigningKeys(refs map[string]string) (SigningKeys, error) {
+	keys := SigningKeys{}
+	for id, ref := range refs {
+		key, err := proxy.ResolveReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve signing key %q: %w", id, err)
+		}
+		if len(key) < MinSigningKeyLength {
+			return nil, fmt.Errorf("signing key %q must be at least %d bytes", id, MinSigningKeyLength)
+		}
+		keys[id] = []byte(key)
+	}
+	return keys, nil
+}
+
+// SignURL returns a copy of u that is valid until expires, signed with key,
+// whose ID is keyID. When ip is valid, the URL may only be used by clients
+// with that address. Only the path of the URL is signed, so it is valid on
+// any handler that has the key.
+func SignURL(u *url.URL, keyID string, key []byte, expires time.Time, ip netip.Addr) *url.URL {
+	q := u.Query()
+	q.Del(IPParam)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q.Set(ExpiresParam, exp)
+	q.Set(KeyIDParam, keyID)
+	var ipStr string
+	if ip.IsValid() {
+		ipStr = ip.String()
+		q.Set(IPParam, ipStr)
+	}
+	q.Set(SignatureParam, base64.RawURLEncoding.EncodeToString(signature(key, keyID, u.Path, exp, ipStr)))
+
+	signed := *u
+	signed.RawQuery = q.Encode()
+	return &signed
+}
+
+func signature(key []byte, keyID, path, expires, ip string) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, s := range []string{"ssp-url-v1", keyID, path, expires, ip} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+type signedURL struct {
+	keys SigningKeys
+}
+
+// NewSignedURL creates an Authenticator for URLs signed by SignURL with any
+// of keys.
+func NewSignedURL(keys SigningKeys) (Authenticator, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("signed URLs require at least one signing key")
+	}
+	return &signedURL{keys: keys}, nil
+}
+
+func (s *signedURL) Authenticate(r *http.Request) (Identity, error) {
+	q := r.URL.Query()
+	sig := q.Get(SignatureParam)
+	if sig == "" {
+		return Identity{}, ErrNoCredentials
+	}
+	// The method is not signed, so signed URLs only ever grant downloads
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return Identity{}, fmt.Errorf("signed URL used to %s: %w", r.Method, ErrInvalidCredentials)
+	}
+
+	id := q.Get(KeyIDParam)
+	key, ok := s.keys[id]
+	if !ok {
+		return Identity{}, fmt.Errorf("signed URL with unknown key %q: %w", id, ErrInvalidCredentials)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, signature(key, id, r.URL.Path, q.Get(ExpiresParam), q.Get(IPParam))) {
+		return Identity{}, fmt.Errorf("signed URL with invalid signature: %w", ErrInvalidCredentials)
+	}
+
+	exp, err := strconv.ParseInt(q.Get(ExpiresParam), 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return Identity{}, fmt.Errorf("signed URL expired: %w", ErrInvalidCredentials)
+	}
+	if ip := q.Get(IPParam); ip != "" {
+		want, err := netip.ParseAddr(ip)
+		if err != nil || clientip.Addr(r) != want.Unmap() {
+			return Identity{}, fmt.Errorf("signed URL used from another address: %w", ErrInvalidCredentials)
+		}
+	}
+	return Identity{User: "signed-url:" + id}, nil
+}
+
+// Challenge refuses the request, since there is nothing that a client could
+// do to sign it.
+func (s *signedURL) Challenge(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
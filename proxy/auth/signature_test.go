@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	keys := SigningKeys{
+		"2024": []byte("0123456789abcdef0123456789abcdef"),
+		"2025": []byte("fedcba9876543210fedcba9876543210"),
+	}
+	a, err := NewSignedURL(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://files.example.com/reports/q1 2025.pdf?download=1")
+	hour := time.Now().Add(time.Hour)
+	ip := netip.MustParseAddr("192.0.2.10")
+
+	tests := []struct {
+		name       string
+		url        string
+		remoteAddr string
+		wantUser   string
+		wantErr    error
+	}{
+		{"unsigned", u.String(), "", "", ErrNoCredentials},
+		{"signed", SignURL(u, "2025", keys["2025"], hour, netip.Addr{}).String(), "", "signed-url:2025", nil},
+		{"older key", SignURL(u, "2024", keys["2024"], hour, netip.Addr{}).String(), "", "signed-url:2024", nil},
+		{"removed key", SignURL(u, "2023", keys["2024"], hour, netip.Addr{}).String(), "", "", ErrInvalidCredentials},
+		{"wrong key", SignURL(u, "2025", keys["2024"], hour, netip.Addr{}).String(), "", "", ErrInvalidCredentials},
+		{"expired", SignURL(u, "2025", keys["2025"], time.Now().Add(-time.Second), netip.Addr{}).String(), "", "", ErrInvalidCredentials},
+		{"client address", SignURL(u, "2025", keys["2025"], hour, ip).String(), "192.0.2.10:4321", "signed-url:2025", nil},
+		{"other client address", SignURL(u, "2025", keys["2025"], hour, ip).String(), "192.0.2.11:4321", "", ErrInvalidCredentials},
+		{"other path", strings.Replace(SignURL(u, "2025", keys["2025"], hour, netip.Addr{}).String(), "/reports/", "/secrets/", 1), "", "", ErrInvalidCredentials},
+		{"extended expiry", strings.Replace(SignURL(u, "2025", keys["2025"], hour, netip.Addr{}).String(), "ssp_expires=", "ssp_expires=9", 1), "", "", ErrInvalidCredentials},
+		{"address removed", strings.Replace(SignURL(u, "2025", keys["2025"], hour, ip).String(), "ssp_ip=", "x=", 1), "", "", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			id, err := a.Authenticate(r)
+			if tt.wantErr != nil {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr.Error()) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if id.User != tt.wantUser {
+				t.Errorf("user = %q, want %q", id.User, tt.wantUser)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodPut, SignURL(u, "2025", keys["2025"], hour, netip.Addr{}).String(), nil)
+	if _, err := a.Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate(PUT) error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestSignedURLChallenge(t *testing.T) {
+	a, err := NewSignedURL(SigningKeys{"k": []byte("0123456789abcdef0123456789abcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Require(a, http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/private.txt", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestResolveSigningKeys(t *testing.T) {
+	t.Setenv("SSP_TEST_SIGNING_KEY", "0123456789abcdef0123456789abcdef")
+	t.Setenv("SSP_TEST_SHORT_KEY", "short")
+
+	if _, err := ResolveSigningKeys(map[string]string{"k": "env:SSP_TEST_SIGNING_KEY"}); err != nil {
+		t.Errorf("ResolveSigningKeys() error = %v", err)
+	}
+	for _, ref := range []string{"env:SSP_TEST_SHORT_KEY", "env:SSP_TEST_MISSING_KEY", "plaintext"} {
+		if _, err := ResolveSigningKeys(map[string]string{"k": ref}); err == nil {
+			t.Errorf("ResolveSigningKeys(%q) succeeded", ref)
+		}
+	}
+}
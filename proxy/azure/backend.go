@@ -0,0 +1,337 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/rs/zerolog"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// directoryMetadataKey marks placeholder blobs that represent directories, as
+// created by hierarchical namespace accounts and tools such as Storage Explorer.
+const directoryMetadataKey = "hdi_isfolder"
+
+type backend struct {
+	Client    *container.Client
+	Account   string
+	Container string
+}
+
+// NewBackend creates a new Azure Blob Storage backend for the container
+// described by c.
+func NewBackend(c Config) (proxy.Backend, error) {
+	if c.Container == "" {
+		return nil, errors.New("Container name is required")
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend{
+		Client:    client,
+		Account:   c.Account,
+		Container: c.Container,
+	}, nil
+}
+
+func (b *backend) UpdateLogContext(c zerolog.Context, key string) zerolog.Context {
+	return c.
+		Str("azure_account", b.Account).
+		Str("azure_container", b.Container).
+		Str("azure_key", key)
+}
+
+func (b *backend) Stat(ctx context.Context, key string) (*proxy.ObjectInfo, error) {
+	props, err := b.Client.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &proxy.ObjectInfo{
+		Key:                key,
+		Size:               deref(props.ContentLength),
+		ModTime:            deref(props.LastModified),
+		CacheControl:       deref(props.CacheControl),
+		ContentDisposition: deref(props.ContentDisposition),
+		ContentEncoding:    deref(props.ContentEncoding),
+		ContentLanguage:    deref(props.ContentLanguage),
+		ContentType:        deref(props.ContentType),
+		ETag:               string(deref(props.ETag)),
+		IsDirectory:        isDirectory(props.Metadata),
+		Header:             metaHeaders(props.VersionID, props.Metadata),
+	}, nil
+}
+
+func (b *backend) Get(ctx context.Context, key string, rng *proxy.ByteRange) (*proxy.Object, error) {
+	opts := &blob.DownloadStreamOptions{}
+	if rng != nil {
+		hr, err := b.httpRange(ctx, key, rng)
+		if err != nil {
+			return nil, err
+		}
+		opts.Range = hr
+	}
+
+	out, err := b.Client.NewBlobClient(key).DownloadStream(ctx, opts)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	size := deref(out.ContentLength)
+	if cr := deref(out.ContentRange); cr != "" {
+		if _, total, ok := strings.Cut(cr, "/"); ok {
+			fmt.Sscan(total, &size)
+		}
+	}
+
+	return &proxy.Object{
+		ObjectInfo: proxy.ObjectInfo{
+			Key:                key,
+			Size:               size,
+			ModTime:            deref(out.LastModified),
+			CacheControl:       deref(out.CacheControl),
+			ContentDisposition: deref(out.ContentDisposition),
+			ContentEncoding:    deref(out.ContentEncoding),
+			ContentLanguage:    deref(out.ContentLanguage),
+			ContentType:        deref(out.ContentType),
+			ETag:               string(deref(out.ETag)),
+			IsDirectory:        isDirectory(out.Metadata),
+			Header:             metaHeaders(out.VersionID, out.Metadata),
+		},
+		Body:         out.Body,
+		Length:       deref(out.ContentLength),
+		ContentRange: deref(out.ContentRange),
+	}, nil
+}
+
+func (b *backend) List(ctx context.Context, prefix string, opts proxy.ListOptions) (*proxy.ListResult, error) {
+	lo := &container.ListBlobsHierarchyOptions{
+		Prefix: &prefix,
+	}
+	if opts.Token != "" {
+		lo.Marker = &opts.Token
+	}
+	if opts.Limit > 0 {
+		n := int32(opts.Limit)
+		lo.MaxResults = &n
+	}
+	pager := b.Client.NewListBlobsHierarchyPager("/", lo)
+
+	out, err := pager.NextPage(ctx)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	res := &proxy.ListResult{
+		IsTruncated: deref(out.NextMarker) != "",
+		NextToken:   deref(out.NextMarker),
+	}
+	for _, item := range out.Segment.BlobItems {
+		info := proxy.ObjectInfo{
+			Key: deref(item.Name),
+		}
+		if p := item.Properties; p != nil {
+			info.Size = deref(p.ContentLength)
+			info.ModTime = deref(p.LastModified)
+			info.ETag = string(deref(p.ETag))
+			info.ContentType = deref(p.ContentType)
+		}
+		res.Objects = append(res.Objects, info)
+	}
+	for _, bp := range out.Segment.BlobPrefixes {
+		res.Prefixes = append(res.Prefixes, deref(bp.Name))
+	}
+	return res, nil
+}
+
+// httpRange converts rng into an Azure range. Azure does not support suffix
+// ranges, so the size of the blob is looked up to resolve them.
+func (b *backend) httpRange(ctx context.Context, key string, rng *proxy.ByteRange) (blob.HTTPRange, error) {
+	if rng.Offset >= 0 {
+		hr := blob.HTTPRange{Offset: rng.Offset}
+		if rng.Length > 0 {
+			hr.Count = rng.Length
+		}
+		return hr, nil
+	}
+
+	info, err := b.Stat(ctx, key)
+	if err != nil {
+		return blob.HTTPRange{}, err
+	}
+	offset := info.Size + rng.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	return blob.HTTPRange{Offset: offset, Count: info.Size - offset}, nil
+}
+
+func isDirectory(meta map[string]*string) bool {
+	for k, v := range meta {
+		if strings.EqualFold(k, directoryMetadataKey) && strings.EqualFold(deref(v), "true") {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *backend) Put(ctx context.Context, key string, body io.Reader, opts proxy.PutOptions) (*proxy.ObjectInfo, error) {
+	meta := make(map[string]*string, len(opts.Metadata))
+	for k, v := range opts.Metadata {
+		meta[k] = to.Ptr(v)
+	}
+
+	var ac *blob.AccessConditions
+	if opts.IfNoneMatch {
+		ac = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)}}
+	}
+
+	// Blocks are only committed once the whole body has been read
+	out, err := b.Client.NewBlockBlobClient(key).UploadStream(ctx, body, &blockblob.UploadStreamOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobCacheControl:       nonEmpty(opts.CacheControl),
+			BlobContentDisposition: nonEmpty(opts.ContentDisposition),
+			BlobContentEncoding:    nonEmpty(opts.ContentEncoding),
+			BlobContentLanguage:    nonEmpty(opts.ContentLanguage),
+			BlobContentType:        nonEmpty(opts.ContentType),
+		},
+		Metadata:         meta,
+		AccessConditions: ac,
+	})
+	if bloberror.HasCode(err, bloberror.BlobAlreadyExists) {
+		return nil, proxy.ErrExist
+	}
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &proxy.ObjectInfo{
+		Key:     key,
+		ModTime: deref(out.LastModified),
+		ETag:    string(deref(out.ETag)),
+	}, nil
+}
+
+func (b *backend) Delete(ctx context.Context, key string) error {
+	if _, err := b.Client.NewBlobClient(key).Delete(ctx, nil); err != nil {
+		return wrapError(err)
+	}
+	return nil
+}
+
+// copyPollInterval is how often the status of a copy that has not finished
+// yet is checked.
+const copyPollInterval = time.Second
+
+// Copy copies blobs within the account, which Azure does asynchronously,
+// though usually by the time it has responded. Otherwise, it waits for the
+// copy to finish.
+func (b *backend) Copy(ctx context.Context, src, dst string, opts proxy.CopyOptions) (*proxy.ObjectInfo, error) {
+	var ac *blob.AccessConditions
+	if opts.IfNoneMatch {
+		ac = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)}}
+	}
+
+	bc := b.Client.NewBlobClient(dst)
+	out, err := bc.StartCopyFromURL(ctx, b.Client.NewBlobClient(src).URL(), &blob.StartCopyFromURLOptions{
+		AccessConditions: ac,
+	})
+	if bloberror.HasCode(err, bloberror.BlobAlreadyExists) {
+		return nil, proxy.ErrExist
+	}
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	status, etag, modTime := deref(out.CopyStatus), deref(out.ETag), deref(out.LastModified)
+	for status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			bc.AbortCopyFromURL(context.WithoutCancel(ctx), deref(out.CopyID), nil)
+			return nil, ctx.Err()
+		case <-time.After(copyPollInterval):
+		}
+
+		props, err := bc.GetProperties(ctx, nil)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		if deref(props.CopyID) != deref(out.CopyID) {
+			return nil, fmt.Errorf("copy of %q to %q was superseded by another", src, dst)
+		}
+		status, etag, modTime = deref(props.CopyStatus), deref(props.ETag), deref(props.LastModified)
+		if status != blob.CopyStatusTypePending && status != blob.CopyStatusTypeSuccess {
+			return nil, fmt.Errorf("copy of %q to %q %s: %s", src, dst, status, deref(props.CopyStatusDescription))
+		}
+	}
+	return &proxy.ObjectInfo{
+		Key:     dst,
+		ModTime: modTime,
+		ETag:    string(etag),
+	}, nil
+}
+
+// metaHeaders returns the Azure-specific headers of a blob.
+func metaHeaders(versionID *string, meta map[string]*string) http.Header {
+	hdr := http.Header{}
+	if v := deref(versionID); v != "" {
+		hdr.Add("X-Ms-Version-Id", v)
+	}
+	for k, v := range meta {
+		hdr.Add("X-Ms-Meta-"+k, deref(v))
+	}
+	return hdr
+}
+
+// wrapError converts errors returned by Azure into errors understood by the proxy.
+func wrapError(err error) error {
+	var resperr *azcore.ResponseError
+	if !errors.As(err, &resperr) {
+		return err
+	}
+
+	var reqID string
+	if resperr.RawResponse != nil {
+		reqID = resperr.RawResponse.Header.Get("X-Ms-Request-Id")
+	}
+	return &proxy.BackendError{
+		StatusCode: resperr.StatusCode,
+		Message:    resperr.ErrorCode + " Request ID: " + reqID,
+		Err:        err,
+		Fields: map[string]interface{}{
+			"azure_status_code": resperr.StatusCode,
+			"azure_code":        resperr.ErrorCode,
+			"azure_request_id":  reqID,
+		},
+	}
+}
+
+// nonEmpty returns a pointer to s, or nil when it is empty.
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
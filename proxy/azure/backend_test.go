@@ -0,0 +1,346 @@
+package azure
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+const testContainer = "ssp-test"
+
+// fakeAzure is a minimal stand-in for the parts of the Blob service REST API
+// that the backend uses: blob properties, listings, downloads, block uploads,
+// deletes and copies.
+type fakeAzure struct {
+	mu      sync.Mutex
+	objects map[string]proxytest.Object
+	blocks  map[string]map[string][]byte
+}
+
+func newFakeAzure(objects []proxytest.Object) *fakeAzure {
+	f := &fakeAzure{objects: map[string]proxytest.Object{}, blocks: map[string]map[string][]byte{}}
+	for _, o := range objects {
+		f.objects[o.Key] = o
+	}
+	return f
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	if r.URL.Path == "/"+testContainer && q.Get("restype") == "container" && q.Get("comp") == "list" {
+		f.list(w, r)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testContainer+"/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		f.stageBlock(w, r, key)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		f.commitBlocks(w, r, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Ms-Copy-Source") != "":
+		f.copy(w, r, key)
+	case r.Method == http.MethodPut:
+		f.upload(w, r, key)
+	case r.Method == http.MethodDelete:
+		f.delete(w, key)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		f.download(w, r, key)
+	default:
+		writeError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+func etag(o proxytest.Object) string {
+	return fmt.Sprintf(`"0x%X"`, o.ModTime.UnixNano())
+}
+
+// download serves the properties of a blob and, unless only they were
+// requested, its contents.
+func (f *fakeAzure) download(w http.ResponseWriter, r *http.Request, key string) {
+	o, ok := f.objects[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+
+	for k, v := range o.Metadata {
+		w.Header().Set("X-Ms-Meta-"+k, v)
+	}
+	w.Header().Set("X-Ms-Blob-Type", "BlockBlob")
+	w.Header().Set("Content-Type", o.ContentType)
+	if o.CacheControl != "" {
+		w.Header().Set("Cache-Control", o.CacheControl)
+	}
+	w.Header().Set("ETag", etag(o))
+	if rng := r.Header.Get("X-Ms-Range"); rng != "" {
+		r.Header.Set("Range", rng)
+	}
+	http.ServeContent(w, r, "", o.ModTime, strings.NewReader(o.Body))
+}
+
+func (f *fakeAzure) delete(w http.ResponseWriter, key string) {
+	if _, ok := f.objects[key]; !ok {
+		writeError(w, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	delete(f.objects, key)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// copy copies a blob within the container, which always finishes before it
+// responds.
+func (f *fakeAzure) copy(w http.ResponseWriter, r *http.Request, key string) {
+	src, err := url.Parse(r.Header.Get("X-Ms-Copy-Source"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidHeaderValue")
+		return
+	}
+
+	o, ok := f.objects[strings.TrimPrefix(src.Path, "/"+testContainer+"/")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "CannotVerifyCopySource")
+		return
+	}
+	if !f.writable(w, r, key) {
+		return
+	}
+	o.Key, o.ModTime = key, time.Now().UTC().Truncate(time.Second)
+	f.objects[key] = o
+
+	w.Header().Set("ETag", etag(o))
+	w.Header().Set("Last-Modified", o.ModTime.Format(http.TimeFormat))
+	w.Header().Set("X-Ms-Copy-Id", "copy-"+strconv.FormatInt(o.ModTime.UnixNano(), 36))
+	w.Header().Set("X-Ms-Copy-Status", "success")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (f *fakeAzure) stageBlock(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+	if f.blocks[key] == nil {
+		f.blocks[key] = map[string][]byte{}
+	}
+	f.blocks[key][r.URL.Query().Get("blockid")] = body
+	w.WriteHeader(http.StatusCreated)
+}
+
+// upload creates a blob from the body of a single request.
+func (f *fakeAzure) upload(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+	if f.writable(w, r, key) {
+		f.store(w, r, key, string(body))
+	}
+}
+
+// commitBlocks creates a blob from the blocks staged for it, in the order in
+// which they are listed.
+func (f *fakeAzure) commitBlocks(w http.ResponseWriter, r *http.Request, key string) {
+	var list struct {
+		Latest []string `xml:"Latest"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidXmlDocument")
+		return
+	}
+	if !f.writable(w, r, key) {
+		return
+	}
+
+	var body strings.Builder
+	for _, id := range list.Latest {
+		b, ok := f.blocks[key][id]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidBlockList")
+			return
+		}
+		body.Write(b)
+	}
+	delete(f.blocks, key)
+	f.store(w, r, key, body.String())
+}
+
+// store stores a blob along with the properties and metadata in the headers
+// of the request that created it.
+func (f *fakeAzure) store(w http.ResponseWriter, r *http.Request, key, body string) {
+	o := proxytest.Object{
+		Key:          key,
+		Body:         body,
+		ContentType:  r.Header.Get("X-Ms-Blob-Content-Type"),
+		CacheControl: r.Header.Get("X-Ms-Blob-Cache-Control"),
+		ModTime:      time.Now().UTC().Truncate(time.Second),
+	}
+	for k, v := range r.Header {
+		if name, ok := strings.CutPrefix(k, "X-Ms-Meta-"); ok {
+			if o.Metadata == nil {
+				o.Metadata = map[string]string{}
+			}
+			o.Metadata[strings.ToLower(name)] = v[0]
+		}
+	}
+	f.objects[key] = o
+
+	w.Header().Set("ETag", etag(o))
+	w.Header().Set("Last-Modified", o.ModTime.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// writable reports whether key may be written, responding with an error
+// when it already exists and was only to be created.
+func (f *fakeAzure) writable(w http.ResponseWriter, r *http.Request, key string) bool {
+	if _, ok := f.objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+		writeError(w, http.StatusConflict, "BlobAlreadyExists")
+		return false
+	}
+	return true
+}
+
+type listBlob struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		LastModified  string `xml:"Last-Modified"`
+		ETag          string `xml:"Etag"`
+		ContentLength int    `xml:"Content-Length"`
+		ContentType   string `xml:"Content-Type"`
+		BlobType      string `xml:"BlobType"`
+	} `xml:"Properties"`
+}
+
+type listPrefix struct {
+	Name string `xml:"Name"`
+}
+
+func (f *fakeAzure) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delim, marker := q.Get("prefix"), q.Get("delimiter"), q.Get("marker")
+	max, _ := strconv.Atoi(q.Get("maxresults"))
+	if max <= 0 {
+		max = 5000
+	}
+
+	// Blobs and prefixes are merged in lexicographic order, and the marker
+	// is the next name to be returned
+	names := map[string]bool{}
+	for key := range f.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delim); delim != "" && i >= 0 {
+			names[prefix+rest[:i+len(delim)]] = true
+		} else {
+			names[key] = false
+		}
+	}
+	var sorted []string
+	for name := range names {
+		if name >= marker {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	var resp struct {
+		XMLName       xml.Name     `xml:"EnumerationResults"`
+		ContainerName string       `xml:"ContainerName,attr"`
+		Prefix        string       `xml:"Prefix"`
+		Delimiter     string       `xml:"Delimiter"`
+		Blobs         []listBlob   `xml:"Blobs>Blob"`
+		Prefixes      []listPrefix `xml:"Blobs>BlobPrefix"`
+		NextMarker    string       `xml:"NextMarker"`
+	}
+	resp.ContainerName, resp.Prefix, resp.Delimiter = testContainer, prefix, delim
+	for i, name := range sorted {
+		if i == max {
+			resp.NextMarker = name
+			break
+		}
+		if names[name] {
+			resp.Prefixes = append(resp.Prefixes, listPrefix{Name: name})
+			continue
+		}
+		o := f.objects[name]
+		b := listBlob{Name: name}
+		b.Properties.LastModified = o.ModTime.Format(http.TimeFormat)
+		b.Properties.ETag = strings.Trim(etag(o), `"`)
+		b.Properties.ContentLength = len(o.Body)
+		b.Properties.ContentType = o.ContentType
+		b.Properties.BlobType = "BlockBlob"
+		resp.Blobs = append(resp.Blobs, b)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func writeError(w http.ResponseWriter, code int, errCode string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Ms-Error-Code", errCode)
+	w.Header().Set("X-Ms-Request-Id", "fake-request")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, errCode, http.StatusText(code))
+}
+
+func newTestBackend(t *testing.T) proxy.Backend {
+	srv := httptest.NewServer(newFakeAzure(proxytest.Objects))
+	t.Cleanup(srv.Close)
+	t.Setenv("SSP_TEST_AZURE_SAS", "sv=2023-11-03&sig=fake")
+
+	b, err := NewBackend(Config{
+		Account:   "devstoreaccount1",
+		Container: testContainer,
+		Endpoint:  srv.URL,
+		SASToken:  "env:SSP_TEST_AZURE_SAS",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBackendConformance(t *testing.T) {
+	proxytest.TestBackend(t, newTestBackend(t))
+}
+
+func TestHandlerConformance(t *testing.T) {
+	proxytest.TestHandler(t, newTestBackend(t), proxytest.Capabilities{})
+}
+
+func TestUploaderConformance(t *testing.T) {
+	proxytest.TestUploader(t, newTestBackend(t))
+}
+
+func TestDeleterConformance(t *testing.T) {
+	proxytest.TestDeleter(t, newTestBackend(t))
+}
+
+func TestCopierConformance(t *testing.T) {
+	proxytest.TestCopier(t, newTestBackend(t))
+}
@@ -0,0 +1,86 @@
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// Config describes how to connect to an Azure Blob Storage container.
+type Config struct {
+	Account   string
+	Container string
+
+	// Endpoint is the base URL of the blob service, e.g.,
+	// http://127.0.0.1:10000/devstoreaccount1 for a local Azurite. Empty uses
+	// https://<account>.blob.core.windows.net.
+	Endpoint string
+
+	// AccountKey, SASToken and ConnectionString are references to secrets, in
+	// the form "env:NAME" or "file:/path". At most one may be set; when none
+	// are, the default Azure credential chain is used instead.
+	AccountKey       string
+	SASToken         string
+	ConnectionString string
+}
+
+func (c Config) containerURL() string {
+	ep := c.Endpoint
+	if ep == "" {
+		ep = fmt.Sprintf("https://%s.blob.core.windows.net", c.Account)
+	}
+	return strings.TrimSuffix(ep, "/") + "/" + c.Container
+}
+
+func newClient(c Config) (*container.Client, error) {
+	n := 0
+	for _, ref := range []string{c.AccountKey, c.SASToken, c.ConnectionString} {
+		if ref != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, errors.New("at most one of an account key, SAS token or connection string may be configured")
+	}
+	if c.ConnectionString == "" && c.Account == "" && c.Endpoint == "" {
+		return nil, errors.New("Storage account name is required")
+	}
+
+	switch {
+	case c.ConnectionString != "":
+		cs, err := proxy.ResolveReference(c.ConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		return container.NewClientFromConnectionString(cs, c.Container, nil)
+
+	case c.AccountKey != "":
+		key, err := proxy.ResolveReference(c.AccountKey)
+		if err != nil {
+			return nil, err
+		}
+		cred, err := container.NewSharedKeyCredential(c.Account, key)
+		if err != nil {
+			return nil, err
+		}
+		return container.NewClientWithSharedKeyCredential(c.containerURL(), cred, nil)
+
+	case c.SASToken != "":
+		sas, err := proxy.ResolveReference(c.SASToken)
+		if err != nil {
+			return nil, err
+		}
+		return container.NewClientWithNoCredential(c.containerURL()+"?"+strings.TrimPrefix(sas, "?"), nil)
+	}
+
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return container.NewClient(c.containerURL(), cred, nil)
+}
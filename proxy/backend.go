@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrNotExist is returned by a Backend when the requested object does not exist.
+var ErrNotExist = errors.New("object does not exist")
+
+// ErrExist is returned by an Uploader when an object already exists at a key
+// that was only to be created.
+var ErrExist = errors.New("object already exists")
+
+// IsNotExist reports whether err means that an object does not exist, either
+// because it is ErrNotExist or a BackendError with a 404 status.
+func IsNotExist(err error) bool {
+	if errors.Is(err, ErrNotExist) {
+		return true
+	}
+	var be *BackendError
+	return errors.As(err, &be) && be.StatusCode == http.StatusNotFound
+}
+
+// IsExist reports whether err means that an object already exists, either
+// because it is ErrExist or a BackendError with a 412 status.
+func IsExist(err error) bool {
+	if errors.Is(err, ErrExist) {
+		return true
+	}
+	var be *BackendError
+	return errors.As(err, &be) && be.StatusCode == http.StatusPreconditionFailed
+}
+
+// Backend is an object store that can be served over HTTP by a Handler. Keys
+// never begin with a slash; prefixes, when not empty, always end with one.
+type Backend interface {
+	// Stat returns the attributes of the object at key.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Get returns the object at key, or only the requested range of it when
+	// rng is not nil.
+	Get(ctx context.Context, key string, rng *ByteRange) (*Object, error)
+	// List returns one page of the objects and common prefixes immediately
+	// under prefix.
+	List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error)
+
+	// UpdateLogContext adds backend-specific fields about key to the request
+	// logger, e.g., the bucket name.
+	UpdateLogContext(c zerolog.Context, key string) zerolog.Context
+}
+
+// Uploader is implemented by backends to which objects can be uploaded.
+type Uploader interface {
+	// Put stores the contents of body at key, replacing any object already
+	// there. A failed upload leaves no partial object behind.
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error)
+}
+
+// Deleter is implemented by backends from which objects can be deleted.
+type Deleter interface {
+	// Delete removes the object at key. Deleting an object that does not
+	// exist either succeeds or returns an error for which IsNotExist is true.
+	Delete(ctx context.Context, key string) error
+}
+
+// Copier is implemented by backends that can copy objects without them
+// passing through the proxy.
+type Copier interface {
+	// Copy stores a copy of the object at src, along with its attributes, at
+	// dst, replacing any object already there. Copying an object that does
+	// not exist returns an error for which IsNotExist is true.
+	Copy(ctx context.Context, src, dst string, opts CopyOptions) (*ObjectInfo, error)
+}
+
+// CopyOptions are the preconditions of a copy.
+type CopyOptions struct {
+	// IfNoneMatch, when set, only copies the object if there is none at dst
+	// yet, and otherwise fails with an error for which IsExist is true.
+	IfNoneMatch bool
+}
+
+// PostSigner is implemented by backends that can sign policies with which
+// browsers upload objects directly to them, by POSTing HTML forms.
+type PostSigner interface {
+	// SignPost returns a policy that allows uploading objects under prefix,
+	// named after the uploaded file.
+	SignPost(ctx context.Context, prefix string, opts PostOptions) (*PostPolicy, error)
+}
+
+// PostOptions are the conditions of a signed POST policy.
+type PostOptions struct {
+	// Expires is how long the policy may be used for.
+	Expires time.Duration
+	// MaxBytes is the size of the largest object that may be uploaded.
+	MaxBytes int64
+	// RedirectURL, when set, is where browsers are sent after uploading.
+	RedirectURL string
+}
+
+// PostPolicy is a signed POST policy. Objects are uploaded by POSTing a
+// multipart form to URL, with Fields followed by the contents of the object
+// in a field named "file".
+type PostPolicy struct {
+	URL     string            `json:"url"`
+	Fields  map[string]string `json:"fields"`
+	Expires time.Time         `json:"expires"`
+}
+
+// PutOptions are the attributes of an uploaded object.
+type PutOptions struct {
+	// Size is the number of bytes in the body, or -1 when it is not known
+	// in advance.
+	Size int64
+	// IfNoneMatch, when set, only stores the object if there is none at key
+	// yet, and otherwise fails with an error for which IsExist is true.
+	IfNoneMatch bool
+
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentType        string
+
+	// Metadata is user metadata, by lowercase name.
+	Metadata map[string]string
+}
+
+// ObjectInfo describes an object, mostly in terms of its HTTP headers.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentType        string
+	ETag               string
+	Expires            string
+
+	// IsDirectory is true when the object is a placeholder for a directory.
+	IsDirectory bool
+	// RedirectLocation, if not empty, is where requests for this object should
+	// be redirected to instead.
+	RedirectLocation string
+
+	// Header holds any backend-specific headers, such as user metadata.
+	Header http.Header
+}
+
+// Object is an object's attributes along with its contents.
+type Object struct {
+	ObjectInfo
+
+	Body io.ReadCloser
+	// Length is the number of bytes in Body.
+	Length int64
+	// ContentRange is set when Body is only part of the object.
+	ContentRange string
+}
+
+// ByteRange is a single range of bytes within an object. A negative Offset
+// refers to the last -Offset bytes of the object, in which case Length is
+// ignored. A negative Length reads until the end of the object.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// ListOptions controls which page of a listing is returned.
+type ListOptions struct {
+	// Token continues a previous listing, as returned in ListResult.NextToken.
+	// An empty token starts from the beginning.
+	Token string
+	// Limit is the maximum number of objects and prefixes returned. Backends
+	// may return fewer, even when more remain.
+	Limit int
+}
+
+// ListResult is one page of a listing of a prefix, as returned by a Backend.
+type ListResult struct {
+	Objects  []ObjectInfo
+	Prefixes []string
+
+	// IsTruncated is true when more entries remain, in which case NextToken
+	// is used to continue the listing.
+	IsTruncated bool
+	NextToken   string
+}
+
+// BackendError is an error returned by a backend that carries the HTTP status
+// code and message that should be returned to the client.
+type BackendError struct {
+	StatusCode int
+	Message    string
+	Err        error
+
+	// Fields are logged alongside the error, e.g., the backend's request ID.
+	Fields map[string]interface{}
+}
+
+func (e *BackendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,255 @@
+// Package bandwidth limits how fast downloads are sent.
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ripta/ssp/proxy/clientip"
+)
+
+// maxChunk is the most that is written at once by a throttled writer, so
+// that a large write does not wait for all of its bytes at once.
+const maxChunk = 32 * 1024
+
+// sweepInterval is how often clients that have been idle long enough for
+// their allowance to be full again are forgotten.
+const sweepInterval = time.Minute
+
+// limitStats are the metrics of limits, by name, which are published with
+// expvar as "bandwidth". Names are made unique by New.
+var (
+	limitStats   = expvar.NewMap("bandwidth")
+	limitStatsMu sync.Mutex
+)
+
+// Config configures a Limit, in bytes per second.
+type Config struct {
+	// Rate, when positive, is shared by all downloads, while ClientRate,
+	// when positive, is shared by all downloads of each client address.
+	Rate       int64
+	ClientRate int64
+
+	// Burst is how many bytes may be sent at once before being throttled,
+	// which defaults to one second's worth at each rate. The allowance of a
+	// client refills while it is idle, so that small objects are not slowed
+	// down by a ClientRate.
+	Burst int64
+}
+
+// Limit limits how fast downloads are sent.
+type Limit struct {
+	shared      *rate.Limiter
+	client      rate.Limit
+	clientBurst int
+	idle        time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+
+	bytes          *expvar.Int
+	throttledBytes *expvar.Int
+	throttledMs    *expvar.Int
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	inFlight int
+	lastSeen time.Time
+}
+
+// New creates a Limit, whose metrics are published under name, or under name
+// followed by "#2", "#3" and so on when another Limit already uses it.
+func New(name string, c Config) (*Limit, error) {
+	if c.Rate < 0 || c.ClientRate < 0 || c.Burst < 0 {
+		return nil, errors.New("bandwidth limits must not be negative")
+	}
+	if c.Rate == 0 && c.ClientRate == 0 {
+		return nil, errors.New("bandwidth limits require a rate")
+	}
+
+	burst := func(r int64) int {
+		if c.Burst > 0 {
+			return int(c.Burst)
+		}
+		return int(r)
+	}
+	l := &Limit{client: rate.Limit(c.ClientRate), clientBurst: burst(c.ClientRate), clients: map[string]*client{}}
+	if c.Rate > 0 {
+		l.shared = rate.NewLimiter(rate.Limit(c.Rate), burst(c.Rate))
+	}
+
+	// A client's allowance is full again once it has been idle for long
+	// enough, at which point it is no different from a new client
+	l.idle = sweepInterval
+	if c.ClientRate > 0 {
+		l.idle = max(l.idle, time.Duration(float64(l.clientBurst)/float64(c.ClientRate)*float64(time.Second)))
+	}
+
+	l.bytes, l.throttledBytes, l.throttledMs = new(expvar.Int), new(expvar.Int), new(expvar.Int)
+	stats := new(expvar.Map)
+	stats.Set("bytes", l.bytes)
+	stats.Set("throttled_bytes", l.throttledBytes)
+	stats.Set("throttled_ms", l.throttledMs)
+	publish(name, stats)
+	return l, nil
+}
+
+// publish adds stats to limitStats under name, or under the first of name#2,
+// name#3, and so on, that is not taken yet.
+func publish(name string, stats *expvar.Map) {
+	limitStatsMu.Lock()
+	defer limitStatsMu.Unlock()
+
+	unique := name
+	for i := 2; limitStats.Get(unique) != nil; i++ {
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+	limitStats.Set(unique, stats)
+}
+
+type contextKey struct{}
+
+// binding is a limit, along with the allowance of the client of a request.
+type binding struct {
+	limit  *Limit
+	client *rate.Limiter
+}
+
+// Handler adds the limit to requests to h. Downloads written by Writer are
+// throttled by it, along with any other limits added earlier.
+func (l *Limit) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := binding{limit: l}
+		if l.client > 0 {
+			key := clientip.Addr(r).String()
+			b.client = l.acquire(key, time.Now())
+			defer l.release(key)
+		}
+
+		bs, _ := r.Context().Value(contextKey{}).([]binding)
+		bs = append(bs[:len(bs):len(bs)], b)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, bs)))
+	})
+}
+
+// acquire returns the allowance of a client, which is shared by all of its
+// downloads in progress.
+func (l *Limit) acquire(key string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(l.client, l.clientBurst)}
+		l.clients[key] = c
+	}
+	c.inFlight++
+	c.lastSeen = now
+	return c.limiter
+}
+
+func (l *Limit) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c, ok := l.clients[key]; ok {
+		c.inFlight--
+		c.lastSeen = time.Now()
+	}
+}
+
+// sweep forgets clients that have been idle long enough.
+func (l *Limit) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, c := range l.clients {
+		if c.inFlight == 0 && now.Sub(c.lastSeen) > l.idle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Writer returns w throttled by the limits added to ctx by Handler, or w
+// itself when there are none. Writes fail once ctx is done.
+func Writer(ctx context.Context, w io.Writer) io.Writer {
+	bs, _ := ctx.Value(contextKey{}).([]binding)
+	if len(bs) == 0 {
+		return w
+	}
+
+	tw := &throttledWriter{ctx: ctx, w: w, chunk: maxChunk}
+	for _, b := range bs {
+		tw.limits = append(tw.limits, b.limit)
+		if b.limit.shared != nil {
+			tw.limiters = append(tw.limiters, b.limit.shared)
+		}
+		if b.client != nil {
+			tw.limiters = append(tw.limiters, b.client)
+		}
+	}
+	for _, rl := range tw.limiters {
+		tw.chunk = min(tw.chunk, rl.Burst())
+	}
+	tw.chunk = max(tw.chunk, 1)
+	return tw
+}
+
+type throttledWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limits   []*Limit
+	limiters []*rate.Limiter
+	chunk    int
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), tw.chunk)
+		if err := tw.wait(n); err != nil {
+			return written, err
+		}
+		m, err := tw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait waits until n bytes may be written under every limit.
+func (tw *throttledWriter) wait(n int) error {
+	start := time.Now()
+	for _, rl := range tw.limiters {
+		if err := rl.WaitN(tw.ctx, n); err != nil {
+			return err
+		}
+	}
+	d := time.Since(start)
+
+	// Waits too short to be throttling are not counted
+	throttled := d >= time.Millisecond
+	for _, l := range tw.limits {
+		l.bytes.Add(int64(n))
+		if throttled {
+			l.throttledBytes.Add(int64(n))
+			l.throttledMs.Add(d.Milliseconds())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustNew(t *testing.T, name string, c Config) *Limit {
+	t.Helper()
+
+	l, err := New(name, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// download writes n bytes through the limits of ls, and returns how long it
+// took.
+func download(t *testing.T, n int, ls ...*Limit) time.Duration {
+	t.Helper()
+	return downloadFrom(t, "192.0.2.1:1234", n, ls...)
+}
+
+// downloadFrom is download by a client at addr.
+func downloadFrom(t *testing.T, addr string, n int, ls ...*Limit) time.Duration {
+	t.Helper()
+
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if _, err := Writer(r.Context(), w).Write(make([]byte, n)); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+		w.Header().Set("X-Duration", time.Since(start).String())
+	})
+	for _, l := range ls {
+		h = l.Handler(h)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = addr
+	h.ServeHTTP(w, r)
+	if w.Body.Len() != n {
+		t.Errorf("wrote %d bytes, want %d", w.Body.Len(), n)
+	}
+	d, _ := time.ParseDuration(w.Header().Get("X-Duration"))
+	return d
+}
+
+func TestClientRate(t *testing.T) {
+	l := mustNew(t, "test-client", Config{ClientRate: 10000, Burst: 1000})
+
+	if d := download(t, 1000, l); d > 50*time.Millisecond {
+		t.Errorf("download within the burst took %v", d)
+	}
+	// The client used up its allowance with the first download, so all of
+	// this one is throttled
+	if d := download(t, 2000, l); d < 150*time.Millisecond {
+		t.Errorf("second download took %v, want at least 150ms", d)
+	}
+	// Other clients have their own allowance
+	if d := downloadFrom(t, "192.0.2.2:1234", 1000, l); d > 50*time.Millisecond {
+		t.Errorf("download by another client took %v", d)
+	}
+
+	if got := l.bytes.Value(); got != 4000 {
+		t.Errorf("bytes = %d, want 4000", got)
+	}
+	if got := l.throttledBytes.Value(); got != 2000 {
+		t.Errorf("throttled bytes = %d, want 2000", got)
+	}
+	if got := l.throttledMs.Value(); got < 150 {
+		t.Errorf("throttled ms = %d, want at least 150", got)
+	}
+}
+
+func TestClientRateConcurrent(t *testing.T) {
+	l := mustNew(t, "test-concurrent", Config{ClientRate: 10000, Burst: 1000})
+
+	// Parallel downloads by one client share its allowance, so together
+	// they take as long as a single download of all of their bytes
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			download(t, 1000, l)
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("parallel downloads took %v, want at least 250ms", d)
+	}
+}
+
+func TestClientSweep(t *testing.T) {
+	l := mustNew(t, "test-sweep", Config{ClientRate: 1000})
+
+	now := time.Now()
+	l.acquire("192.0.2.1", now)
+	l.release("192.0.2.1")
+	l.acquire("192.0.2.2", now)
+
+	l.acquire("192.0.2.3", now.Add(2*sweepInterval))
+	if _, ok := l.clients["192.0.2.1"]; ok {
+		t.Error("idle client was not forgotten")
+	}
+	if _, ok := l.clients["192.0.2.2"]; !ok {
+		t.Error("client with a download in progress was forgotten")
+	}
+}
+
+func TestNewUniqueNames(t *testing.T) {
+	mustNew(t, "test-unique", Config{Rate: 1})
+	mustNew(t, "test-unique", Config{Rate: 1})
+	mustNew(t, "test-unique", Config{Rate: 1})
+
+	for _, name := range []string{"test-unique", "test-unique#2", "test-unique#3"} {
+		if limitStats.Get(name) == nil {
+			t.Errorf("metrics of %q were not published", name)
+		}
+	}
+}
+
+func TestSharedRate(t *testing.T) {
+	l := mustNew(t, "test-shared", Config{Rate: 10000, Burst: 2000})
+
+	if d := download(t, 2000, l); d > 50*time.Millisecond {
+		t.Errorf("first download took %v", d)
+	}
+	// The allowance was used up by the first download
+	if d := download(t, 2000, l); d < 150*time.Millisecond {
+		t.Errorf("second download took %v, want at least 150ms", d)
+	}
+}
+
+func TestWriterWithoutLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if w := Writer(context.Background(), &buf); w != &buf {
+		t.Errorf("Writer() = %T, want the writer itself", w)
+	}
+}
+
+func TestWriterCanceled(t *testing.T) {
+	l := mustNew(t, "test-canceled", Config{ClientRate: 100, Burst: 100})
+	ctx, cancel := context.WithCancel(context.Background())
+	var w http.ResponseWriter = httptest.NewRecorder()
+	l.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	cancel()
+	n, err := Writer(ctx, w).Write(make([]byte, 1000))
+	if !errors.Is(err, context.Canceled) || n != 0 {
+		t.Errorf("Write() = %d, %v; want 0, %v", n, err, context.Canceled)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, c := range []Config{{}, {Rate: -1}, {ClientRate: 1, Burst: -1}} {
+		if _, err := New("test-errors", c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
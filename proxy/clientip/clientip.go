@@ -0,0 +1,205 @@
+// Package clientip resolves the address of the client behind trusted proxies,
+// and restricts access by that address.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Prefixes is a list of address ranges.
+type Prefixes []netip.Prefix
+
+// ParsePrefixes parses CIDRs, e.g., "10.0.0.0/8", or single addresses, which
+// are treated as ranges of one.
+func ParsePrefixes(ss []string) (Prefixes, error) {
+	var ps Prefixes
+	for _, s := range ss {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", s, err)
+			}
+			addr = addr.Unmap()
+			ps = append(ps, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		ps = append(ps, p.Masked())
+	}
+	return ps, nil
+}
+
+// Contains reports whether addr is in any of the ranges.
+func (ps Prefixes) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range ps {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct {
+	name string
+}
+
+var contextKeyViaProxy = &contextKey{"via-proxy"}
+
+// ViaTrustedProxy reports whether a request was received from a trusted
+// proxy, whose forwarding headers may be believed.
+func ViaTrustedProxy(ctx context.Context) bool {
+	v, _ := ctx.Value(contextKeyViaProxy).(bool)
+	return v
+}
+
+// Addr returns the address of the client making a request, which is that in
+// r.RemoteAddr once Handler has resolved it.
+func Addr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.WithZone("").Unmap()
+}
+
+// Handler replaces the remote address of requests received from any of
+// trusted with that of the client, as forwarded in the Forwarded,
+// X-Forwarded-For or X-Real-IP headers, in that order of preference. Those
+// headers are ignored on requests from anywhere else, since clients could
+// set them to anything. The address of the proxy is added to the request's
+// logger.
+func Handler(trusted Prefixes) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer := Addr(r)
+			if !peer.IsValid() || !trusted.Contains(peer) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			client := resolve(trusted, forwardedFor(r.Header))
+			if !client.IsValid() {
+				client = peer
+			}
+
+			hlog.FromRequest(r).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("proxy_addr", r.RemoteAddr)
+			})
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyViaProxy, true))
+			r.RemoteAddr = client.String()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// resolve returns the client in a chain of forwarded addresses, which is the
+// nearest one that is not a trusted proxy. Proxies append the address they
+// received a request from, so only addresses from the end of the chain up to
+// the first untrusted one can be believed.
+func resolve(trusted Prefixes, chain []string) netip.Addr {
+	var client netip.Addr
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseNode(chain[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !trusted.Contains(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the chain of forwarded addresses in the headers of a
+// request, from the original client to the nearest proxy.
+func forwardedFor(h http.Header) []string {
+	if vs := h.Values("Forwarded"); len(vs) > 0 {
+		var chain []string
+		for _, v := range vs {
+			for _, elem := range strings.Split(v, ",") {
+				node := ""
+				for _, pair := range strings.Split(elem, ";") {
+					k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(k, "for") {
+						node = v
+					}
+				}
+				chain = append(chain, node)
+			}
+		}
+		return chain
+	}
+
+	if vs := h.Values("X-Forwarded-For"); len(vs) > 0 {
+		var chain []string
+		for _, v := range vs {
+			chain = append(chain, strings.Split(v, ",")...)
+		}
+		return chain
+	}
+
+	if v := h.Get("X-Real-IP"); v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+// parseNode parses an address in a forwarding header, which may be quoted,
+// bracketed or carry a port, as in `"[2001:db8::1]:4711"`. Obfuscated and
+// unknown nodes cannot be parsed.
+func parseNode(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// ACL allows or denies access by the address of the client.
+type ACL struct {
+	// Allow, when not empty, are the only ranges from which clients are
+	// allowed.
+	Allow Prefixes
+	// Deny are ranges from which clients are denied, even when they are
+	// also in Allow.
+	Deny Prefixes
+}
+
+// Allows reports whether a client at addr is allowed.
+func (acl ACL) Allows(addr netip.Addr) bool {
+	if acl.Deny.Contains(addr) {
+		return false
+	}
+	return len(acl.Allow) == 0 || acl.Allow.Contains(addr)
+}
+
+// Handler refuses requests to h from clients that are not allowed.
+func (acl ACL) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acl.Allows(Addr(r)) {
+			hlog.FromRequest(r).Warn().Msg("client address is not allowed")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
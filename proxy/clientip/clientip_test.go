@@ -0,0 +1,112 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func mustParsePrefixes(t *testing.T, ss ...string) Prefixes {
+	t.Helper()
+
+	ps, err := ParsePrefixes(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func TestParsePrefixes(t *testing.T) {
+	ps := mustParsePrefixes(t, "10.1.2.3/8", "192.0.2.1", "2001:db8::/32", "::ffff:198.51.100.7")
+	for _, s := range []string{"10.200.0.1", "192.0.2.1", "2001:db8::1", "198.51.100.7", "::ffff:10.0.0.1"} {
+		if !ps.Contains(netip.MustParseAddr(s)) {
+			t.Errorf("Contains(%s) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"11.0.0.1", "192.0.2.2", "2001:db9::1"} {
+		if ps.Contains(netip.MustParseAddr(s)) {
+			t.Errorf("Contains(%s) = true, want false", s)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip", ""} {
+		if _, err := ParsePrefixes([]string{s}); err == nil {
+			t.Errorf("ParsePrefixes(%q) succeeded", s)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	trusted := mustParsePrefixes(t, "10.0.0.0/8", "192.168.1.1")
+
+	tests := []struct {
+		name      string
+		peer      string
+		headers   map[string][]string
+		want      string
+		wantProxy bool
+	}{
+		{"direct", "203.0.113.5:1234", nil, "203.0.113.5:1234", false},
+		{"untrusted peer", "203.0.113.5:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.5:1234", false},
+		{"x-forwarded-for", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1", true},
+		{"spoofed x-forwarded-for", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}}, "198.51.100.1", true},
+		{"chain of proxies", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 192.168.1.1", "10.0.0.2"}}, "198.51.100.1", true},
+		{"only proxies", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3", true},
+		{"garbage", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"garbage"}}, "10.0.0.1", true},
+		{"x-real-ip", "10.0.0.1:1234", map[string][]string{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1", true},
+		{"forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`}}, "2001:db8::1", true},
+		{"forwarded wins", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.1", true},
+		{"obfuscated", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1, for=_hidden"}}, "10.0.0.1", true},
+		{"no headers", "10.0.0.1:1234", nil, "10.0.0.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			var gotProxy bool
+			h := Handler(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, gotProxy = r.RemoteAddr, ViaTrustedProxy(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			for k, vs := range tt.headers {
+				r.Header[k] = vs
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want || gotProxy != tt.wantProxy {
+				t.Errorf("remote address = %q, via proxy = %v; want %q, %v", got, gotProxy, tt.want, tt.wantProxy)
+			}
+		})
+	}
+}
+
+func TestACL(t *testing.T) {
+	tests := []struct {
+		name string
+		acl  ACL
+		addr string
+		want bool
+	}{
+		{"empty", ACL{}, "198.51.100.1:80", true},
+		{"allowed", ACL{Allow: mustParsePrefixes(t, "198.51.100.0/24")}, "198.51.100.1:80", true},
+		{"not allowed", ACL{Allow: mustParsePrefixes(t, "198.51.100.0/24")}, "203.0.113.1:80", false},
+		{"denied", ACL{Deny: mustParsePrefixes(t, "198.51.100.0/24")}, "198.51.100.1:80", false},
+		{"not denied", ACL{Deny: mustParsePrefixes(t, "198.51.100.0/24")}, "203.0.113.1:80", true},
+		{"deny wins", ACL{Allow: mustParsePrefixes(t, "198.51.100.0/24"), Deny: mustParsePrefixes(t, "198.51.100.1")}, "198.51.100.1:80", false},
+		{"ipv6", ACL{Allow: mustParsePrefixes(t, "2001:db8::/32")}, "[2001:db8::1]:80", true},
+		{"mapped ipv4", ACL{Allow: mustParsePrefixes(t, "198.51.100.0/24")}, "[::ffff:198.51.100.1]:80", true},
+		{"unparseable", ACL{Allow: mustParsePrefixes(t, "198.51.100.0/24")}, "pipe", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.addr
+			w := httptest.NewRecorder()
+			tt.acl.Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+			if got := w.Code != http.StatusForbidden; got != tt.want {
+				t.Errorf("allowed = %v (status %d), want %v", got, w.Code, tt.want)
+			}
+		})
+	}
+}
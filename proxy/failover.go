@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// DefaultBackendHeader is the response header naming the backend that served
+// a request, when the handler fails over between several backends.
+const DefaultBackendHeader = "X-Ssp-Backend"
+
+// NamedHandler is a request handler along with the name it is known by.
+type NamedHandler struct {
+	Name    string
+	Handler http.Handler
+
+	// Backend is the object store served by Handler, if any.
+	Backend Backend
+}
+
+type failoverHandler struct {
+	Backends []NamedHandler
+	Statuses map[int]bool
+	Header   string
+}
+
+// NewFailoverHandler creates an HTTP handler that tries each backend in turn,
+// moving on to the next one whenever a backend responds with one of statuses.
+// When statuses is empty, any 404 or 5xx fails over. The response of the last
+// backend is always returned as-is. The name of the backend that served the
+// response is returned in the header named by header.
+func NewFailoverHandler(backends []NamedHandler, statuses []int, header string) http.Handler {
+	fh := &failoverHandler{
+		Backends: backends,
+		Header:   header,
+	}
+	if fh.Header == "" {
+		fh.Header = DefaultBackendHeader
+	}
+	if len(statuses) > 0 {
+		fh.Statuses = make(map[int]bool, len(statuses))
+		for _, s := range statuses {
+			fh.Statuses[s] = true
+		}
+	}
+	return fh
+}
+
+func (fh *failoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	last := len(fh.Backends) - 1
+	for i, b := range fh.Backends {
+		if i == last {
+			w.Header().Set(fh.Header, b.Name)
+			b.Handler.ServeHTTP(w, r)
+			return
+		}
+
+		fw := &failoverWriter{
+			w:          w,
+			header:     http.Header{},
+			shouldFail: fh.shouldFail,
+			name:       b.Name,
+			headerName: fh.Header,
+		}
+		b.Handler.ServeHTTP(fw, r)
+		if !fw.failed {
+			// Commit the headers of an implicitly-successful empty response
+			fw.WriteHeader(http.StatusOK)
+			return
+		}
+		log.Warn().Str("backend", b.Name).Int("backend_status", fw.status).Msg("failing over to next backend")
+	}
+}
+
+func (fh *failoverHandler) shouldFail(status int) bool {
+	if fh.Statuses == nil {
+		return status == http.StatusNotFound || status >= 500
+	}
+	return fh.Statuses[status]
+}
+
+// failoverWriter holds back a backend's response until its status is known.
+// Failed responses are discarded, while successful ones are streamed through.
+type failoverWriter struct {
+	w          http.ResponseWriter
+	header     http.Header
+	shouldFail func(int) bool
+	name       string
+	headerName string
+
+	committed bool
+	failed    bool
+	status    int
+}
+
+func (fw *failoverWriter) Header() http.Header {
+	if fw.committed {
+		return fw.w.Header()
+	}
+	return fw.header
+}
+
+func (fw *failoverWriter) WriteHeader(status int) {
+	if fw.committed || fw.failed {
+		return
+	}
+
+	fw.status = status
+	if fw.shouldFail(status) {
+		fw.failed = true
+		return
+	}
+
+	hdr := fw.w.Header()
+	for k, vs := range fw.header {
+		hdr[k] = vs
+	}
+	hdr.Set(fw.headerName, fw.name)
+	fw.w.WriteHeader(status)
+	fw.committed = true
+}
+
+func (fw *failoverWriter) Write(p []byte) (int, error) {
+	if !fw.committed && !fw.failed {
+		fw.WriteHeader(http.StatusOK)
+	}
+	if fw.failed {
+		return len(p), nil
+	}
+	return fw.w.Write(p)
+}
+
+func (fw *failoverWriter) Flush() {
+	if f, ok := fw.w.(http.Flusher); ok && fw.committed {
+		f.Flush()
+	}
+}
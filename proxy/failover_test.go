@@ -0,0 +1,146 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// failoverBackend responds with status and body or, when status is zero, only
+// sets a header and leaves the rest of the response implicit.
+type failoverBackend struct {
+	name   string
+	status int
+	body   string
+}
+
+func TestFailoverHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		backends   []failoverBackend
+		statuses   []int
+		header     string
+		wantStatus int
+		wantBody   string
+		wantFrom   string
+		wantCalled []string
+	}{
+		{
+			name:       "not found",
+			backends:   []failoverBackend{{"primary", http.StatusNotFound, "missing"}, {"secondary", http.StatusOK, "found"}},
+			wantStatus: http.StatusOK, wantBody: "found", wantFrom: "secondary",
+			wantCalled: []string{"primary", "secondary"},
+		},
+		{
+			name:       "server error",
+			backends:   []failoverBackend{{"primary", http.StatusServiceUnavailable, "down"}, {"secondary", http.StatusOK, "found"}},
+			wantStatus: http.StatusOK, wantBody: "found", wantFrom: "secondary",
+			wantCalled: []string{"primary", "secondary"},
+		},
+		{
+			name: "several failures",
+			backends: []failoverBackend{
+				{"primary", http.StatusNotFound, "missing"},
+				{"secondary", http.StatusBadGateway, "bad gateway"},
+				{"tertiary", http.StatusOK, "found"},
+			},
+			wantStatus: http.StatusOK, wantBody: "found", wantFrom: "tertiary",
+			wantCalled: []string{"primary", "secondary", "tertiary"},
+		},
+		{
+			name:       "success",
+			backends:   []failoverBackend{{"primary", http.StatusOK, "found"}, {"secondary", http.StatusOK, "also found"}},
+			wantStatus: http.StatusOK, wantBody: "found", wantFrom: "primary",
+			wantCalled: []string{"primary"},
+		},
+		{
+			name:       "redirect",
+			backends:   []failoverBackend{{"primary", http.StatusFound, ""}, {"secondary", http.StatusOK, "found"}},
+			wantStatus: http.StatusFound, wantFrom: "primary",
+			wantCalled: []string{"primary"},
+		},
+		{
+			name:       "client error",
+			backends:   []failoverBackend{{"primary", http.StatusForbidden, "forbidden"}, {"secondary", http.StatusOK, "found"}},
+			wantStatus: http.StatusForbidden, wantBody: "forbidden", wantFrom: "primary",
+			wantCalled: []string{"primary"},
+		},
+		{
+			name:       "last backend",
+			backends:   []failoverBackend{{"primary", http.StatusNotFound, "missing"}, {"secondary", http.StatusInternalServerError, "broken"}},
+			wantStatus: http.StatusInternalServerError, wantBody: "broken", wantFrom: "secondary",
+			wantCalled: []string{"primary", "secondary"},
+		},
+		{
+			name:       "custom status",
+			backends:   []failoverBackend{{"primary", http.StatusForbidden, "forbidden"}, {"secondary", http.StatusOK, "found"}},
+			statuses:   []int{http.StatusForbidden},
+			wantStatus: http.StatusOK, wantBody: "found", wantFrom: "secondary",
+			wantCalled: []string{"primary", "secondary"},
+		},
+		{
+			name:       "not a custom status",
+			backends:   []failoverBackend{{"primary", http.StatusNotFound, "missing"}, {"secondary", http.StatusOK, "found"}},
+			statuses:   []int{http.StatusForbidden},
+			wantStatus: http.StatusNotFound, wantBody: "missing", wantFrom: "primary",
+			wantCalled: []string{"primary"},
+		},
+		{
+			name:       "custom header",
+			backends:   []failoverBackend{{"primary", http.StatusNotFound, "missing"}, {"secondary", http.StatusOK, "found"}},
+			header:     "X-Served-By",
+			wantStatus: http.StatusOK, wantBody: "found", wantFrom: "secondary",
+			wantCalled: []string{"primary", "secondary"},
+		},
+		{
+			name:       "implicit success",
+			backends:   []failoverBackend{{"primary", 0, ""}, {"secondary", http.StatusOK, "found"}},
+			wantStatus: http.StatusOK, wantFrom: "primary",
+			wantCalled: []string{"primary"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called []string
+			var hs []proxy.NamedHandler
+			for _, b := range tt.backends {
+				hs = append(hs, proxy.NamedHandler{Name: b.name, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					called = append(called, b.name)
+					w.Header().Set("X-From", b.name)
+					if b.status == 0 {
+						return
+					}
+					w.WriteHeader(b.status)
+					w.Write([]byte(b.body))
+				})})
+			}
+
+			w := httptest.NewRecorder()
+			proxy.NewFailoverHandler(hs, tt.statuses, tt.header).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if body := w.Body.String(); body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+
+			header := tt.header
+			if header == "" {
+				header = proxy.DefaultBackendHeader
+			}
+			if v := w.Header().Get(header); v != tt.wantFrom {
+				t.Errorf("%s = %q, want %q", header, v, tt.wantFrom)
+			}
+			// Headers of the responses that were failed over are discarded
+			if v := w.Header().Values("X-From"); !reflect.DeepEqual(v, []string{tt.wantFrom}) {
+				t.Errorf("X-From = %q, want %q", v, tt.wantFrom)
+			}
+			if !reflect.DeepEqual(called, tt.wantCalled) {
+				t.Errorf("backends called = %q, want %q", called, tt.wantCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/rs/zerolog"
+)
+
+type backend struct {
+	Client  *storage.Client
+	Bucket  string
+	KeyFile string
+}
+
+func NewBackend(bucket, keyFile string) (proxy.Backend, error) {
+	c, err := newClient(context.Background(), keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b := backend{
+		Client:  c,
+		Bucket:  bucket,
+		KeyFile: keyFile,
+	}
+	return &b, nil
+}
+
+func newClient(ctx context.Context, keyFile string) (*storage.Client, error) {
+	if keyFile == "" {
+		return storage.NewClient(ctx)
+	}
+	return storage.NewClient(ctx, option.WithCredentialsFile(keyFile))
+}
+
+func (b *backend) UpdateLogContext(c zerolog.Context, key string) zerolog.Context {
+	return c.
+		Str("gcs_bucket", b.Bucket).
+		Str("gcs_key", key)
+}
+
+func (b *backend) Stat(ctx context.Context, key string) (*proxy.ObjectInfo, error) {
+	attrs, err := b.Client.Bucket(b.Bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return objectInfo(attrs), nil
+}
+
+func (b *backend) Get(ctx context.Context, key string, rng *proxy.ByteRange) (*proxy.Object, error) {
+	obj := b.Client.Bucket(b.Bucket).Object(key)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	var offset, length int64 = 0, -1
+	if rng != nil {
+		offset = rng.Offset
+		if rng.Offset >= 0 {
+			length = rng.Length
+		}
+	}
+	// Read the generation whose attributes were just fetched, in case the
+	// object is overwritten in the meantime
+	body, err := obj.Generation(attrs.Generation).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	o := &proxy.Object{
+		ObjectInfo: *objectInfo(attrs),
+		Body:       body,
+		Length:     body.Remain(),
+	}
+	if rng != nil {
+		start := body.Attrs.StartOffset
+		o.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, start+o.Length-1, body.Attrs.Size)
+	}
+	return o, nil
+}
+
+func (b *backend) List(ctx context.Context, prefix string, opts proxy.ListOptions) (*proxy.ListResult, error) {
+	q := storage.Query{
+		Delimiter: "/",
+		Prefix:    prefix,
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = proxy.DefaultPageSize
+	}
+
+	// Page through the iterator, rather than draining it, so that only one
+	// page is ever held in memory.
+	var objs []*storage.ObjectAttrs
+	it := b.Client.Bucket(b.Bucket).Objects(ctx, &q)
+	next, err := iterator.NewPager(it, limit, opts.Token).NextPage(&objs)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	res := &proxy.ListResult{
+		IsTruncated: next != "",
+		NextToken:   next,
+	}
+	for _, obj := range objs {
+		// Common prefixes are returned as attributes with only Prefix set
+		if obj.Prefix != "" {
+			res.Prefixes = append(res.Prefixes, obj.Prefix)
+			continue
+		}
+		res.Objects = append(res.Objects, proxy.ObjectInfo{
+			Key:         obj.Name,
+			Size:        obj.Size,
+			ModTime:     obj.Updated,
+			ETag:        quoteETag(obj.Etag),
+			ContentType: obj.ContentType,
+		})
+	}
+	return res, nil
+}
+
+func (b *backend) Put(ctx context.Context, key string, body io.Reader, opts proxy.PutOptions) (*proxy.ObjectInfo, error) {
+	// Canceling the context is the only way to abort an upload in progress
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	obj := b.Client.Bucket(b.Bucket).Object(key)
+	if opts.IfNoneMatch {
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	}
+	w := obj.NewWriter(ctx)
+	w.CacheControl = opts.CacheControl
+	w.ContentDisposition = opts.ContentDisposition
+	w.ContentEncoding = opts.ContentEncoding
+	w.ContentLanguage = opts.ContentLanguage
+	w.ContentType = opts.ContentType
+	w.Metadata = opts.Metadata
+	if _, err := io.Copy(w, body); err != nil {
+		cancel()
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, wrapError(err)
+	}
+	return objectInfo(w.Attrs()), nil
+}
+
+func (b *backend) Delete(ctx context.Context, key string) error {
+	if err := b.Client.Bucket(b.Bucket).Object(key).Delete(ctx); err != nil {
+		return wrapError(err)
+	}
+	return nil
+}
+
+// Copy copies objects within the bucket by rewriting them, which may take
+// several requests for large objects, all of which are made before it
+// returns.
+func (b *backend) Copy(ctx context.Context, src, dst string, opts proxy.CopyOptions) (*proxy.ObjectInfo, error) {
+	bkt := b.Client.Bucket(b.Bucket)
+	obj := bkt.Object(dst)
+	if opts.IfNoneMatch {
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	}
+	attrs, err := obj.CopierFrom(bkt.Object(src)).Run(ctx)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return objectInfo(attrs), nil
+}
+
+// SignPost signs a POST policy for objects under prefix, named after the
+// uploaded file. Signing requires the credentials of a service account, or
+// permission to sign blobs as one through the IAM API.
+func (b *backend) SignPost(ctx context.Context, prefix string, opts proxy.PostOptions) (*proxy.PostPolicy, error) {
+	po := &storage.PostPolicyV4Options{
+		Expires: time.Now().Add(opts.Expires),
+		Conditions: []storage.PostPolicyV4Condition{
+			storage.ConditionStartsWith("$Content-Type", ""),
+			storage.ConditionContentLengthRange(0, uint64(opts.MaxBytes)),
+		},
+	}
+	if opts.RedirectURL != "" {
+		po.Fields = &storage.PolicyV4Fields{RedirectToURLOnSuccess: opts.RedirectURL}
+	}
+	p, err := b.Client.Bucket(b.Bucket).GenerateSignedPostPolicyV4(prefix+"${filename}", po)
+	if err != nil {
+		return nil, err
+	}
+	return &proxy.PostPolicy{URL: p.URL, Fields: p.Fields, Expires: po.Expires}, nil
+}
+
+func objectInfo(attrs *storage.ObjectAttrs) *proxy.ObjectInfo {
+	return &proxy.ObjectInfo{
+		Key:                attrs.Name,
+		Size:               attrs.Size,
+		ModTime:            attrs.Updated,
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		ETag:               quoteETag(attrs.Etag),
+		Header:             metaHeaders(attrs),
+	}
+}
+
+// metaHeaders returns the GCS-specific headers of an object.
+func metaHeaders(attrs *storage.ObjectAttrs) http.Header {
+	hdr := http.Header{}
+	if attrs.Generation != 0 {
+		hdr.Add("X-Goog-Generation", strconv.FormatInt(attrs.Generation, 10))
+	}
+	if attrs.StorageClass != "" {
+		hdr.Add("X-Goog-Storage-Class", attrs.StorageClass)
+	}
+	for k, v := range attrs.Metadata {
+		hdr.Add("X-Goog-Meta-"+k, v)
+	}
+	return hdr
+}
+
+// quoteETag returns etag as an HTTP entity tag. GCS returns them unquoted,
+// unlike S3 and Azure.
+func quoteETag(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	return `"` + etag + `"`
+}
+
+// wrapError maps GCS errors to those of the proxy package.
+func wrapError(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return proxy.ErrNotExist
+	}
+
+	var apierr *googleapi.Error
+	if !errors.As(err, &apierr) {
+		return err
+	}
+
+	msg := apierr.Message
+	if msg == "" {
+		msg = http.StatusText(apierr.Code)
+	}
+	return &proxy.BackendError{
+		StatusCode: apierr.Code,
+		Message:    msg,
+		Err:        err,
+		Fields: map[string]interface{}{
+			"gcs_status_code": apierr.Code,
+			"gcs_reason":      errorReason(apierr),
+		},
+	}
+}
+
+// errorReason returns the reason of the first error detail in err, e.g.,
+// "forbidden", if any.
+func errorReason(err *googleapi.Error) string {
+	if len(err.Errors) > 0 {
+		return err.Errors[0].Reason
+	}
+	return ""
+}
@@ -0,0 +1,339 @@
+package gcs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+const testBucket = "ssp-test"
+
+// fakeGCS is a minimal stand-in for the parts of the GCS JSON and XML APIs
+// that the backend uses: object metadata, listings, downloads, uploads,
+// deletes and copies.
+type fakeGCS struct {
+	objects map[string]proxytest.Object
+}
+
+func newFakeGCS(objects []proxytest.Object) *fakeGCS {
+	f := &fakeGCS{objects: map[string]proxytest.Object{}}
+	for _, o := range objects {
+		f.objects[o.Key] = o
+	}
+	return f
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/storage/v1/b/"+testBucket+"/o"); ok {
+		if rest == "" {
+			f.list(w, r)
+			return
+		}
+		if src, dst, ok := strings.Cut(rest, "/rewriteTo/b/"+testBucket+"/o/"); ok && r.Method == http.MethodPost {
+			f.rewrite(w, r, strings.TrimPrefix(src, "/"), dst)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			f.delete(w, strings.TrimPrefix(rest, "/"))
+			return
+		}
+		f.stat(w, strings.TrimPrefix(rest, "/"))
+		return
+	}
+	if r.URL.Path == "/upload/storage/v1/b/"+testBucket+"/o" && r.Method == http.MethodPost {
+		f.upload(w, r)
+		return
+	}
+	if key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/"); ok {
+		f.download(w, r, key)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (f *fakeGCS) resource(o proxytest.Object) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":           "storage#object",
+		"bucket":         testBucket,
+		"name":           o.Key,
+		"size":           strconv.Itoa(len(o.Body)),
+		"contentType":    o.ContentType,
+		"cacheControl":   o.CacheControl,
+		"etag":           "CJ" + strconv.FormatInt(o.ModTime.Unix(), 36),
+		"generation":     strconv.FormatInt(o.ModTime.UnixMicro(), 10),
+		"metageneration": "1",
+		"storageClass":   "STANDARD",
+		// Objects are created well before they are last updated, so that
+		// the two cannot be confused
+		"timeCreated": o.ModTime.Add(-24 * time.Hour).Format(time.RFC3339Nano),
+		"updated":     o.ModTime.Format(time.RFC3339Nano),
+		"metadata":    o.Metadata,
+	}
+}
+
+func (f *fakeGCS) stat(w http.ResponseWriter, key string) {
+	o, ok := f.objects[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "No such object: "+testBucket+"/"+key)
+		return
+	}
+	writeJSON(w, f.resource(o))
+}
+
+func (f *fakeGCS) delete(w http.ResponseWriter, key string) {
+	if _, ok := f.objects[key]; !ok {
+		writeError(w, http.StatusNotFound, "notFound", "No such object: "+testBucket+"/"+key)
+		return
+	}
+	delete(f.objects, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rewrite copies an object within the bucket in a single call.
+func (f *fakeGCS) rewrite(w http.ResponseWriter, r *http.Request, src, dst string) {
+	o, ok := f.objects[src]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "No such object: "+testBucket+"/"+src)
+		return
+	}
+	if _, ok := f.objects[dst]; ok && r.URL.Query().Get("ifGenerationMatch") == "0" {
+		writeError(w, http.StatusPreconditionFailed, "conditionNotMet", "At least one of the pre-conditions you specified did not hold.")
+		return
+	}
+	o.Key, o.ModTime = dst, time.Now().UTC().Truncate(time.Microsecond)
+	f.objects[dst] = o
+	writeJSON(w, map[string]interface{}{
+		"kind":                "storage#rewriteResponse",
+		"totalBytesRewritten": strconv.Itoa(len(o.Body)),
+		"objectSize":          strconv.Itoa(len(o.Body)),
+		"done":                true,
+		"resource":            f.resource(o),
+	})
+}
+
+func (f *fakeGCS) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delim, token := q.Get("prefix"), q.Get("delimiter"), q.Get("pageToken")
+	max, _ := strconv.Atoi(q.Get("maxResults"))
+	if max <= 0 {
+		max = 1000
+	}
+
+	// Objects and common prefixes are merged in lexicographic order, and the
+	// page token is the last name returned
+	names := map[string]bool{}
+	for key := range f.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delim); delim != "" && i >= 0 {
+			names[prefix+rest[:i+len(delim)]] = true
+		} else {
+			names[key] = false
+		}
+	}
+	var sorted []string
+	for name := range names {
+		if name > token {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	items, prefixes := []interface{}{}, []string{}
+	resp := map[string]interface{}{"kind": "storage#objects"}
+	for i, name := range sorted {
+		if i == max {
+			resp["nextPageToken"] = sorted[i-1]
+			break
+		}
+		if names[name] {
+			prefixes = append(prefixes, name)
+		} else {
+			items = append(items, f.resource(f.objects[name]))
+		}
+	}
+	resp["items"] = items
+	resp["prefixes"] = prefixes
+	writeJSON(w, resp)
+}
+
+func (f *fakeGCS) download(w http.ResponseWriter, r *http.Request, key string) {
+	o, ok := f.objects[key]
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	for k, v := range o.Metadata {
+		w.Header().Set("X-Goog-Meta-"+k, v)
+	}
+	w.Header().Set("Content-Type", o.ContentType)
+	w.Header().Set("Cache-Control", o.CacheControl)
+	http.ServeContent(w, r, "", o.ModTime, strings.NewReader(o.Body))
+}
+
+// upload stores an object sent in a multipart upload, whose first part is its
+// resource and whose second part is its contents.
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || r.URL.Query().Get("uploadType") != "multipart" {
+		http.Error(w, "only multipart uploads are supported", http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	var res struct {
+		Name         string            `json:"name"`
+		ContentType  string            `json:"contentType"`
+		CacheControl string            `json:"cacheControl"`
+		Metadata     map[string]string `json:"metadata"`
+	}
+	p, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(p).Decode(&res)
+	}
+	var body []byte
+	if p, err = mr.NextPart(); err == nil {
+		body, err = io.ReadAll(p)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	o := proxytest.Object{
+		Key:          res.Name,
+		Body:         string(body),
+		ContentType:  res.ContentType,
+		CacheControl: res.CacheControl,
+		ModTime:      time.Now().UTC().Truncate(time.Microsecond),
+		Metadata:     res.Metadata,
+	}
+	if _, ok := f.objects[o.Key]; ok && r.URL.Query().Get("ifGenerationMatch") == "0" {
+		writeError(w, http.StatusPreconditionFailed, "conditionNotMet", "At least one of the pre-conditions you specified did not hold.")
+		return
+	}
+	f.objects[o.Key] = o
+	writeJSON(w, f.resource(o))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, reason, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": msg,
+			"errors":  []map[string]string{{"reason": reason, "message": msg}},
+		},
+	})
+}
+
+func newTestBackend(t *testing.T) proxy.Backend {
+	srv := httptest.NewServer(newFakeGCS(proxytest.Objects))
+	t.Cleanup(srv.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+
+	b, err := NewBackend(testBucket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBackendConformance(t *testing.T) {
+	proxytest.TestBackend(t, newTestBackend(t))
+}
+
+func TestHandlerConformance(t *testing.T) {
+	proxytest.TestHandler(t, newTestBackend(t), proxytest.Capabilities{})
+}
+
+func TestUploaderConformance(t *testing.T) {
+	proxytest.TestUploader(t, newTestBackend(t))
+}
+
+func TestDeleterConformance(t *testing.T) {
+	proxytest.TestDeleter(t, newTestBackend(t))
+}
+
+func TestCopierConformance(t *testing.T) {
+	proxytest.TestCopier(t, newTestBackend(t))
+}
+
+func TestSignPost(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "ssp@routed-cloud.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(keyFile, sa, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBackend(testBucket, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.(proxy.PostSigner).SignPost(context.Background(), "users/ripta/", proxy.PostOptions{
+		Expires:     15 * time.Minute,
+		MaxBytes:    1 << 20,
+		RedirectURL: "https://files.routed.cloud/users/ripta/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(p.URL, "/"+testBucket+"/") {
+		t.Errorf("URL = %q", p.URL)
+	}
+	if got := p.Fields["key"]; got != "users/ripta/${filename}" {
+		t.Errorf("key field = %q", got)
+	}
+	if got := p.Fields["success_action_redirect"]; got != "https://files.routed.cloud/users/ripta/" {
+		t.Errorf("success_action_redirect field = %q", got)
+	}
+	doc, err := base64.StdEncoding.DecodeString(p.Fields["policy"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(doc), `["content-length-range",0,1048576]`) {
+		t.Errorf("policy %s does not limit the size of uploads", doc)
+	}
+}
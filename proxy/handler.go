@@ -0,0 +1,380 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	pathpkg "path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ripta/ssp/proxy/bandwidth"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+type handler struct {
+	Backend   Backend
+	Rules     *ListingRules
+	Templates *templateLoader
+
+	Options
+}
+
+// NewHandler creates an HTTP handler that serves objects from b.
+func NewHandler(b Backend, opts Options) (http.Handler, error) {
+	lr, err := NewListingRules(opts)
+	if err != nil {
+		return nil, err
+	}
+	tl, err := newTemplateLoader(b, opts.AutoindexTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handler{
+		Backend:   b,
+		Rules:     lr,
+		Templates: tl,
+		Options:   opts,
+	}, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	log := hlog.FromRequest(r)
+	log.UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return h.Backend.UpdateLogContext(c, path)
+	})
+
+	// Private objects can be neither read nor changed, as if they did not
+	// exist, and nothing may be copied or moved onto them
+	if h.Options.Private(path) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if dst, ok := Destination(r); ok && h.Options.Private(strings.TrimPrefix(dst, "/")) {
+		http.Error(w, "The destination may not be changed.", http.StatusForbidden)
+		return
+	}
+
+	if h.Options.WebDAVEnabled() && h.serveWebDAV(w, r, path) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		h.serveUpload(w, r, path)
+		return
+	case http.MethodDelete:
+		h.serveDelete(w, r, path)
+		return
+	case http.MethodPost:
+		h.servePostPolicy(w, r, path)
+		return
+	}
+
+	if path == "" || strings.HasSuffix(path, "/") {
+		if format := r.URL.Query().Get("archive"); format != "" && h.Options.ArchiveEnabled() {
+			h.serveArchive(w, r, path, format)
+			return
+		}
+
+		var foundPath string
+		for _, candidate := range h.Options.IndexFiles {
+			if h.hasObject(r, path+candidate) {
+				foundPath = path + candidate
+				break
+			}
+		}
+
+		if foundPath == "" {
+			if h.Options.Autoindex != nil && *h.Options.Autoindex {
+				h.serveDirectoryListing(w, r, path)
+			} else {
+				http.Error(w, "Could not find a valid index file. Additionally, directory listing was denied.", http.StatusForbidden)
+			}
+			return
+		}
+		path = foundPath
+	}
+
+	h.serveFile(w, r, path)
+}
+
+func (h *handler) hasObject(r *http.Request, path string) bool {
+	_, err := h.Backend.Stat(r.Context(), path)
+	return err == nil
+}
+
+func (h *handler) serveDirectoryListing(w http.ResponseWriter, r *http.Request, path string) {
+	log := hlog.FromRequest(r)
+
+	q := r.URL.Query()
+	opts := ListOptions{
+		Token: q.Get("page"),
+		Limit: h.Options.PageSize(),
+	}
+	res, err := h.Backend.List(r.Context(), path, opts)
+	if err != nil {
+		var be *BackendError
+		if errors.As(err, &be) {
+			log.Error().Err(err).Fields(be.Fields).Msg("listing error")
+			http.Error(w, be.Message, be.StatusCode)
+			return
+		}
+		log.Error().Err(err).Msg("generic listing error")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var files []DirectoryEntry
+	for _, obj := range res.Objects {
+		name := strings.TrimPrefix(obj.Key, path)
+		// Skip the placeholder of the directory itself, and private objects,
+		// whose keys may differ from the paths that hide rules apply to
+		if name == "" || h.Options.Private(obj.Key) {
+			continue
+		}
+		modTime := obj.ModTime
+		ct := obj.ContentType
+		if ct == "" {
+			ct = mime.TypeByExtension(pathpkg.Ext(name))
+		}
+		files = append(files, DirectoryEntry{
+			Name:        name,
+			Size:        obj.Size,
+			ModTime:     &modTime,
+			ETag:        obj.ETag,
+			ContentType: ct,
+		})
+	}
+
+	var prefixes []string
+	for _, prefix := range res.Prefixes {
+		prefixes = append(prefixes, strings.TrimPrefix(prefix, path))
+	}
+
+	reqPath := requestPath(r)
+	listing := DirectoryListing{
+		Path:        reqPath,
+		Prefix:      path,
+		Host:        r.Host,
+		Vars:        mux.Vars(r),
+		Breadcrumbs: breadcrumbs(reqPath),
+		Entries:     files,
+		Prefixes:    prefixes,
+		IsTruncated: res.IsTruncated,
+		Token:       opts.Token,
+		NextToken:   res.NextToken,
+		PageSize:    opts.Limit,
+	}
+	if reqPath != "" {
+		listing.ParentURL = "../"
+	}
+	if h.Options.ArchiveEnabled() {
+		listing.ArchiveFormats = ArchiveFormats
+	}
+	h.Rules.Apply(&listing, q.Get("sort"), q.Get("order"))
+
+	// Headers and readmes are only shown in HTML, and only on the first page
+	if opts.Token == "" && NegotiateFormat(r) == FormatHTML {
+		if listing.Header, err = loadReadme(r.Context(), h.Backend, path, h.Options.AutoindexHeader); err != nil {
+			log.Error().Err(err).Msg("could not load directory header")
+		}
+		if listing.Readme, err = loadReadme(r.Context(), h.Backend, path, h.Options.AutoindexReadme); err != nil {
+			log.Error().Err(err).Msg("could not load directory readme")
+		}
+	}
+	listing.PrevURL, listing.NextURL = pageURLs(q, opts.Token, res.NextToken)
+
+	// Users who may upload here are offered a form to do so straight to the
+	// backend, which then sends them back to the listing
+	if h.Options.UploadFormEnabled() && !ReadOnly(r.Context()) && !h.Options.HasPrivate(path) && NegotiateFormat(r) == FormatHTML {
+		if listing.Upload, err = h.signPost(r, path, requestURL(r)); err != nil {
+			log.Error().Err(err).Msg("could not sign upload form")
+		}
+	}
+
+	t, err := h.Templates.Template(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("could not load autoindex template")
+	}
+	if err := RenderListing(w, r, t, listing); err != nil {
+		log.Error().Err(err).Msg("directory listing render error")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	log := hlog.FromRequest(r)
+
+	obj, err := h.Backend.Get(r.Context(), path, parseRange(r.Header.Get("Range")))
+	if err != nil {
+		var be *BackendError
+		switch {
+		case errors.Is(err, ErrNotExist):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case errors.As(err, &be):
+			log.Error().Err(err).Fields(be.Fields).Msg("")
+			http.Error(w, be.Message, be.StatusCode)
+		default:
+			log.Error().Err(err).Msg("generic download error")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+		return
+	}
+	defer obj.Body.Close()
+
+	// Immediately handle website redirects
+	if obj.RedirectLocation != "" {
+		w.Header().Add("Location", obj.RedirectLocation)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	if obj.IsDirectory && !strings.HasSuffix(r.RequestURI, "/") {
+		w.Header().Add("Location", r.RequestURI+"/")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	// Copy common headers from the backend to the response
+	copyStringHeader(w, "Cache-Control", obj.CacheControl)
+	copyStringHeader(w, "Content-Disposition", obj.ContentDisposition)
+	copyStringHeader(w, "Content-Encoding", obj.ContentEncoding)
+	copyStringHeader(w, "Content-Language", obj.ContentLanguage)
+	copyStringHeader(w, "Content-Type", obj.ContentType)
+	copyStringHeader(w, "ETag", obj.ETag)
+	copyStringHeader(w, "Expires", obj.Expires)
+
+	// Copy the Last-Modified header as long as it's not the zero value
+	if t := obj.ModTime; !t.IsZero() {
+		copyStringHeader(w, "Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+
+	// Copy backend-specific headers, e.g., user metadata
+	for k, vs := range obj.Header {
+		for _, v := range vs {
+			copyStringHeader(w, k, v)
+		}
+	}
+
+	// Return "204 No Content" only if the object is in fact empty
+	if obj.Length == 0 && obj.ContentRange == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	copyStringHeader(w, "Content-Length", strconv.FormatInt(obj.Length, 10))
+
+	// Prepare "206 Partial Content" if only part of the object was returned
+	if obj.ContentRange != "" {
+		copyStringHeader(w, "Content-Range", obj.ContentRange)
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	if n, err := io.Copy(bandwidth.Writer(r.Context(), w), obj.Body); err != nil {
+		log.Error().Err(err).Int64("bytes_written", n).Msg("")
+		return
+	}
+}
+
+// requestPath returns the path originally requested by the client, before any
+// prefix rewriting, without its leading slash.
+func requestPath(r *http.Request) string {
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		return strings.TrimPrefix(u.Path, "/")
+	}
+	return strings.TrimPrefix(r.URL.Path, "/")
+}
+
+// maxPageTrail is the number of previous page tokens remembered in listing
+// URLs. Paging back past the oldest one returns to the first page.
+const maxPageTrail = 32
+
+// pageQueryParams are carried over from one page of a listing to the next.
+var pageQueryParams = []string{"format", "order", "sort"}
+
+// pageURLs returns the relative URLs of the previous and next pages of a
+// listing. Continuation tokens only go forward, so the tokens of previous pages
+// are carried along in the "prev" query parameter.
+func pageURLs(q url.Values, token, next string) (prevURL, nextURL string) {
+	base := func() url.Values {
+		v := url.Values{}
+		for _, k := range pageQueryParams {
+			if s := q.Get(k); s != "" {
+				v.Set(k, s)
+			}
+		}
+		return v
+	}
+	trail := q["prev"]
+
+	if token != "" {
+		v := base()
+		if n := len(trail); n > 0 && trail[n-1] != "" {
+			v["page"] = []string{trail[n-1]}
+			v["prev"] = trail[:n-1]
+		}
+		prevURL = "?" + v.Encode()
+	}
+
+	if next != "" {
+		t := append(trail[:len(trail):len(trail)], token)
+		if len(t) > maxPageTrail {
+			t = t[len(t)-maxPageTrail:]
+		}
+		v := base()
+		v["page"] = []string{next}
+		v["prev"] = t
+		nextURL = "?" + v.Encode()
+	}
+	return prevURL, nextURL
+}
+
+// parseRange parses the value of a Range header. Only a single range of bytes
+// is supported; anything else returns nil, in which case the whole object is
+// served, as permitted by RFC 9110.
+func parseRange(s string) *ByteRange {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return nil
+		}
+		return &ByteRange{Offset: -n}
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil
+	}
+	if last == "" {
+		return &ByteRange{Offset: start, Length: -1}
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil
+	}
+	return &ByteRange{Offset: start, Length: end - start + 1}
+}
+
+func copyStringHeader(w http.ResponseWriter, k, v string) {
+	if v != "" {
+		w.Header().Add(k, v)
+	}
+}
@@ -0,0 +1,767 @@
+package proxy_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+func newTestHandler(t *testing.T, b proxy.Backend) http.Handler {
+	t.Helper()
+
+	autoindex := true
+	h, err := proxy.NewHandler(b, proxy.Options{Autoindex: &autoindex})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"generic object error", errors.New("connection reset"), "/hello.txt", http.StatusServiceUnavailable, ""},
+		{"generic listing error", errors.New("connection reset"), "/dir/", http.StatusInternalServerError, ""},
+		{"not found", proxy.ErrNotExist, "/hello.txt", http.StatusNotFound, ""},
+		{
+			name:       "backend error",
+			err:        &proxy.BackendError{StatusCode: http.StatusForbidden, Message: "Access Denied", Err: errors.New("denied")},
+			path:       "/hello.txt",
+			wantStatus: http.StatusForbidden,
+			wantBody:   "Access Denied",
+		},
+		{
+			name:       "backend listing error",
+			err:        &proxy.BackendError{StatusCode: http.StatusForbidden, Message: "Access Denied", Err: errors.New("denied")},
+			path:       "/dir/",
+			wantStatus: http.StatusForbidden,
+			wantBody:   "Access Denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := proxytest.NewMemoryBackend(proxytest.Objects)
+			b.Err = tt.err
+
+			w := httptest.NewRecorder()
+			newTestHandler(t, b).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandlerRanges(t *testing.T) {
+	tests := []struct {
+		rng        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"bytes=0-0", http.StatusPartialContent, "h"},
+		{"bytes=7-", http.StatusPartialContent, "world\n"},
+		{"bytes=7-100", http.StatusPartialContent, "world\n"},
+		{"bytes=-100", http.StatusPartialContent, "hello, world\n"},
+		{"bytes=5-2", http.StatusOK, "hello, world\n"},
+		{"bytes=-0", http.StatusOK, "hello, world\n"},
+		{"bytes=a-b", http.StatusOK, "hello, world\n"},
+		{"items=0-1", http.StatusOK, "hello, world\n"},
+		{"bytes=100-", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	h := newTestHandler(t, proxytest.NewMemoryBackend(proxytest.Objects))
+	for _, tt := range tests {
+		t.Run(tt.rng, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/hello.txt", nil)
+			r.Header.Set("Range", tt.rng)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandlerPageURLs(t *testing.T) {
+	size := 1
+	autoindex := true
+	h, err := proxy.NewHandler(proxytest.NewMemoryBackend(proxytest.Objects), proxy.Options{
+		Autoindex:         &autoindex,
+		AutoindexPageSize: &size,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second page links back to the first, and forward to the third,
+	// keeping the requested sort order
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dir/?sort=size&page=dir%2Fa.txt&prev=", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`href="?sort=size" rel="prev"`,
+		`href="?page=dir%2Fb.json&amp;prev=&amp;prev=dir%2Fa.txt&amp;sort=size" rel="next"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing does not contain %s", want)
+		}
+	}
+}
+
+func TestHandlerUpload(t *testing.T) {
+	yes, max := true, int64(16)
+	tests := []struct {
+		name       string
+		opts       proxy.Options
+		path       string
+		body       io.Reader
+		length     int64
+		wantStatus int
+	}{
+		{"disabled", proxy.Options{}, "/new.txt", strings.NewReader("hi"), 2, http.StatusMethodNotAllowed},
+		{"upload", proxy.Options{AllowUpload: &yes}, "/new.txt", strings.NewReader("hi"), 2, http.StatusCreated},
+		{"directory", proxy.Options{AllowUpload: &yes}, "/dir/", strings.NewReader("hi"), 2, http.StatusBadRequest},
+		{"too large", proxy.Options{AllowUpload: &yes, UploadMaxBytes: &max}, "/new.txt", strings.NewReader(strings.Repeat("a", 17)), 17, http.StatusRequestEntityTooLarge},
+		{"too large without length", proxy.Options{AllowUpload: &yes, UploadMaxBytes: &max}, "/new.txt", iotest.OneByteReader(strings.NewReader(strings.Repeat("a", 17))), -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := proxytest.NewMemoryBackend(proxytest.Objects)
+			h, err := proxy.NewHandler(b, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPut, tt.path, tt.body)
+			r.ContentLength = tt.length
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if _, err := b.Stat(context.Background(), strings.TrimPrefix(tt.path, "/")); (err == nil) != (tt.wantStatus == http.StatusCreated) {
+				t.Errorf("Stat() after upload error = %v", err)
+			}
+		})
+	}
+}
+
+func TestHandlerUploadOverwrite(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name        string
+		opts        proxy.Options
+		path        string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		{"overwrite", proxy.Options{AllowUpload: &yes}, "/hello.txt", "", http.StatusCreated, "new"},
+		{"if-none-match", proxy.Options{AllowUpload: &yes}, "/hello.txt", "*", http.StatusPreconditionFailed, "hello, world\n"},
+		{"if-none-match new", proxy.Options{AllowUpload: &yes}, "/new.txt", "*", http.StatusCreated, "new"},
+		{"forbidden", proxy.Options{AllowUpload: &yes, AllowOverwrite: &no}, "/hello.txt", "", http.StatusPreconditionFailed, "hello, world\n"},
+		{"forbidden new", proxy.Options{AllowUpload: &yes, AllowOverwrite: &no}, "/new.txt", "", http.StatusCreated, "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := proxytest.NewMemoryBackend(proxytest.Objects)
+			h, err := proxy.NewHandler(b, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader("new"))
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			obj, err := b.Get(context.Background(), strings.TrimPrefix(tt.path, "/"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer obj.Body.Close()
+			if got, _ := io.ReadAll(obj.Body); string(got) != tt.wantBody {
+				t.Errorf("body after upload = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandlerDelete(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name       string
+		opts       proxy.Options
+		path       string
+		wantStatus int
+	}{
+		{"disabled", proxy.Options{}, "/hello.txt", http.StatusMethodNotAllowed},
+		{"delete", proxy.Options{AllowDelete: &yes}, "/hello.txt", http.StatusNoContent},
+		{"missing", proxy.Options{AllowDelete: &yes}, "/missing.txt", http.StatusNotFound},
+		{"directory", proxy.Options{AllowDelete: &yes}, "/dir/", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := proxytest.NewMemoryBackend(proxytest.Objects)
+			h, err := proxy.NewHandler(b, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if _, err := b.Stat(context.Background(), "hello.txt"); (err == nil) == (tt.wantStatus == http.StatusNoContent) {
+				t.Errorf("Stat() after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestHandlerUploadAttributes(t *testing.T) {
+	yes := true
+	b := proxytest.NewMemoryBackend(proxytest.Objects)
+	h, err := proxy.NewHandler(b, proxy.Options{AllowUpload: &yes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/builds/42/app.json", strings.NewReader(`{}`))
+	r.Header.Set("Cache-Control", "max-age=300")
+	r.Header.Set("X-Ssp-Meta-Commit", "abc123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") == "" {
+		t.Fatalf("status = %d, ETag = %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	info, err := b.Stat(context.Background(), "builds/42/app.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "application/json" || info.CacheControl != "max-age=300" || info.Header.Get("X-Meta-Commit") != "abc123" {
+		t.Errorf("uploaded object = %+v", info)
+	}
+}
+
+// signingBackend signs POST policies for a fictional bucket.
+type signingBackend struct {
+	*proxytest.MemoryBackend
+}
+
+func (signingBackend) SignPost(ctx context.Context, prefix string, opts proxy.PostOptions) (*proxy.PostPolicy, error) {
+	fields := map[string]string{"key": prefix + "${filename}", "policy": "signed"}
+	if opts.RedirectURL != "" {
+		fields["success_action_redirect"] = opts.RedirectURL
+	}
+	return &proxy.PostPolicy{URL: "https://uploads.example.com/", Fields: fields}, nil
+}
+
+func TestHandlerPostPolicy(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name       string
+		opts       proxy.Options
+		path       string
+		wantStatus int
+		wantKey    string
+	}{
+		{"disabled", proxy.Options{AllowUpload: &yes}, "/dir/", http.StatusMethodNotAllowed, ""},
+		{"directory", proxy.Options{AllowUpload: &yes, UploadPostPolicy: &yes}, "/dir/", http.StatusOK, "dir/${filename}"},
+		{"root", proxy.Options{AllowUpload: &yes, UploadPostPolicy: &yes}, "/", http.StatusOK, "${filename}"},
+		{"object", proxy.Options{AllowUpload: &yes, UploadPostPolicy: &yes}, "/hello.txt", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := proxy.NewHandler(signingBackend{proxytest.NewMemoryBackend(proxytest.Objects)}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantKey == "" {
+				return
+			}
+			var p proxy.PostPolicy
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.URL != "https://uploads.example.com/" || p.Fields["key"] != tt.wantKey {
+				t.Errorf("policy = %+v, want key %q", p, tt.wantKey)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", cc)
+			}
+		})
+	}
+}
+
+func TestHandlerUploadForm(t *testing.T) {
+	yes := true
+	h, err := proxy.NewHandler(signingBackend{proxytest.NewMemoryBackend(proxytest.Objects)}, proxy.Options{
+		Autoindex:           &yes,
+		AutoindexUploadForm: &yes,
+		AllowUpload:         &yes,
+		UploadPostPolicy:    &yes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://files.example.com/dir/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	body := w.Body.String()
+	for _, want := range []string{
+		`<form class="upload" method="post" action="https://uploads.example.com/"`,
+		`<input type="hidden" name="key" value="dir/${filename}">`,
+		`<input type="hidden" name="success_action_redirect" value="https://files.example.com/dir/">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing does not contain %s:\n%s", want, body)
+		}
+	}
+
+	// Users who may not upload here are not offered the form
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r.WithContext(proxy.WithReadOnly(r.Context())))
+	if strings.Contains(w.Body.String(), `class="upload"`) {
+		t.Errorf("read-only listing contains an upload form:\n%s", w.Body.String())
+	}
+}
+
+// newWebDAVHandler returns a WebDAV handler for b, with the given permissions.
+func newWebDAVHandler(t *testing.T, b proxy.Backend, upload, del bool) http.Handler {
+	t.Helper()
+
+	yes := true
+	h, err := proxy.NewHandler(b, proxy.Options{WebDAV: &yes, AllowUpload: &upload, AllowDelete: &del})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHandlerWebDAVOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		upload    bool
+		del       bool
+		wantDAV   string
+		wantAllow string
+	}{
+		{"read-only", false, false, "1", "GET, HEAD, OPTIONS, PROPFIND, PROPPATCH"},
+		{"upload", true, false, "1, 2", "GET, HEAD, OPTIONS, PROPFIND, PROPPATCH, PUT, MKCOL, LOCK, UNLOCK, COPY"},
+		{"upload and delete", true, true, "1, 2", "GET, HEAD, OPTIONS, PROPFIND, PROPPATCH, PUT, MKCOL, LOCK, UNLOCK, DELETE, COPY, MOVE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newWebDAVHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), tt.upload, tt.del)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/", nil))
+			if got := w.Header().Get("DAV"); got != tt.wantDAV {
+				t.Errorf("DAV = %q, want %q", got, tt.wantDAV)
+			}
+			if got := w.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestHandlerPropfind(t *testing.T) {
+	const propGetETag = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getetag/><x:color xmlns:x="urn:x"/></D:prop></D:propfind>`
+	tests := []struct {
+		name       string
+		path       string
+		depth      string
+		body       string
+		wantStatus int
+		want       []string
+		wantNot    []string
+	}{
+		{
+			name: "collection", path: "/dir/", depth: "1", wantStatus: http.StatusMultiStatus,
+			want: []string{
+				"<D:href>/dir/</D:href>",
+				"<D:resourcetype><D:collection/></D:resourcetype>",
+				"<D:href>/dir/a.txt</D:href>",
+				"<D:getcontentlength>1</D:getcontentlength>",
+				"<D:href>/dir/sub/</D:href>",
+			},
+			wantNot: []string{"<D:href>/dir/sub/c.txt</D:href>"},
+		},
+		{
+			name: "collection without slash", path: "/dir", depth: "0", wantStatus: http.StatusMultiStatus,
+			want:    []string{"<D:href>/dir/</D:href>", "<D:displayname>dir</D:displayname>"},
+			wantNot: []string{"<D:href>/dir/a.txt</D:href>"},
+		},
+		{
+			name: "object", path: "/hello.txt", depth: "1", wantStatus: http.StatusMultiStatus,
+			want: []string{"<D:href>/hello.txt</D:href>", "<D:getcontenttype>text/plain"},
+		},
+		{
+			name: "named properties", path: "/hello.txt", depth: "0", body: propGetETag, wantStatus: http.StatusMultiStatus,
+			want:    []string{"<D:getetag>&#34;", `<color xmlns="urn:x"></color>`, "HTTP/1.1 404 Not Found"},
+			wantNot: []string{"<D:getcontentlength>"},
+		},
+		{name: "infinite depth", path: "/", depth: "infinity", wantStatus: http.StatusForbidden, want: []string{"<D:propfind-finite-depth/>"}},
+		{name: "missing", path: "/missing/", depth: "1", wantStatus: http.StatusNotFound},
+		{name: "invalid", path: "/", depth: "1", body: "<D:propfind", wantStatus: http.StatusBadRequest},
+	}
+	h := newWebDAVHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), false, false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(proxy.MethodPropfind, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Depth", tt.depth)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			body := w.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("response does not contain %s:\n%s", want, body)
+				}
+			}
+			for _, want := range tt.wantNot {
+				if strings.Contains(body, want) {
+					t.Errorf("response contains %s:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerMkcol(t *testing.T) {
+	b := proxytest.NewMemoryBackend(proxytest.Objects)
+	h := newWebDAVHandler(t, b, true, false)
+
+	for _, want := range []int{http.StatusCreated, http.StatusMethodNotAllowed} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(proxy.MethodMkcol, "/new", nil))
+		if w.Code != want {
+			t.Errorf("status = %d, want %d", w.Code, want)
+		}
+	}
+	if _, err := b.Stat(context.Background(), "new/"); err != nil {
+		t.Errorf("Stat() of placeholder error = %v", err)
+	}
+
+	// Empty collections are listed, but their placeholders are not
+	r := httptest.NewRequest(proxy.MethodPropfind, "/new/", nil)
+	r.Header.Set("Depth", "1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if n := strings.Count(w.Body.String(), "<D:response>"); w.Code != http.StatusMultiStatus || n != 1 {
+		t.Errorf("status = %d with %d responses, want %d with 1:\n%s", w.Code, n, http.StatusMultiStatus, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	newWebDAVHandler(t, b, false, false).ServeHTTP(w, httptest.NewRequest(proxy.MethodMkcol, "/other", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status without uploads = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandlerCopy(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		destination string
+		overwrite   string
+		del         bool
+		wantStatus  int
+		wantExist   []string
+		wantMissing []string
+	}{
+		{
+			name: "object", method: proxy.MethodCopy, path: "/hello.txt", destination: "http://example.com/copy.txt",
+			wantStatus: http.StatusCreated, wantExist: []string{"hello.txt", "copy.txt"},
+		},
+		{
+			name: "unclean destination", method: proxy.MethodCopy, path: "/hello.txt", destination: "/dir/../copy.txt",
+			wantStatus: http.StatusCreated, wantExist: []string{"copy.txt"},
+		},
+		{
+			name: "move object", method: proxy.MethodMove, path: "/hello.txt", destination: "/dir/hello.txt", del: true,
+			wantStatus: http.StatusCreated, wantExist: []string{"dir/hello.txt"}, wantMissing: []string{"hello.txt"},
+		},
+		{
+			name: "replace object", method: proxy.MethodCopy, path: "/hello.txt", destination: "/empty.txt",
+			wantStatus: http.StatusNoContent, wantExist: []string{"hello.txt", "empty.txt"},
+		},
+		{
+			name: "collection", method: proxy.MethodCopy, path: "/dir", destination: "/copy",
+			wantStatus: http.StatusCreated, wantExist: []string{"dir/a.txt", "copy/a.txt", "copy/sub/c.txt"},
+		},
+		{
+			name: "move collection", method: proxy.MethodMove, path: "/dir/", destination: "/moved/", del: true,
+			wantStatus: http.StatusCreated, wantExist: []string{"moved/b.json", "moved/sub/c.txt"}, wantMissing: []string{"dir/a.txt", "dir/sub/c.txt"},
+		},
+		{
+			name: "no overwrite", method: proxy.MethodCopy, path: "/hello.txt", destination: "/empty.txt", overwrite: "F",
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name: "into itself", method: proxy.MethodCopy, path: "/dir/", destination: "/dir/sub/dir/",
+			wantStatus: http.StatusForbidden, wantMissing: []string{"dir/sub/dir/a.txt"},
+		},
+		{name: "no destination", method: proxy.MethodCopy, path: "/hello.txt", wantStatus: http.StatusBadRequest},
+		{name: "missing", method: proxy.MethodCopy, path: "/missing.txt", destination: "/copy.txt", wantStatus: http.StatusNotFound},
+		{
+			name: "move without deletes", method: proxy.MethodMove, path: "/hello.txt", destination: "/copy.txt",
+			wantStatus: http.StatusMethodNotAllowed, wantExist: []string{"hello.txt"}, wantMissing: []string{"copy.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := proxytest.NewMemoryBackend(proxytest.Objects)
+			h := newWebDAVHandler(t, b, true, tt.del)
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.destination != "" {
+				r.Header.Set("Destination", tt.destination)
+			}
+			if tt.overwrite != "" {
+				r.Header.Set("Overwrite", tt.overwrite)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			for _, key := range tt.wantExist {
+				if _, err := b.Stat(context.Background(), key); err != nil {
+					t.Errorf("Stat(%q) error = %v", key, err)
+				}
+			}
+			for _, key := range tt.wantMissing {
+				if _, err := b.Stat(context.Background(), key); !proxy.IsNotExist(err) {
+					t.Errorf("Stat(%q) error = %v, want one that does not exist", key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerDeleteCollection(t *testing.T) {
+	b := proxytest.NewMemoryBackend(proxytest.Objects)
+	h := newWebDAVHandler(t, b, true, true)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/dir", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	for _, key := range []string{"dir/a.txt", "dir/sub/c.txt"} {
+		if _, err := b.Stat(context.Background(), key); !proxy.IsNotExist(err) {
+			t.Errorf("Stat(%q) error = %v, want one that does not exist", key, err)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status of root = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandlerPrivateKeys(t *testing.T) {
+	yes := true
+	opts := proxy.Options{
+		Autoindex:        &yes,
+		AutoindexArchive: &yes,
+		AllowUpload:      &yes,
+		AllowDelete:      &yes,
+		UploadPostPolicy: &yes,
+		WebDAV:           &yes,
+		PrivateKeys:      []string{"dir/a.txt"},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		destination string
+		wantStatus  int
+		wantExist   []string
+		wantMissing []string
+	}{
+		{name: "get", method: http.MethodGet, path: "/dir/a.txt", wantStatus: http.StatusNotFound},
+		{name: "head", method: http.MethodHead, path: "/dir/a.txt", wantStatus: http.StatusNotFound},
+		{name: "put", method: http.MethodPut, path: "/dir/a.txt", wantStatus: http.StatusNotFound},
+		{name: "delete", method: http.MethodDelete, path: "/dir/a.txt", wantStatus: http.StatusNotFound, wantExist: []string{"dir/a.txt"}},
+		{name: "copy", method: proxy.MethodCopy, path: "/dir/a.txt", destination: "/copy.txt", wantStatus: http.StatusNotFound, wantMissing: []string{"copy.txt"}},
+		{name: "copy onto", method: proxy.MethodCopy, path: "/hello.txt", destination: "/dir/a.txt", wantStatus: http.StatusForbidden},
+		{
+			name: "move collection", method: proxy.MethodMove, path: "/dir/", destination: "/moved/",
+			wantStatus: http.StatusCreated, wantExist: []string{"dir/a.txt", "moved/b.json"}, wantMissing: []string{"moved/a.txt", "dir/b.json"},
+		},
+		{name: "post policy", method: http.MethodPost, path: "/dir/", wantStatus: http.StatusForbidden},
+		{name: "post policy elsewhere", method: http.MethodPost, path: "/dir/sub/", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := proxytest.NewMemoryBackend(proxytest.Objects)
+			h, err := proxy.NewHandler(signingBackend{b}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader("replaced"))
+			if tt.destination != "" {
+				r.Header.Set("Destination", tt.destination)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			for _, key := range tt.wantExist {
+				if _, err := b.Stat(context.Background(), key); err != nil {
+					t.Errorf("Stat(%q) error = %v", key, err)
+				}
+			}
+			for _, key := range tt.wantMissing {
+				if _, err := b.Stat(context.Background(), key); !proxy.IsNotExist(err) {
+					t.Errorf("Stat(%q) error = %v, want one that does not exist", key, err)
+				}
+			}
+		})
+	}
+
+	// Private objects are left out of listings, archives and WebDAV
+	// collections, including on handlers whose backend keys are under a
+	// prefix that requests are rewritten into
+	var prefixed []proxytest.Object
+	for _, o := range proxytest.Objects {
+		o.Key = "site/" + o.Key
+		prefixed = append(prefixed, o)
+	}
+	listings := []struct {
+		name    string
+		objects []proxytest.Object
+		private string
+		prefix  string
+	}{
+		{"unprefixed", proxytest.Objects, "dir/a.txt", ""},
+		{"prefixed", prefixed, "site/dir/a.txt", "/site"},
+	}
+	for _, tt := range listings {
+		t.Run(tt.name, func(t *testing.T) {
+			o := opts
+			o.PrivateKeys = []string{tt.private}
+			h, err := proxy.NewHandler(signingBackend{proxytest.NewMemoryBackend(tt.objects)}, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/dir/", nil),
+				httptest.NewRequest(http.MethodGet, "/dir/?format=json", nil),
+				httptest.NewRequest(http.MethodGet, "/dir/?archive=zip", nil),
+				httptest.NewRequest(proxy.MethodPropfind, "/dir/", nil),
+			} {
+				if r.Method == proxy.MethodPropfind {
+					r.Header.Set("Depth", "1")
+				}
+				r.URL.Path = tt.prefix + r.URL.Path
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if body := w.Body.String(); !strings.Contains(body, "b.json") || strings.Contains(body, "a.txt") {
+					t.Errorf("%s %s lists a private object, or nothing at all:\n%s", r.Method, r.RequestURI, body)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerLock(t *testing.T) {
+	const lockinfo = `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner><D:href>ripta</D:href></D:owner></D:lockinfo>`
+	h := newWebDAVHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), true, false)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(proxy.MethodLock, "/hello.txt", strings.NewReader(lockinfo)))
+	token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	if w.Code != http.StatusOK || !strings.HasPrefix(token, "opaquelocktoken:") {
+		t.Fatalf("status = %d with token %q, want %d with a lock token", w.Code, token, http.StatusOK)
+	}
+	for _, want := range []string{"<D:href>" + token + "</D:href>", "<D:owner><D:href>ripta</D:href></D:owner>", "<D:href>/hello.txt</D:href>"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("response does not contain %s:\n%s", want, w.Body.String())
+		}
+	}
+
+	// Locks are refreshed without a body
+	r := httptest.NewRequest(proxy.MethodLock, "/hello.txt", nil)
+	r.Header.Set("If", "(<"+token+">)")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), token) {
+		t.Errorf("refresh status = %d, want %d with the same token:\n%s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	r = httptest.NewRequest(proxy.MethodUnlock, "/hello.txt", nil)
+	r.Header.Set("Lock-Token", "<"+token+">")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("unlock status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	w = httptest.NewRecorder()
+	newWebDAVHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), false, false).ServeHTTP(w, httptest.NewRequest(proxy.MethodLock, "/hello.txt", strings.NewReader(lockinfo)))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status without uploads = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandlerProppatch(t *testing.T) {
+	const update = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:"><D:set><D:prop><Z:Win32LastModifiedTime>Wed, 01 Jan 2025 00:00:00 GMT</Z:Win32LastModifiedTime></D:prop></D:set></D:propertyupdate>`
+	h := newWebDAVHandler(t, proxytest.NewMemoryBackend(proxytest.Objects), true, false)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(proxy.MethodProppatch, "/hello.txt", strings.NewReader(update)))
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusMultiStatus)
+	}
+	for _, want := range []string{`<Win32LastModifiedTime xmlns="urn:schemas-microsoft-com:"></Win32LastModifiedTime>`, "HTTP/1.1 403 Forbidden"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("response does not contain %s:\n%s", want, w.Body.String())
+		}
+	}
+}
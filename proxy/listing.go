@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats in which directory listings can be rendered.
+const (
+	FormatHTML = "html"
+	FormatJSON = "json"
+	FormatXML  = "xml"
+)
+
+// s3XMLNamespace is the namespace of S3's ListBucketResult documents.
+const s3XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// RenderListing renders a directory listing in the format requested by the
+// client, either explicitly via the "format" query parameter, or otherwise by
+// content negotiation on the Accept header. HTML is the default, rendered using
+// the template t.
+func RenderListing(w http.ResponseWriter, r *http.Request, t *template.Template, listing DirectoryListing) error {
+	w.Header().Add("Vary", "Accept")
+	switch NegotiateFormat(r) {
+	case FormatJSON:
+		return renderJSONListing(w, listing)
+	case FormatXML:
+		return renderXMLListing(w, listing)
+	}
+	return renderHTMLListing(w, t, listing)
+}
+
+// NegotiateFormat returns the listing format preferred by the client.
+func NegotiateFormat(r *http.Request) string {
+	switch f := strings.ToLower(r.URL.Query().Get("format")); f {
+	case FormatHTML, FormatJSON, FormatXML:
+		return f
+	}
+
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		var f string
+		switch strings.ToLower(strings.TrimSpace(mt)) {
+		case "text/html", "application/xhtml+xml":
+			f = FormatHTML
+		case "application/json":
+			f = FormatJSON
+		case "application/xml", "text/xml":
+			f = FormatXML
+		}
+		if f != "" && q > 0 {
+			candidates = append(candidates, candidate{f, q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return FormatHTML
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].format
+}
+
+type jsonListing struct {
+	Path        string      `json:"path"`
+	Entries     []jsonEntry `json:"entries"`
+	IsTruncated bool        `json:"is_truncated"`
+	NextToken   string      `json:"next_token,omitempty"`
+}
+
+type jsonEntry struct {
+	Name        string     `json:"name"`
+	Size        int64      `json:"size"`
+	ModTime     *time.Time `json:"modified,omitempty"`
+	ETag        string     `json:"etag,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	IsDirectory bool       `json:"is_directory"`
+}
+
+func renderJSONListing(w http.ResponseWriter, listing DirectoryListing) error {
+	out := jsonListing{
+		Path:        listing.Path,
+		Entries:     []jsonEntry{},
+		IsTruncated: listing.IsTruncated,
+		NextToken:   listing.NextToken,
+	}
+	for _, e := range listing.All {
+		out.Entries = append(out.Entries, jsonEntry{
+			Name:        e.Name,
+			Size:        e.Size,
+			ModTime:     e.ModTime,
+			ETag:        strings.Trim(e.ETag, `"`),
+			ContentType: e.ContentType,
+			IsDirectory: e.IsDirectory,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// xmlListing mirrors the shape of S3's ListObjectsV2 response, so that
+// existing S3 tooling can parse it. Keys are relative to the root of the
+// handler, rather than to the bucket.
+type xmlListing struct {
+	XMLName               xml.Name          `xml:"ListBucketResult"`
+	Namespace             string            `xml:"xmlns,attr"`
+	Prefix                string            `xml:"Prefix"`
+	Delimiter             string            `xml:"Delimiter"`
+	MaxKeys               int               `xml:"MaxKeys"`
+	KeyCount              int               `xml:"KeyCount"`
+	IsTruncated           bool              `xml:"IsTruncated"`
+	ContinuationToken     string            `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string            `xml:"NextContinuationToken,omitempty"`
+	Contents              []xmlContents     `xml:"Contents"`
+	CommonPrefixes        []xmlCommonPrefix `xml:"CommonPrefixes"`
+}
+
+type xmlContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified,omitempty"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         int64  `xml:"Size"`
+	ContentType  string `xml:"ContentType,omitempty"`
+}
+
+type xmlCommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func renderXMLListing(w http.ResponseWriter, listing DirectoryListing) error {
+	out := xmlListing{
+		Namespace:             s3XMLNamespace,
+		Prefix:                listing.Path,
+		Delimiter:             "/",
+		MaxKeys:               listing.PageSize,
+		KeyCount:              len(listing.Entries) + len(listing.Prefixes),
+		IsTruncated:           listing.IsTruncated,
+		ContinuationToken:     listing.Token,
+		NextContinuationToken: listing.NextToken,
+	}
+	for _, e := range listing.Entries {
+		c := xmlContents{
+			Key:         listing.Path + e.Name,
+			ETag:        e.ETag,
+			Size:        e.Size,
+			ContentType: e.ContentType,
+		}
+		if e.ModTime != nil && !e.ModTime.IsZero() {
+			c.LastModified = e.ModTime.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		out.Contents = append(out.Contents, c)
+	}
+	for _, p := range listing.Prefixes {
+		out.CommonPrefixes = append(out.CommonPrefixes, xmlCommonPrefix{
+			Prefix: listing.Path + p,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(out)
+}
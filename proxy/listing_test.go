@@ -0,0 +1,188 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+// newListingHandler returns a handler that lists two entries of dir/ per page,
+// hiding JSON objects.
+func newListingHandler(t *testing.T) http.Handler {
+	t.Helper()
+
+	autoindex, size := true, 2
+	h, err := proxy.NewHandler(proxytest.NewMemoryBackend(proxytest.Objects), proxy.Options{
+		Autoindex:         &autoindex,
+		AutoindexPageSize: &size,
+		AutoindexHide:     []string{"*.json"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHandlerListingFormat(t *testing.T) {
+	h := newListingHandler(t)
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		want   string
+	}{
+		{"default", "", "", "text/html"},
+		{"browser", "", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html"},
+		{"json", "", "application/json", "application/json"},
+		{"xml", "", "application/xml", "application/xml"},
+		{"text xml", "", "text/xml", "application/xml"},
+		{"preferred", "", "application/json;q=0.5, application/xml", "application/xml"},
+		{"refused", "", "application/json;q=0", "text/html"},
+		{"unknown", "", "image/png", "text/html"},
+		{"json parameter", "format=json", "", "application/json"},
+		{"xml parameter", "format=XML", "", "application/xml"},
+		{"parameter over accept", "format=html", "application/json", "text/html"},
+		{"unknown parameter", "format=yaml", "application/json", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/dir/?"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.want) {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.want)
+			}
+			if v := w.Header().Get("Vary"); v != "Accept" {
+				t.Errorf("Vary = %q, want Accept", v)
+			}
+		})
+	}
+}
+
+func TestHandlerJSONListing(t *testing.T) {
+	type entry struct {
+		Name        string     `json:"name"`
+		Size        int64      `json:"size"`
+		ModTime     *time.Time `json:"modified"`
+		ETag        string     `json:"etag"`
+		ContentType string     `json:"content_type"`
+		IsDirectory bool       `json:"is_directory"`
+	}
+	type listing struct {
+		Path        string  `json:"path"`
+		Entries     []entry `json:"entries"`
+		IsTruncated bool    `json:"is_truncated"`
+		NextToken   string  `json:"next_token"`
+	}
+	get := func(t *testing.T, target string) listing {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		newListingHandler(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var l listing
+		if err := json.NewDecoder(w.Body).Decode(&l); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	// The first page holds a.txt and b.json, the latter of which is hidden
+	l := get(t, "/dir/?format=json")
+	if l.Path != "dir/" || !l.IsTruncated || l.NextToken != "dir/b.json" {
+		t.Errorf("first page = %q, truncated %v, next token %q", l.Path, l.IsTruncated, l.NextToken)
+	}
+	modTime := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	for i, e := range l.Entries {
+		if e.ETag == "" || e.ModTime == nil || !e.ModTime.Equal(modTime) {
+			t.Errorf("entry %q has ETag %q, modification time %v", e.Name, e.ETag, e.ModTime)
+		}
+		l.Entries[i].ETag, l.Entries[i].ModTime = "", nil
+	}
+	want := []entry{{Name: "a.txt", Size: 1, ContentType: "text/plain"}}
+	if !reflect.DeepEqual(l.Entries, want) {
+		t.Errorf("first page entries = %+v, want %+v", l.Entries, want)
+	}
+
+	l = get(t, "/dir/?format=json&page=dir%2Fb.json")
+	if l.IsTruncated || l.NextToken != "" {
+		t.Errorf("last page is truncated %v, with next token %q", l.IsTruncated, l.NextToken)
+	}
+	if want := []entry{{Name: "sub/", IsDirectory: true}}; !reflect.DeepEqual(l.Entries, want) {
+		t.Errorf("last page entries = %+v, want %+v", l.Entries, want)
+	}
+}
+
+func TestHandlerXMLListing(t *testing.T) {
+	type contents struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		Size         int64  `xml:"Size"`
+		ContentType  string `xml:"ContentType"`
+	}
+	type result struct {
+		XMLName               xml.Name
+		Prefix                string     `xml:"Prefix"`
+		Delimiter             string     `xml:"Delimiter"`
+		MaxKeys               int        `xml:"MaxKeys"`
+		KeyCount              int        `xml:"KeyCount"`
+		IsTruncated           bool       `xml:"IsTruncated"`
+		ContinuationToken     string     `xml:"ContinuationToken"`
+		NextContinuationToken string     `xml:"NextContinuationToken"`
+		Contents              []contents `xml:"Contents"`
+		CommonPrefixes        []string   `xml:"CommonPrefixes>Prefix"`
+	}
+	get := func(t *testing.T, target string) result {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		newListingHandler(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var res result
+		if err := xml.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := get(t, "/dir/?format=xml")
+	want := result{
+		XMLName:               xml.Name{Space: "http://s3.amazonaws.com/doc/2006-03-01/", Local: "ListBucketResult"},
+		Prefix:                "dir/",
+		Delimiter:             "/",
+		MaxKeys:               2,
+		KeyCount:              1,
+		IsTruncated:           true,
+		NextContinuationToken: "dir/b.json",
+		Contents:              []contents{{Key: "dir/a.txt", LastModified: "2024-03-04T05:06:07.000Z", Size: 1, ContentType: "text/plain"}},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("first page = %+v, want %+v", res, want)
+	}
+
+	res = get(t, "/dir/?format=xml&page=dir%2Fb.json")
+	want = result{
+		XMLName:           want.XMLName,
+		Prefix:            "dir/",
+		Delimiter:         "/",
+		MaxKeys:           2,
+		KeyCount:          1,
+		ContinuationToken: "dir/b.json",
+		CommonPrefixes:    []string{"dir/sub/"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("last page = %+v, want %+v", res, want)
+	}
+}
@@ -1,6 +1,152 @@
 package proxy
 
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Listing page sizes, in number of entries.
+const (
+	DefaultPageSize = 1000
+	MaxPageSize     = 5000
+)
+
+// DefaultUploadMaxBytes is the largest object that may be uploaded, unless
+// configured otherwise.
+const DefaultUploadMaxBytes = 1 << 30
+
+// PostPolicyExpiry is how long signed POST policies may be used for.
+const PostPolicyExpiry = 15 * time.Minute
+
 type Options struct {
-	Autoindex  *bool    `json:"autoindex,omitempty" yaml:"autoindex,omitempty"`
-	IndexFiles []string `json:"index_files,omitempty" yaml:"index_files,omitempty"`
+	Autoindex         *bool    `json:"autoindex,omitempty" yaml:"autoindex,omitempty"`
+	AutoindexPageSize *int     `json:"autoindex_page_size,omitempty" yaml:"autoindex_page_size,omitempty"`
+	AutoindexTemplate string   `json:"autoindex_template,omitempty" yaml:"autoindex_template,omitempty"`
+	AutoindexHeader   []string `json:"autoindex_header,omitempty" yaml:"autoindex_header,omitempty"`
+	AutoindexReadme   []string `json:"autoindex_readme,omitempty" yaml:"autoindex_readme,omitempty"`
+	IndexFiles        []string `json:"index_files,omitempty" yaml:"index_files,omitempty"`
+
+	// Listing rules; see ListingRules.
+	AutoindexHide         []string `json:"autoindex_hide,omitempty" yaml:"autoindex_hide,omitempty"`
+	AutoindexHideDotfiles *bool    `json:"autoindex_hide_dotfiles,omitempty" yaml:"autoindex_hide_dotfiles,omitempty"`
+	AutoindexDirsFirst    *bool    `json:"autoindex_dirs_first,omitempty" yaml:"autoindex_dirs_first,omitempty"`
+	AutoindexSort         string   `json:"autoindex_sort,omitempty" yaml:"autoindex_sort,omitempty"`
+	AutoindexOrder        string   `json:"autoindex_order,omitempty" yaml:"autoindex_order,omitempty"`
+
+	// Directory archives; see ArchiveFormats.
+	AutoindexArchive           *bool  `json:"autoindex_archive,omitempty" yaml:"autoindex_archive,omitempty"`
+	AutoindexArchiveMaxObjects *int   `json:"autoindex_archive_max_objects,omitempty" yaml:"autoindex_archive_max_objects,omitempty"`
+	AutoindexArchiveMaxBytes   *int64 `json:"autoindex_archive_max_bytes,omitempty" yaml:"autoindex_archive_max_bytes,omitempty"`
+
+	// Uploads with PUT; see UploadEnabled.
+	AllowUpload    *bool  `json:"allow_upload,omitempty" yaml:"allow_upload,omitempty"`
+	UploadMaxBytes *int64 `json:"upload_max_bytes,omitempty" yaml:"upload_max_bytes,omitempty"`
+	AllowOverwrite *bool  `json:"allow_overwrite,omitempty" yaml:"allow_overwrite,omitempty"`
+
+	// Uploads directly to the backend; see PostPolicyEnabled.
+	UploadPostPolicy    *bool `json:"upload_post_policy,omitempty" yaml:"upload_post_policy,omitempty"`
+	AutoindexUploadForm *bool `json:"autoindex_upload_form,omitempty" yaml:"autoindex_upload_form,omitempty"`
+
+	// Deletes with DELETE; see DeleteEnabled.
+	AllowDelete *bool `json:"allow_delete,omitempty" yaml:"allow_delete,omitempty"`
+
+	// Mounting as a network drive; see WebDAVEnabled.
+	WebDAV *bool `json:"webdav,omitempty" yaml:"webdav,omitempty"`
+
+	// PrivateKeys are objects that are never served, listed or changed, such
+	// as credentials that are stored in the backend. They are not configured
+	// directly, but set from the other settings of a handler.
+	PrivateKeys []string `json:"-" yaml:"-"`
+}
+
+// Private reports whether the object at key is one of PrivateKeys.
+func (o Options) Private(key string) bool {
+	return slices.Contains(o.PrivateKeys, key)
+}
+
+// HasPrivate reports whether any of PrivateKeys is under prefix.
+func (o Options) HasPrivate(prefix string) bool {
+	return slices.ContainsFunc(o.PrivateKeys, func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+// PageSize returns the number of entries in each page of a directory listing,
+// which is never more than MaxPageSize, so that large prefixes are not loaded
+// into memory all at once.
+func (o Options) PageSize() int {
+	if o.AutoindexPageSize == nil || *o.AutoindexPageSize <= 0 {
+		return DefaultPageSize
+	}
+	if n := *o.AutoindexPageSize; n < MaxPageSize {
+		return n
+	}
+	return MaxPageSize
+}
+
+// ArchiveEnabled reports whether directories may be downloaded as archives,
+// which requires directory listings to be enabled as well.
+func (o Options) ArchiveEnabled() bool {
+	return o.Autoindex != nil && *o.Autoindex && o.AutoindexArchive != nil && *o.AutoindexArchive
+}
+
+// ArchiveMaxObjects returns the maximum number of objects in an archive.
+func (o Options) ArchiveMaxObjects() int {
+	if o.AutoindexArchiveMaxObjects == nil || *o.AutoindexArchiveMaxObjects <= 0 {
+		return DefaultArchiveMaxObjects
+	}
+	return *o.AutoindexArchiveMaxObjects
+}
+
+// ArchiveMaxBytes returns the maximum total size of the objects in an archive.
+func (o Options) ArchiveMaxBytes() int64 {
+	if o.AutoindexArchiveMaxBytes == nil || *o.AutoindexArchiveMaxBytes <= 0 {
+		return DefaultArchiveMaxBytes
+	}
+	return *o.AutoindexArchiveMaxBytes
+}
+
+// UploadEnabled reports whether objects may be uploaded with PUT.
+func (o Options) UploadEnabled() bool {
+	return o.AllowUpload != nil && *o.AllowUpload
+}
+
+// UploadMaxSize returns the size of the largest object that may be uploaded.
+func (o Options) UploadMaxSize() int64 {
+	if o.UploadMaxBytes == nil || *o.UploadMaxBytes <= 0 {
+		return DefaultUploadMaxBytes
+	}
+	return *o.UploadMaxBytes
+}
+
+// PostPolicyEnabled reports whether signed POST policies may be requested, so
+// that browsers can upload objects directly to the backend, which requires
+// uploads to be enabled as well.
+func (o Options) PostPolicyEnabled() bool {
+	return o.UploadEnabled() && o.UploadPostPolicy != nil && *o.UploadPostPolicy
+}
+
+// UploadFormEnabled reports whether directory listings include a form for
+// uploading objects with signed POST policies.
+func (o Options) UploadFormEnabled() bool {
+	return o.PostPolicyEnabled() && o.Autoindex != nil && *o.Autoindex && o.AutoindexUploadForm != nil && *o.AutoindexUploadForm
+}
+
+// OverwriteEnabled reports whether uploads may replace existing objects, which
+// they may unless configured otherwise.
+func (o Options) OverwriteEnabled() bool {
+	return o.AllowOverwrite == nil || *o.AllowOverwrite
+}
+
+// DeleteEnabled reports whether objects may be deleted with DELETE.
+func (o Options) DeleteEnabled() bool {
+	return o.AllowDelete != nil && *o.AllowDelete
+}
+
+// WebDAVEnabled reports whether the handler also speaks WebDAV, so that it can
+// be mounted as a network drive. Collections may only be changed as allowed by
+// AllowUpload and AllowDelete.
+func (o Options) WebDAVEnabled() bool {
+	return o.WebDAV != nil && *o.WebDAV
 }
@@ -0,0 +1,146 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Default timeouts used when talking to the origin.
+const (
+	DefaultDialTimeout           = 5 * time.Second
+	DefaultResponseHeaderTimeout = 10 * time.Second
+)
+
+// Config describes an upstream HTTP origin.
+type Config struct {
+	// URL is the base URL of the origin. Request paths are appended to it.
+	URL string
+
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+
+	// RequestHeaders filters the headers forwarded to the origin, while
+	// ResponseHeaders filters the headers returned to the client.
+	RequestHeaders  HeaderFilter
+	ResponseHeaders HeaderFilter
+
+	// Credentials are removed from requests before they are forwarded.
+	Credentials Credentials
+}
+
+// Credentials are what clients authenticate to ssp with, which the origin
+// has no use for, and must not be able to reuse.
+type Credentials struct {
+	// Headers are the names of request headers, e.g., "Authorization".
+	Headers []string
+	// Cookies are the names of cookies, e.g., session cookies.
+	Cookies []string
+	// Query are the names of query parameters, e.g., those of signed URLs.
+	Query []string
+}
+
+// strip removes the credentials in c from r.
+func (c Credentials) strip(r *http.Request) {
+	for _, h := range c.Headers {
+		r.Header.Del(h)
+	}
+
+	if len(c.Cookies) > 0 && r.Header.Get("Cookie") != "" {
+		var kept []string
+		for _, ck := range r.Cookies() {
+			if !slices.Contains(c.Cookies, ck.Name) {
+				kept = append(kept, ck.String())
+			}
+		}
+		r.Header.Del("Cookie")
+		if len(kept) > 0 {
+			r.Header.Set("Cookie", strings.Join(kept, "; "))
+		}
+	}
+
+	if len(c.Query) > 0 && r.URL.RawQuery != "" {
+		q := r.URL.Query()
+		found := false
+		for _, name := range c.Query {
+			if q.Has(name) {
+				q.Del(name)
+				found = true
+			}
+		}
+		// Queries without credentials are forwarded exactly as they were sent
+		if found {
+			r.URL.RawQuery = q.Encode()
+		}
+	}
+}
+
+// NewHandler creates a reverse proxy to the origin described by c.
+func NewHandler(c Config) (http.Handler, error) {
+	if c.URL == "" {
+		return nil, errors.New("Origin URL is required")
+	}
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, errors.New("Origin URL must be http or https")
+	}
+
+	if c.DialTimeout == 0 {
+		c.DialTimeout = DefaultDialTimeout
+	}
+	if c.ResponseHeaderTimeout == 0 {
+		c.ResponseHeaderTimeout = DefaultResponseHeaderTimeout
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{Timeout: c.DialTimeout}).DialContext
+	tr.ResponseHeaderTimeout = c.ResponseHeaderTimeout
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			c.Credentials.strip(pr.Out)
+			pr.SetURL(base)
+			pr.SetXForwarded()
+			c.RequestHeaders.Apply(pr.Out.Header)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			c.ResponseHeaders.Apply(resp.Header)
+			return nil
+		},
+		ErrorHandler: errorHandler,
+		Transport:    tr,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := hlog.FromRequest(r)
+		log.UpdateContext(func(zc zerolog.Context) zerolog.Context {
+			return zc.
+				Str("origin_host", base.Host).
+				Str("origin_path", r.URL.Path)
+		})
+		rp.ServeHTTP(w, r)
+	}), nil
+}
+
+func errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	hlog.FromRequest(r).Error().Err(err).Msg("origin request error")
+
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
@@ -0,0 +1,170 @@
+package origin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// received is what the origin received, as echoed back to the client.
+type received struct {
+	Path   string
+	Query  string
+	Header http.Header
+}
+
+func newOrigin(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Amz-Request-Id", "abc")
+		w.Header().Set("X-Served-By", "origin")
+		json.NewEncoder(w).Encode(received{Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func serve(t *testing.T, c Config, r *http.Request) (*httptest.ResponseRecorder, received) {
+	t.Helper()
+
+	h, err := NewHandler(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var got received
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, got
+}
+
+func TestHandler(t *testing.T) {
+	srv := newOrigin(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/dir/a.txt?b=2&a=1", nil)
+	r.Header.Set("Authorization", "Bearer for-the-origin")
+	r.Header.Set("Cookie", "theme=dark")
+	w, got := serve(t, Config{URL: srv.URL + "/base"}, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got.Path != "/base/dir/a.txt" || got.Query != "b=2&a=1" {
+		t.Errorf("origin received %s?%s, want /base/dir/a.txt?b=2&a=1", got.Path, got.Query)
+	}
+	// Without credentials of its own, the handler forwards everything
+	if got.Header.Get("Authorization") != "Bearer for-the-origin" || got.Header.Get("Cookie") != "theme=dark" {
+		t.Errorf("origin received headers %v", got.Header)
+	}
+	if got.Header.Get("X-Forwarded-For") == "" {
+		t.Error("origin did not receive X-Forwarded-For")
+	}
+}
+
+func TestHandlerStripsCredentials(t *testing.T) {
+	srv := newOrigin(t)
+	c := Config{
+		URL: srv.URL,
+		Credentials: Credentials{
+			Headers: []string{"Authorization"},
+			Cookies: []string{"ssp_session", "ssp_session_login"},
+			Query:   []string{"ssp_expires", "ssp_key", "ssp_signature"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		cookie     string
+		wantQuery  string
+		wantCookie string
+	}{
+		{"signed url", "/a.txt?ssp_expires=1&archive=zip&ssp_key=k1&ssp_signature=sig", "", "archive=zip", ""},
+		{"unsigned query", "/a.txt?b=2&a=1", "", "b=2&a=1", ""},
+		{"session", "/a.txt", "theme=dark; ssp_session=s3cr3t; ssp_session_login=state", "", "theme=dark"},
+		{"only session", "/a.txt", "ssp_session=s3cr3t", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.SetBasicAuth("alice", "wonderland")
+			if tt.cookie != "" {
+				r.Header.Set("Cookie", tt.cookie)
+			}
+			w, got := serve(t, c, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got.Query != tt.wantQuery {
+				t.Errorf("origin received query %q, want %q", got.Query, tt.wantQuery)
+			}
+			if v := got.Header.Get("Cookie"); v != tt.wantCookie {
+				t.Errorf("origin received cookies %q, want %q", v, tt.wantCookie)
+			}
+			if v := got.Header.Get("Authorization"); v != "" {
+				t.Errorf("origin received Authorization %q", v)
+			}
+		})
+	}
+}
+
+func TestHandlerHeaderFilters(t *testing.T) {
+	srv := newOrigin(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+	r.Header.Set("X-Trace", "abc")
+	r.Header.Set("X-Debug", "1")
+	w, got := serve(t, Config{
+		URL:             srv.URL,
+		RequestHeaders:  HeaderFilter{Deny: []string{"x-debug"}},
+		ResponseHeaders: HeaderFilter{Deny: []string{"X-Amz-*"}},
+	}, r)
+	if got.Header.Get("X-Trace") != "abc" || got.Header.Get("X-Debug") != "" {
+		t.Errorf("origin received headers %v", got.Header)
+	}
+	if w.Header().Get("X-Amz-Request-Id") != "" || w.Header().Get("X-Served-By") != "origin" {
+		t.Errorf("client received headers %v", w.Header())
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		config     Config
+		wantStatus int
+	}{
+		{"unreachable", Config{URL: closed.URL}, http.StatusBadGateway},
+		{"timeout", Config{URL: slow.URL, ResponseHeaderTimeout: 20 * time.Millisecond}, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := serve(t, tt.config, httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewHandlerErrors(t *testing.T) {
+	for _, u := range []string{"", "ftp://example.com/", "://example.com"} {
+		if _, err := NewHandler(Config{URL: u}); err == nil {
+			t.Errorf("NewHandler(%q) error = nil, want one", u)
+		}
+	}
+}
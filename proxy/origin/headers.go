@@ -0,0 +1,41 @@
+package origin
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HeaderFilter restricts which headers pass through the proxy. When Allow is
+// not empty, only the headers it names are kept; headers named in Deny are
+// always removed. Names are case-insensitive, and may end in "*" to match any
+// header sharing that prefix, e.g., "X-Amz-*".
+type HeaderFilter struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// Apply removes all filtered headers from hdr.
+func (f HeaderFilter) Apply(hdr http.Header) {
+	for k := range hdr {
+		if len(f.Allow) > 0 && !matchAny(f.Allow, k) {
+			hdr.Del(k)
+			continue
+		}
+		if matchAny(f.Deny, k) {
+			hdr.Del(k)
+		}
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
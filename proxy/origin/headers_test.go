@@ -0,0 +1,47 @@
+package origin
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	hdr := http.Header{
+		"Accept":         {"*/*"},
+		"Cookie":         {"theme=dark"},
+		"X-Amz-Meta-Foo": {"bar"},
+		"X-Amz-Request":  {"1"},
+		"X-Trace":        {"abc"},
+	}
+	tests := []struct {
+		name   string
+		filter HeaderFilter
+		want   []string
+	}{
+		{"empty", HeaderFilter{}, []string{"Accept", "Cookie", "X-Amz-Meta-Foo", "X-Amz-Request", "X-Trace"}},
+		{"allow", HeaderFilter{Allow: []string{"accept", "X-Trace"}}, []string{"Accept", "X-Trace"}},
+		{"allow wildcard", HeaderFilter{Allow: []string{"x-amz-*"}}, []string{"X-Amz-Meta-Foo", "X-Amz-Request"}},
+		{"allow everything", HeaderFilter{Allow: []string{"*"}}, []string{"Accept", "Cookie", "X-Amz-Meta-Foo", "X-Amz-Request", "X-Trace"}},
+		{"deny", HeaderFilter{Deny: []string{"COOKIE"}}, []string{"Accept", "X-Amz-Meta-Foo", "X-Amz-Request", "X-Trace"}},
+		{"deny wildcard", HeaderFilter{Deny: []string{"X-Amz-Meta-*"}}, []string{"Accept", "Cookie", "X-Amz-Request", "X-Trace"}},
+		{"deny overrides allow", HeaderFilter{Allow: []string{"X-*"}, Deny: []string{"X-Amz-*"}}, []string{"X-Trace"}},
+		{"wildcard is a prefix only", HeaderFilter{Allow: []string{"Amz*"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hdr.Clone()
+			tt.filter.Apply(h)
+
+			var got []string
+			for k := range h {
+				got = append(got, k)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("headers = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+type contextKey struct {
+	name string
+}
+
+var contextKeyReadOnly = &contextKey{"read-only"}
+
+// WithReadOnly returns a copy of ctx for a request whose user may not change
+// objects under its key, so that they are not offered any way to.
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyReadOnly, true)
+}
+
+// ReadOnly reports whether ctx was returned by WithReadOnly.
+func ReadOnly(ctx context.Context) bool {
+	ro, _ := ctx.Value(contextKeyReadOnly).(bool)
+	return ro
+}
+
+// servePostPolicy responds with a signed POST policy for uploading objects
+// under prefix directly to the backend.
+func (h *handler) servePostPolicy(w http.ResponseWriter, r *http.Request, prefix string) {
+	log := hlog.FromRequest(r)
+
+	if _, ok := h.Backend.(PostSigner); !ok || !h.Options.PostPolicyEnabled() {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		http.Error(w, "POST policies are only issued for directories.", http.StatusBadRequest)
+		return
+	}
+
+	// Policies allow uploading any key under prefix, including private ones
+	if h.Options.HasPrivate(prefix) {
+		http.Error(w, "POST policies are not issued for this directory.", http.StatusForbidden)
+		return
+	}
+
+	p, err := h.signPost(r, prefix, "")
+	if err != nil {
+		var be *BackendError
+		if errors.As(err, &be) {
+			log.Error().Err(err).Fields(be.Fields).Msg("could not sign POST policy")
+			http.Error(w, be.Message, be.StatusCode)
+			return
+		}
+		log.Error().Err(err).Msg("could not sign POST policy")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		log.Error().Err(err).Msg("could not write POST policy")
+	}
+}
+
+// signPost signs a POST policy for uploading objects under prefix, after which
+// browsers are sent to redirectURL, if it is not empty.
+func (h *handler) signPost(r *http.Request, prefix, redirectURL string) (*PostPolicy, error) {
+	s, ok := h.Backend.(PostSigner)
+	if !ok {
+		return nil, errors.New("backend cannot sign POST policies")
+	}
+	return s.SignPost(r.Context(), prefix, PostOptions{
+		Expires:     PostPolicyExpiry,
+		MaxBytes:    h.Options.UploadMaxSize(),
+		RedirectURL: redirectURL,
+	})
+}
+
+// requestURL returns the absolute URL that the client requested, before any
+// prefix rewriting. The scheme is taken from X-Forwarded-Proto when served
+// over plain HTTP, since it is then usually behind a load balancer that
+// terminates TLS.
+func requestURL(r *http.Request) string {
+	u := &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+	if ru, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		u.Path, u.RawPath, u.RawQuery = ru.Path, ru.RawPath, ru.RawQuery
+	}
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
@@ -0,0 +1,474 @@
+// Package proxytest provides utilities for testing implementations of
+// proxy.Backend.
+package proxytest
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// Object is an object with which a backend under test is seeded.
+type Object struct {
+	Key          string
+	Body         string
+	ContentType  string
+	CacheControl string
+	ModTime      time.Time
+	Metadata     map[string]string
+
+	// RedirectLocation is only honored by backends that support redirects;
+	// see Capabilities.
+	RedirectLocation string
+}
+
+// Objects are the objects that a backend must contain, and nothing else, to be
+// tested by TestBackend.
+var Objects = []Object{
+	{
+		Key:          "hello.txt",
+		Body:         "hello, world\n",
+		ContentType:  "text/plain",
+		CacheControl: "max-age=60",
+		ModTime:      time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		Metadata:     map[string]string{"owner": "ripta"},
+	},
+	{
+		Key:         "empty.txt",
+		ContentType: "text/plain",
+		ModTime:     time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		Key:              "old.html",
+		Body:             "moved",
+		ContentType:      "text/html",
+		ModTime:          time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC),
+		RedirectLocation: "/index.html",
+	},
+	{
+		Key:         "index.html",
+		Body:        "<h1>hello</h1>",
+		ContentType: "text/html",
+		ModTime:     time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC),
+	},
+	{
+		Key:         "dir/a.txt",
+		Body:        "a",
+		ContentType: "text/plain",
+		ModTime:     time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC),
+	},
+	{
+		Key:         "dir/b.json",
+		Body:        `{"b":2}`,
+		ContentType: "application/json",
+		ModTime:     time.Date(2024, 4, 5, 6, 7, 8, 0, time.UTC),
+	},
+	{
+		Key:         "dir/sub/c.txt",
+		Body:        "c",
+		ContentType: "text/plain",
+		ModTime:     time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	},
+}
+
+// TestBackend checks that b behaves as the proxy package expects of every
+// backend, in particular that objects, listings and their attributes are
+// reported consistently. The backend must contain exactly Objects.
+func TestBackend(t *testing.T, b proxy.Backend) {
+	t.Helper()
+	ctx := context.Background()
+
+	t.Run("Stat", func(t *testing.T) {
+		for _, want := range Objects {
+			info, err := b.Stat(ctx, want.Key)
+			if err != nil {
+				t.Errorf("Stat(%q) error = %v", want.Key, err)
+				continue
+			}
+			checkInfo(t, "Stat", want, *info)
+			checkMetadata(t, want, *info)
+		}
+	})
+
+	t.Run("StatNotExist", func(t *testing.T) {
+		if _, err := b.Stat(ctx, "missing.txt"); !proxy.IsNotExist(err) {
+			t.Errorf("Stat(missing) error = %v, want not exist", err)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		for _, want := range Objects {
+			obj, err := b.Get(ctx, want.Key, nil)
+			if err != nil {
+				t.Errorf("Get(%q) error = %v", want.Key, err)
+				continue
+			}
+			body := readAll(t, obj)
+			if body != want.Body {
+				t.Errorf("Get(%q) body = %q, want %q", want.Key, body, want.Body)
+			}
+			if obj.Length != int64(len(want.Body)) {
+				t.Errorf("Get(%q) length = %d, want %d", want.Key, obj.Length, len(want.Body))
+			}
+			if obj.ContentRange != "" {
+				t.Errorf("Get(%q) content range = %q, want none", want.Key, obj.ContentRange)
+			}
+			checkInfo(t, "Get", want, obj.ObjectInfo)
+			checkMetadata(t, want, obj.ObjectInfo)
+		}
+	})
+
+	t.Run("GetRange", func(t *testing.T) {
+		tests := []struct {
+			rng       proxy.ByteRange
+			wantBody  string
+			wantRange string
+		}{
+			{proxy.ByteRange{Offset: 1, Length: 3}, "ell", "bytes 1-3/13"},
+			{proxy.ByteRange{Offset: 7, Length: -1}, "world\n", "bytes 7-12/13"},
+			{proxy.ByteRange{Offset: -6}, "world\n", "bytes 7-12/13"},
+		}
+		for _, tt := range tests {
+			rng := tt.rng
+			obj, err := b.Get(ctx, "hello.txt", &rng)
+			if err != nil {
+				t.Errorf("Get(%+v) error = %v", tt.rng, err)
+				continue
+			}
+			if body := readAll(t, obj); body != tt.wantBody {
+				t.Errorf("Get(%+v) body = %q, want %q", tt.rng, body, tt.wantBody)
+			}
+			if obj.Length != int64(len(tt.wantBody)) {
+				t.Errorf("Get(%+v) length = %d, want %d", tt.rng, obj.Length, len(tt.wantBody))
+			}
+			if obj.ContentRange != tt.wantRange {
+				t.Errorf("Get(%+v) content range = %q, want %q", tt.rng, obj.ContentRange, tt.wantRange)
+			}
+		}
+	})
+
+	t.Run("GetNotExist", func(t *testing.T) {
+		obj, err := b.Get(ctx, "missing.txt", nil)
+		if err == nil {
+			obj.Body.Close()
+		}
+		if !proxy.IsNotExist(err) {
+			t.Errorf("Get(missing) error = %v, want not exist", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		tests := []struct {
+			prefix       string
+			wantKeys     []string
+			wantPrefixes []string
+		}{
+			{"", []string{"empty.txt", "hello.txt", "index.html", "old.html"}, []string{"dir/"}},
+			{"dir/", []string{"dir/a.txt", "dir/b.json"}, []string{"dir/sub/"}},
+			{"dir/sub/", []string{"dir/sub/c.txt"}, nil},
+			{"missing/", nil, nil},
+		}
+		for _, tt := range tests {
+			res, err := b.List(ctx, tt.prefix, proxy.ListOptions{})
+			if err != nil {
+				t.Errorf("List(%q) error = %v", tt.prefix, err)
+				continue
+			}
+			if res.IsTruncated || res.NextToken != "" {
+				t.Errorf("List(%q) is truncated, with next token %q", tt.prefix, res.NextToken)
+			}
+
+			var keys []string
+			for _, info := range res.Objects {
+				keys = append(keys, info.Key)
+				want, ok := object(info.Key)
+				if !ok {
+					continue
+				}
+				if info.Size != int64(len(want.Body)) {
+					t.Errorf("List(%q) size of %q = %d, want %d", tt.prefix, info.Key, info.Size, len(want.Body))
+				}
+				if !info.ModTime.Equal(want.ModTime) {
+					t.Errorf("List(%q) modification time of %q = %v, want %v", tt.prefix, info.Key, info.ModTime, want.ModTime)
+				}
+			}
+			checkStrings(t, "List("+tt.prefix+") keys", keys, tt.wantKeys)
+			checkStrings(t, "List("+tt.prefix+") prefixes", res.Prefixes, tt.wantPrefixes)
+		}
+	})
+
+	t.Run("ListPages", func(t *testing.T) {
+		var keys, prefixes []string
+		opts := proxy.ListOptions{Limit: 1}
+		for i := 0; ; i++ {
+			if i > 10 {
+				t.Fatalf("List(dir/) did not finish after %d pages", i)
+			}
+			res, err := b.List(ctx, "dir/", opts)
+			if err != nil {
+				t.Fatalf("List(dir/) page %d error = %v", i, err)
+			}
+			if n := len(res.Objects) + len(res.Prefixes); n > opts.Limit {
+				t.Errorf("List(dir/) page %d has %d entries, want at most %d", i, n, opts.Limit)
+			}
+			for _, info := range res.Objects {
+				keys = append(keys, info.Key)
+			}
+			prefixes = append(prefixes, res.Prefixes...)
+			if !res.IsTruncated {
+				break
+			}
+			opts.Token = res.NextToken
+		}
+		checkStrings(t, "List(dir/) keys", keys, []string{"dir/a.txt", "dir/b.json"})
+		checkStrings(t, "List(dir/) prefixes", prefixes, []string{"dir/sub/"})
+	})
+}
+
+func object(key string) (Object, bool) {
+	for _, o := range Objects {
+		if o.Key == key {
+			return o, true
+		}
+	}
+	return Object{}, false
+}
+
+func readAll(t *testing.T, obj *proxy.Object) string {
+	t.Helper()
+	defer obj.Body.Close()
+
+	body, err := io.ReadAll(obj.Body)
+	if err != nil {
+		t.Errorf("reading %q: %v", obj.Key, err)
+	}
+	return string(body)
+}
+
+func checkInfo(t *testing.T, op string, want Object, got proxy.ObjectInfo) {
+	t.Helper()
+
+	if got.Key != want.Key {
+		t.Errorf("%s(%q) key = %q", op, want.Key, got.Key)
+	}
+	if got.Size != int64(len(want.Body)) {
+		t.Errorf("%s(%q) size = %d, want %d", op, want.Key, got.Size, len(want.Body))
+	}
+	if !got.ModTime.Equal(want.ModTime) {
+		t.Errorf("%s(%q) modification time = %v, want %v", op, want.Key, got.ModTime, want.ModTime)
+	}
+	if got.CacheControl != want.CacheControl {
+		t.Errorf("%s(%q) cache control = %q, want %q", op, want.Key, got.CacheControl, want.CacheControl)
+	}
+	if !strings.HasPrefix(got.ContentType, want.ContentType) {
+		t.Errorf("%s(%q) content type = %q, want %q", op, want.Key, got.ContentType, want.ContentType)
+	}
+	if etag := strings.TrimPrefix(got.ETag, "W/"); len(etag) < 3 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		t.Errorf("%s(%q) ETag = %q, want a quoted entity tag", op, want.Key, got.ETag)
+	}
+	if got.IsDirectory {
+		t.Errorf("%s(%q) is a directory", op, want.Key)
+	}
+}
+
+// checkMetadata checks that each item of user metadata is returned in a
+// header, which backends name differently, e.g., X-Amz-Meta-Owner.
+func checkMetadata(t *testing.T, want Object, got proxy.ObjectInfo) {
+	t.Helper()
+
+	for k, v := range want.Metadata {
+		found := false
+		for name, values := range got.Header {
+			if strings.HasSuffix(strings.ToLower(name), "-meta-"+k) && len(values) > 0 && values[0] == v {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("metadata %q of %q not found in headers %v", k, want.Key, got.Header)
+		}
+	}
+}
+
+func checkStrings(t *testing.T, what string, got, want []string) {
+	t.Helper()
+
+	got = append([]string(nil), got...)
+	want = append([]string(nil), want...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
+
+// TestUploader tests that objects uploaded to b are stored along with their
+// attributes, and replace any object already at their key unless asked not
+// to.
+func TestUploader(t *testing.T, b proxy.Backend) {
+	t.Helper()
+	ctx := context.Background()
+
+	u, ok := b.(proxy.Uploader)
+	if !ok {
+		t.Fatalf("%T is not a proxy.Uploader", b)
+	}
+
+	want := Object{
+		Key:          "uploads/report.txt",
+		Body:         "quarterly numbers\n",
+		ContentType:  "text/plain",
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"build": "42"},
+	}
+	for i, body := range []string{"draft\n", want.Body} {
+		// The size is not always known in advance
+		size := int64(len(body))
+		if i > 0 {
+			size = -1
+		}
+		info, err := u.Put(ctx, want.Key, strings.NewReader(body), proxy.PutOptions{
+			Size:         size,
+			CacheControl: want.CacheControl,
+			ContentType:  want.ContentType,
+			Metadata:     want.Metadata,
+		})
+		if err != nil {
+			t.Fatalf("Put(%q) error = %v", want.Key, err)
+		}
+		if etag := strings.TrimPrefix(info.ETag, "W/"); len(etag) < 3 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+			t.Errorf("Put(%q) ETag = %q, want a quoted entity tag", want.Key, info.ETag)
+		}
+	}
+
+	info, err := b.Stat(ctx, want.Key)
+	if err != nil {
+		t.Fatalf("Stat(%q) error = %v", want.Key, err)
+	}
+	if info.Size != int64(len(want.Body)) {
+		t.Errorf("Stat(%q) size = %d, want %d", want.Key, info.Size, len(want.Body))
+	}
+	if info.CacheControl != want.CacheControl {
+		t.Errorf("Stat(%q) cache control = %q, want %q", want.Key, info.CacheControl, want.CacheControl)
+	}
+	if !strings.HasPrefix(info.ContentType, want.ContentType) {
+		t.Errorf("Stat(%q) content type = %q, want %q", want.Key, info.ContentType, want.ContentType)
+	}
+	checkMetadata(t, want, *info)
+
+	obj, err := b.Get(ctx, want.Key, nil)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", want.Key, err)
+	}
+	if got := readAll(t, obj); got != want.Body {
+		t.Errorf("Get(%q) body = %q, want %q", want.Key, got, want.Body)
+	}
+
+	// Objects are only created, and not replaced, when requested
+	if _, err := u.Put(ctx, want.Key, strings.NewReader("final\n"), proxy.PutOptions{Size: 6, IfNoneMatch: true}); !proxy.IsExist(err) {
+		t.Errorf("Put(%q) without overwriting error = %v, want one that exists", want.Key, err)
+	}
+	if _, err := u.Put(ctx, "uploads/new.txt", strings.NewReader("new\n"), proxy.PutOptions{Size: 4, IfNoneMatch: true}); err != nil {
+		t.Errorf("Put(%q) without overwriting error = %v", "uploads/new.txt", err)
+	}
+}
+
+// TestDeleter tests that objects uploaded to b can be deleted from it.
+func TestDeleter(t *testing.T, b proxy.Backend) {
+	t.Helper()
+	ctx := context.Background()
+
+	u, ok := b.(proxy.Uploader)
+	if !ok {
+		t.Fatalf("%T is not a proxy.Uploader", b)
+	}
+	d, ok := b.(proxy.Deleter)
+	if !ok {
+		t.Fatalf("%T is not a proxy.Deleter", b)
+	}
+
+	const key = "uploads/obsolete.txt"
+	if _, err := u.Put(ctx, key, strings.NewReader("obsolete\n"), proxy.PutOptions{Size: 9}); err != nil {
+		t.Fatalf("Put(%q) error = %v", key, err)
+	}
+	if err := d.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete(%q) error = %v", key, err)
+	}
+	if _, err := b.Stat(ctx, key); !proxy.IsNotExist(err) {
+		t.Errorf("Stat(%q) after Delete error = %v, want one that does not exist", key, err)
+	}
+	if err := d.Delete(ctx, key); err != nil && !proxy.IsNotExist(err) {
+		t.Errorf("Delete(%q) again error = %v", key, err)
+	}
+}
+
+// TestCopier tests that objects uploaded to b can be copied within it, along
+// with their attributes.
+func TestCopier(t *testing.T, b proxy.Backend) {
+	t.Helper()
+	ctx := context.Background()
+
+	u, ok := b.(proxy.Uploader)
+	if !ok {
+		t.Fatalf("%T is not a proxy.Uploader", b)
+	}
+	c, ok := b.(proxy.Copier)
+	if !ok {
+		t.Fatalf("%T is not a proxy.Copier", b)
+	}
+
+	want := Object{
+		Key:          "uploads/original.txt",
+		Body:         "original\n",
+		ContentType:  "text/plain",
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"build": "42"},
+	}
+	if _, err := u.Put(ctx, want.Key, strings.NewReader(want.Body), proxy.PutOptions{
+		Size:         int64(len(want.Body)),
+		CacheControl: want.CacheControl,
+		ContentType:  want.ContentType,
+		Metadata:     want.Metadata,
+	}); err != nil {
+		t.Fatalf("Put(%q) error = %v", want.Key, err)
+	}
+
+	const dst = "uploads/copy.txt"
+	if _, err := c.Copy(ctx, want.Key, dst, proxy.CopyOptions{}); err != nil {
+		t.Fatalf("Copy(%q, %q) error = %v", want.Key, dst, err)
+	}
+	info, err := b.Stat(ctx, dst)
+	if err != nil {
+		t.Fatalf("Stat(%q) error = %v", dst, err)
+	}
+	if info.CacheControl != want.CacheControl {
+		t.Errorf("Stat(%q) cache control = %q, want %q", dst, info.CacheControl, want.CacheControl)
+	}
+	if !strings.HasPrefix(info.ContentType, want.ContentType) {
+		t.Errorf("Stat(%q) content type = %q, want %q", dst, info.ContentType, want.ContentType)
+	}
+	checkMetadata(t, want, *info)
+
+	obj, err := b.Get(ctx, dst, nil)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", dst, err)
+	}
+	if got := readAll(t, obj); got != want.Body {
+		t.Errorf("Get(%q) body = %q, want %q", dst, got, want.Body)
+	}
+
+	if _, err := c.Copy(ctx, want.Key, dst, proxy.CopyOptions{IfNoneMatch: true}); !proxy.IsExist(err) {
+		t.Errorf("Copy(%q, %q) without overwriting error = %v, want one that exists", want.Key, dst, err)
+	}
+	if _, err := c.Copy(ctx, "uploads/missing.txt", dst, proxy.CopyOptions{}); !proxy.IsNotExist(err) {
+		t.Errorf("Copy(%q, %q) error = %v, want one that does not exist", "uploads/missing.txt", dst, err)
+	}
+}
@@ -0,0 +1,232 @@
+package proxytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// Capabilities are the optional features of a backend under test.
+type Capabilities struct {
+	// Redirects is true when the backend honors Object.RedirectLocation.
+	Redirects bool
+}
+
+// TestHandler checks that a proxy.Handler serving b responds as it would for
+// any other backend: index files, directory listings, redirects, headers,
+// empty objects, ranges and errors. The backend must contain exactly Objects.
+func TestHandler(t *testing.T, b proxy.Backend, caps Capabilities) {
+	t.Helper()
+
+	autoindex := true
+	h, err := proxy.NewHandler(b, proxy.Options{
+		Autoindex:  &autoindex,
+		IndexFiles: []string{"index.html"},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	hello, _ := object("hello.txt")
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+		wantHeader http.Header
+	}{
+		{
+			name:       "index file",
+			path:       "/",
+			wantStatus: http.StatusOK,
+			wantBody:   "<h1>hello</h1>",
+			wantHeader: http.Header{"Content-Type": {"text/html"}, "Content-Length": {"14"}},
+		},
+		{
+			name:       "object",
+			path:       "/hello.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   hello.Body,
+			wantHeader: http.Header{
+				"Cache-Control":  {hello.CacheControl},
+				"Content-Length": {"13"},
+				"Content-Type":   {"text/plain"},
+				"Last-Modified":  {hello.ModTime.Format(http.TimeFormat)},
+			},
+		},
+		{
+			name:       "empty object",
+			path:       "/empty.txt",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "range",
+			path:       "/hello.txt",
+			header:     http.Header{"Range": {"bytes=1-3"}},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "ell",
+			wantHeader: http.Header{"Content-Length": {"3"}, "Content-Range": {"bytes 1-3/13"}},
+		},
+		{
+			name:       "suffix range",
+			path:       "/hello.txt",
+			header:     http.Header{"Range": {"bytes=-6"}},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "world\n",
+			wantHeader: http.Header{"Content-Length": {"6"}, "Content-Range": {"bytes 7-12/13"}},
+		},
+		{
+			name:       "multiple ranges",
+			path:       "/hello.txt",
+			header:     http.Header{"Range": {"bytes=0-1,3-4"}},
+			wantStatus: http.StatusOK,
+			wantBody:   hello.Body,
+		},
+		{
+			name:       "not found",
+			path:       "/missing.txt",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "autoindex",
+			path:       "/dir/",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, tt.path, tt.header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("GET %s body = %q, want %q", tt.path, w.Body.String(), tt.wantBody)
+			}
+			for k := range tt.wantHeader {
+				if got, want := w.Header().Get(k), tt.wantHeader.Get(k); !strings.HasPrefix(got, want) {
+					t.Errorf("GET %s header %s = %q, want %q", tt.path, k, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("headers", func(t *testing.T) {
+		w := serve(h, "/hello.txt", nil)
+		if etag := w.Header().Get("ETag"); !strings.HasSuffix(etag, `"`) {
+			t.Errorf("ETag = %q, want a quoted entity tag", etag)
+		}
+		found := false
+		for k, vs := range w.Header() {
+			if strings.HasSuffix(strings.ToLower(k), "-meta-owner") && vs[0] == hello.Metadata["owner"] {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("metadata not found in headers %v", w.Header())
+		}
+	})
+
+	t.Run("autoindex JSON", func(t *testing.T) {
+		w := serve(h, "/dir/?format=json", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+
+		var listing struct {
+			Path    string `json:"path"`
+			Entries []struct {
+				Name        string `json:"name"`
+				Size        int64  `json:"size"`
+				IsDirectory bool   `json:"is_directory"`
+			} `json:"entries"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+			t.Fatalf("invalid listing: %v", err)
+		}
+		var names []string
+		for _, e := range listing.Entries {
+			names = append(names, e.Name)
+		}
+		if want := []string{"sub/", "a.txt", "b.json"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("entries = %q, want %q", names, want)
+		}
+		if listing.Path != "dir/" {
+			t.Errorf("path = %q, want %q", listing.Path, "dir/")
+		}
+	})
+
+	t.Run("autoindex pages", func(t *testing.T) {
+		size := 1
+		paged, err := proxy.NewHandler(b, proxy.Options{Autoindex: &autoindex, AutoindexPageSize: &size})
+		if err != nil {
+			t.Fatalf("NewHandler() error = %v", err)
+		}
+
+		var names []string
+		next := "/dir/?format=json"
+		for i := 0; next != ""; i++ {
+			if i > 10 {
+				t.Fatalf("listing did not finish after %d pages", i)
+			}
+			w := serve(paged, next, nil)
+			var listing struct {
+				Entries []struct {
+					Name string `json:"name"`
+				} `json:"entries"`
+				NextToken string `json:"next_token"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+				t.Fatalf("invalid listing: %v", err)
+			}
+			for _, e := range listing.Entries {
+				names = append(names, e.Name)
+			}
+			next = ""
+			if listing.NextToken != "" {
+				next = "/dir/?format=json&page=" + url.QueryEscape(listing.NextToken)
+			}
+		}
+		checkStrings(t, "entries", names, []string{"a.txt", "b.json", "sub/"})
+	})
+
+	t.Run("autoindex denied", func(t *testing.T) {
+		denied, err := proxy.NewHandler(b, proxy.Options{})
+		if err != nil {
+			t.Fatalf("NewHandler() error = %v", err)
+		}
+		if w := serve(denied, "/dir/", nil); w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		if !caps.Redirects {
+			t.Skip("backend does not support redirects")
+		}
+		w := serve(h, "/old.html", nil)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusTemporaryRedirect)
+		}
+		if loc := w.Header().Get("Location"); loc != "/index.html" {
+			t.Errorf("Location = %q, want %q", loc, "/index.html")
+		}
+	})
+}
+
+func serve(h http.Handler, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, vs := range header {
+		r.Header[k] = vs
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
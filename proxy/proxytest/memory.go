@@ -0,0 +1,237 @@
+package proxytest
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// MemoryBackend is a proxy.Backend that serves objects held in memory. It
+// supports everything that a backend may, including redirects, uploads,
+// deletes and copies.
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]Object
+	keys    []string
+
+	// Err, when not nil, is returned by every operation instead of a result.
+	Err error
+}
+
+// NewMemoryBackend returns a backend that contains objects.
+func NewMemoryBackend(objects []Object) *MemoryBackend {
+	m := &MemoryBackend{objects: map[string]Object{}}
+	for _, o := range objects {
+		m.objects[o.Key] = o
+		m.keys = append(m.keys, o.Key)
+	}
+	sort.Strings(m.keys)
+	return m
+}
+
+func (m *MemoryBackend) UpdateLogContext(c zerolog.Context, key string) zerolog.Context {
+	return c.Str("memory_key", key)
+}
+
+func (m *MemoryBackend) Stat(ctx context.Context, key string) (*proxy.ObjectInfo, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	m.mu.RLock()
+	o, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, proxy.ErrNotExist
+	}
+	info := objectInfo(o)
+	return &info, nil
+}
+
+func (m *MemoryBackend) Get(ctx context.Context, key string, rng *proxy.ByteRange) (*proxy.Object, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	m.mu.RLock()
+	o, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, proxy.ErrNotExist
+	}
+
+	size := int64(len(o.Body))
+	start, end := int64(0), size
+	if rng != nil {
+		switch {
+		case rng.Offset < 0:
+			start = max(size+rng.Offset, 0)
+		case rng.Offset >= size:
+			return nil, &proxy.BackendError{
+				StatusCode: http.StatusRequestedRangeNotSatisfiable,
+				Message:    http.StatusText(http.StatusRequestedRangeNotSatisfiable),
+				Err:        fmt.Errorf("range starts at %d, beyond the end of %q", rng.Offset, key),
+			}
+		default:
+			start = rng.Offset
+			if rng.Length >= 0 {
+				end = min(start+rng.Length, size)
+			}
+		}
+	}
+
+	obj := &proxy.Object{
+		ObjectInfo: objectInfo(o),
+		Body:       io.NopCloser(strings.NewReader(o.Body[start:end])),
+		Length:     end - start,
+	}
+	if rng != nil {
+		obj.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, end-1, size)
+	}
+	return obj, nil
+}
+
+// List lists keys in lexicographic order, using the last key or prefix
+// returned as the continuation token.
+func (m *MemoryBackend) List(ctx context.Context, prefix string, opts proxy.ListOptions) (*proxy.ListResult, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = proxy.DefaultPageSize
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := &proxy.ListResult{}
+	last := opts.Token
+	for _, key := range m.keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+
+		name, isPrefix := key, false
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name, isPrefix = prefix+rest[:i+1], true
+		}
+		if name <= last {
+			continue
+		}
+
+		if len(res.Objects)+len(res.Prefixes) == limit {
+			res.IsTruncated = true
+			res.NextToken = last
+			break
+		}
+		if isPrefix {
+			res.Prefixes = append(res.Prefixes, name)
+		} else {
+			res.Objects = append(res.Objects, objectInfo(m.objects[key]))
+		}
+		last = name
+	}
+	return res, nil
+}
+
+func (m *MemoryBackend) Put(ctx context.Context, key string, body io.Reader, opts proxy.PutOptions) (*proxy.ObjectInfo, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	p, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	o := Object{
+		Key:          key,
+		Body:         string(p),
+		ContentType:  opts.ContentType,
+		CacheControl: opts.CacheControl,
+		ModTime:      time.Now().UTC().Truncate(time.Second),
+		Metadata:     opts.Metadata,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[key]; !ok {
+		i := sort.SearchStrings(m.keys, key)
+		m.keys = append(m.keys[:i], append([]string{key}, m.keys[i:]...)...)
+	} else if opts.IfNoneMatch {
+		return nil, proxy.ErrExist
+	}
+	m.objects[key] = o
+	info := objectInfo(o)
+	return &info, nil
+}
+
+func (m *MemoryBackend) Delete(ctx context.Context, key string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[key]; !ok {
+		return proxy.ErrNotExist
+	}
+	delete(m.objects, key)
+	i := sort.SearchStrings(m.keys, key)
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	return nil
+}
+
+func (m *MemoryBackend) Copy(ctx context.Context, src, dst string, opts proxy.CopyOptions) (*proxy.ObjectInfo, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objects[src]
+	if !ok {
+		return nil, proxy.ErrNotExist
+	}
+	if _, ok := m.objects[dst]; !ok {
+		i := sort.SearchStrings(m.keys, dst)
+		m.keys = append(m.keys[:i], append([]string{dst}, m.keys[i:]...)...)
+	} else if opts.IfNoneMatch {
+		return nil, proxy.ErrExist
+	}
+	o.Key = dst
+	o.ModTime = time.Now().UTC().Truncate(time.Second)
+	m.objects[dst] = o
+	info := objectInfo(o)
+	return &info, nil
+}
+
+func objectInfo(o Object) proxy.ObjectInfo {
+	sum := md5.Sum([]byte(o.Body))
+	hdr := http.Header{}
+	for k, v := range o.Metadata {
+		hdr.Add("X-Meta-"+k, v)
+	}
+
+	return proxy.ObjectInfo{
+		Key:              o.Key,
+		Size:             int64(len(o.Body)),
+		ModTime:          o.ModTime,
+		CacheControl:     o.CacheControl,
+		ContentType:      o.ContentType,
+		ETag:             `"` + hex.EncodeToString(sum[:]) + `"`,
+		RedirectLocation: o.RedirectLocation,
+		Header:           hdr,
+	}
+}
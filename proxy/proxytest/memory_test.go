@@ -0,0 +1,23 @@
+package proxytest
+
+import "testing"
+
+func TestMemoryBackend(t *testing.T) {
+	TestBackend(t, NewMemoryBackend(Objects))
+}
+
+func TestMemoryHandler(t *testing.T) {
+	TestHandler(t, NewMemoryBackend(Objects), Capabilities{Redirects: true})
+}
+
+func TestMemoryUploader(t *testing.T) {
+	TestUploader(t, NewMemoryBackend(Objects))
+}
+
+func TestMemoryDeleter(t *testing.T) {
+	TestDeleter(t, NewMemoryBackend(Objects))
+}
+
+func TestMemoryCopier(t *testing.T) {
+	TestCopier(t, NewMemoryBackend(Objects))
+}
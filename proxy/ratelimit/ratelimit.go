@@ -0,0 +1,178 @@
+// Package ratelimit limits how often, and how many requests at once, each
+// client may make.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/time/rate"
+
+	"github.com/ripta/ssp/proxy/auth"
+	"github.com/ripta/ssp/proxy/clientip"
+)
+
+// Key identifies the clients that are limited separately.
+type Key string
+
+const (
+	// KeyIP limits each client address separately.
+	KeyIP Key = "ip"
+	// KeyUser limits each authenticated user separately, and anyone else by
+	// their address.
+	KeyUser Key = "user"
+	// KeyHost limits each requested host separately.
+	KeyHost Key = "host"
+)
+
+// sweepInterval is how often clients that have been idle long enough for
+// their limits to reset are forgotten.
+const sweepInterval = time.Minute
+
+// Config configures a Limiter.
+type Config struct {
+	// Rate is how many requests per second each client may make on average,
+	// and Burst how many it may make at once. A zero Rate does not limit
+	// the rate of requests.
+	Rate  float64
+	Burst int
+	// MaxConcurrent, when positive, is how many requests each client may
+	// have in progress at once.
+	MaxConcurrent int
+	Key           Key
+}
+
+// Limiter limits requests using a token bucket per client.
+type Limiter struct {
+	config Config
+	idle   time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	inFlight int
+	lastSeen time.Time
+}
+
+// New creates a Limiter.
+func New(c Config) (*Limiter, error) {
+	switch c.Key {
+	case "":
+		c.Key = KeyIP
+	case KeyIP, KeyUser, KeyHost:
+	default:
+		return nil, fmt.Errorf("unknown rate limit key %q; must be one of %q, %q or %q", c.Key, KeyIP, KeyUser, KeyHost)
+	}
+	if c.Rate < 0 || c.Burst < 0 || c.MaxConcurrent < 0 {
+		return nil, fmt.Errorf("rate limits must not be negative")
+	}
+	if c.Rate > 0 && c.Burst == 0 {
+		c.Burst = int(math.Ceil(c.Rate))
+	}
+
+	// A client's bucket is full again once it has been idle for long enough,
+	// at which point it is no different from a new client
+	idle := sweepInterval
+	if c.Rate > 0 {
+		if d := time.Duration(float64(c.Burst) / c.Rate * float64(time.Second)); d > idle {
+			idle = d
+		}
+	}
+	return &Limiter{config: c, idle: idle, clients: map[string]*client{}}, nil
+}
+
+// Handler refuses requests to h with 429 Too Many Requests when their client
+// is over its limits.
+func (l *Limiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := l.key(r)
+		retry, ok := l.acquire(key, time.Now())
+		if !ok {
+			hlog.FromRequest(r).Warn().Str("rate_limit_key", key).Msg("rate limited")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer l.release(key)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) key(r *http.Request) string {
+	switch l.config.Key {
+	case KeyHost:
+		host := strings.ToLower(r.Host)
+		if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+			host = host[:i]
+		}
+		return "host:" + host
+	case KeyUser:
+		if user, ok := auth.User(r.Context()); ok {
+			return "user:" + user
+		}
+	}
+	return "ip:" + clientip.Addr(r).String()
+}
+
+// acquire takes a token and a concurrency slot for a client, or reports how
+// long it should wait before trying again.
+func (l *Limiter) acquire(key string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{}
+		if l.config.Rate > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(l.config.Rate), l.config.Burst)
+		}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	if l.config.MaxConcurrent > 0 && c.inFlight >= l.config.MaxConcurrent {
+		return time.Second, false
+	}
+	if c.limiter != nil {
+		res := c.limiter.ReserveN(now, 1)
+		if d := res.DelayFrom(now); d > 0 {
+			res.CancelAt(now)
+			return d, false
+		}
+	}
+	c.inFlight++
+	return 0, true
+}
+
+func (l *Limiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c, ok := l.clients[key]; ok {
+		c.inFlight--
+	}
+}
+
+// sweep forgets clients that have been idle long enough.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, c := range l.clients {
+		if c.inFlight == 0 && now.Sub(c.lastSeen) > l.idle {
+			delete(l.clients, key)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ripta/ssp/proxy/auth"
+)
+
+func mustNew(t *testing.T, c Config) *Limiter {
+	t.Helper()
+
+	l, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func serve(h http.Handler, remoteAddr, host, user string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr, r.Host = remoteAddr, host
+	if user != "" {
+		r = r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{User: user}))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRate(t *testing.T) {
+	h := mustNew(t, Config{Rate: 0.5, Burst: 2}).Handler(http.NotFoundHandler())
+
+	for i, want := range []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests} {
+		w := serve(h, "198.51.100.1:1234", "example.com", "")
+		if w.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests {
+			if got := w.Header().Get("Retry-After"); got != "2" {
+				t.Errorf("Retry-After = %q, want %q", got, "2")
+			}
+		}
+	}
+
+	if w := serve(h, "198.51.100.2:1234", "example.com", ""); w.Code != http.StatusNotFound {
+		t.Errorf("status for another client = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		key  Key
+		a, b [3]string
+		same bool
+	}{
+		{KeyIP, [3]string{"198.51.100.1:1", "a.example.com", ""}, [3]string{"198.51.100.1:2", "b.example.com", ""}, true},
+		{KeyIP, [3]string{"198.51.100.1:1", "a.example.com", ""}, [3]string{"198.51.100.2:1", "a.example.com", ""}, false},
+		{KeyHost, [3]string{"198.51.100.1:1", "a.example.com", ""}, [3]string{"198.51.100.2:1", "A.example.com:8080", ""}, true},
+		{KeyHost, [3]string{"198.51.100.1:1", "a.example.com", ""}, [3]string{"198.51.100.1:1", "b.example.com", ""}, false},
+		{KeyUser, [3]string{"198.51.100.1:1", "", "alice"}, [3]string{"198.51.100.2:1", "", "alice"}, true},
+		{KeyUser, [3]string{"198.51.100.1:1", "", "alice"}, [3]string{"198.51.100.1:1", "", "bob"}, false},
+		{KeyUser, [3]string{"198.51.100.1:1", "", ""}, [3]string{"198.51.100.1:2", "", ""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.key), func(t *testing.T) {
+			h := mustNew(t, Config{Rate: 1, Burst: 1, Key: tt.key}).Handler(http.NotFoundHandler())
+			serve(h, tt.a[0], tt.a[1], tt.a[2])
+			w := serve(h, tt.b[0], tt.b[1], tt.b[2])
+			if got := w.Code == http.StatusTooManyRequests; got != tt.same {
+				t.Errorf("%v then %v: limited = %v, want %v", tt.a, tt.b, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	l := mustNew(t, Config{MaxConcurrent: 1})
+	started, done := make(chan struct{}), make(chan struct{})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			close(started)
+			<-done
+		}
+	}))
+
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?block=1", nil))
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("concurrent request: status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	close(done)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code == http.StatusOK || time.Now().After(deadline) {
+			break
+		}
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("status after the first request finished = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := mustNew(t, Config{Rate: 1, Burst: 1})
+	now := time.Now()
+	if _, ok := l.acquire("ip:198.51.100.1", now); !ok {
+		t.Fatal("first request was limited")
+	}
+	l.release("ip:198.51.100.1")
+
+	l.acquire("ip:198.51.100.2", now.Add(2*sweepInterval))
+	if _, ok := l.clients["ip:198.51.100.1"]; ok {
+		t.Error("idle client was not forgotten")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, c := range []Config{{Key: "cookie"}, {Rate: -1}, {MaxConcurrent: -1}} {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"html/template"
+	"io"
+	pathpkg "path"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// maxReadmeSize is the largest header or readme object that is rendered into
+// a directory listing; larger objects are truncated.
+const maxReadmeSize = 1 << 20
+
+var (
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	// readmePolicy strips scripts, styles and event handlers from headers and
+	// readmes, which are usually written by whoever can upload to the bucket,
+	// rather than whoever operates ssp.
+	readmePolicy = bluemonday.UGCPolicy()
+)
+
+// loadReadme returns the first of candidates that exists under prefix in b,
+// rendered as sanitized HTML. Markdown (.md, .markdown) is converted to HTML,
+// HTML (.html, .htm) is kept as-is, and anything else is treated as plain
+// text. Missing candidates are skipped, while other errors are returned.
+func loadReadme(ctx context.Context, b Backend, prefix string, candidates []string) (template.HTML, error) {
+	for _, name := range candidates {
+		obj, err := b.Get(ctx, prefix+name, nil)
+		if err != nil {
+			if IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+
+		text, err := io.ReadAll(io.LimitReader(obj.Body, maxReadmeSize))
+		obj.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		return renderReadme(name, text)
+	}
+	return "", nil
+}
+
+func renderReadme(name string, text []byte) (template.HTML, error) {
+	var out []byte
+	switch strings.ToLower(pathpkg.Ext(name)) {
+	case ".md", ".markdown":
+		var buf bytes.Buffer
+		if err := markdown.Convert(text, &buf); err != nil {
+			return "", err
+		}
+		out = buf.Bytes()
+	case ".html", ".htm":
+		out = text
+	default:
+		out = []byte("<pre>" + html.EscapeString(string(text)) + "</pre>")
+	}
+
+	return template.HTML(readmePolicy.SanitizeBytes(out)), nil
+}
@@ -0,0 +1,84 @@
+package proxy_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+func TestHandlerReadme(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		body     string
+		want     []string
+		dontWant []string
+	}{
+		{
+			name:     "html",
+			key:      "docs/README.html",
+			body:     `<h2 onclick="steal()">Docs</h2><script>steal()</script><img src="logo.png" onerror="steal()"><a href="javascript:steal()">home</a>`,
+			want:     []string{"<h2>Docs</h2>", `<img src="logo.png">`, "home"},
+			dontWant: []string{"<script", "steal()", "onclick", "onerror", "javascript:"},
+		},
+		{
+			name:     "markdown",
+			key:      "docs/README.md",
+			body:     "# Docs\n\n<script>steal()</script>\n\n<b onmouseover=\"steal()\">bold</b> and [home](javascript:steal())\n",
+			want:     []string{"<h1>Docs</h1>", "bold"},
+			dontWant: []string{"<script", "steal()", "onmouseover", "javascript:"},
+		},
+		{
+			name:     "text",
+			key:      "docs/README.txt",
+			body:     "<script>steal()</script>\n",
+			want:     []string{"<pre>&lt;script&gt;steal()&lt;/script&gt;\n</pre>"},
+			dontWant: []string{"<script"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := proxytest.NewMemoryBackend([]proxytest.Object{
+				{Key: "docs/a.txt", Body: "a", ContentType: "text/plain"},
+				{Key: tt.key, Body: tt.body, ContentType: "text/plain"},
+			})
+			autoindex := true
+			h, err := proxy.NewHandler(b, proxy.Options{
+				Autoindex:       &autoindex,
+				AutoindexReadme: []string{"README.md", "README.html", "README.txt"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			body, _ := io.ReadAll(w.Body)
+			page := string(body)
+			i := strings.Index(page, `<article class="readme">`)
+			if i < 0 {
+				t.Fatalf("listing has no readme:\n%s", page)
+			}
+			// The listing has scripts of its own, so only the readme is checked
+			readme, _, _ := strings.Cut(page[i:], "</article>")
+			for _, s := range tt.want {
+				if !strings.Contains(readme, s) {
+					t.Errorf("readme does not contain %q:\n%s", s, readme)
+				}
+			}
+			for _, s := range tt.dontWant {
+				if strings.Contains(readme, s) {
+					t.Errorf("readme contains %q:\n%s", s, readme)
+				}
+			}
+		})
+	}
+}
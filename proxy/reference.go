@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ResolveReference returns the secret named by ref, which must be either
+// "env:NAME" or "file:/path". Surrounding whitespace is trimmed, so that
+// secrets mounted as files may end in a newline.
+func ResolveReference(ref string) (string, error) {
+	var v string
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		v = s
+	case strings.HasPrefix(ref, "file:"):
+		p, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", err
+		}
+		v = string(p)
+	default:
+		return "", fmt.Errorf("unsupported reference %q: must begin with env: or file:", ref)
+	}
+
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", fmt.Errorf("reference %q is empty", ref)
+	}
+	return v, nil
+}
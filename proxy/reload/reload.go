@@ -0,0 +1,82 @@
+// Package reload keeps values, such as credentials and templates, that are
+// periodically reloaded from a local file, a URL or a backend.
+package reload
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Timeout bounds each load. Since a load is shared by every caller that needs
+// it, it is not canceled along with any one of them.
+const Timeout = 30 * time.Second
+
+// MinBackoff is how long a failed load is retried after. It doubles with each
+// consecutive failure, up to the reload interval.
+const MinBackoff = time.Second
+
+// Loader holds a value that is reloaded every interval.
+type Loader[T any] struct {
+	load     func(context.Context) (T, error)
+	interval time.Duration
+	group    singleflight.Group
+
+	mu      sync.Mutex
+	value   T
+	err     error
+	expires time.Time
+	backoff time.Duration
+}
+
+// New creates a Loader that calls load, at most once at a time, whenever its
+// value is needed and is more than interval old. Nothing is loaded until then.
+func New[T any](interval time.Duration, load func(context.Context) (T, error)) *Loader[T] {
+	return &Loader[T]{load: load, interval: interval}
+}
+
+// Get returns the current value, reloading it when it has expired. Callers
+// wait for a single load between them, without holding any lock while it
+// runs. When the value cannot be reloaded, the previously-loaded value, if
+// any, is returned along with the error, and so it is until the load is
+// retried after a backoff.
+func (l *Loader[T]) Get(ctx context.Context) (T, error) {
+	l.mu.Lock()
+	v, err, fresh := l.value, l.err, time.Now().Before(l.expires)
+	l.mu.Unlock()
+	if fresh {
+		return v, err
+	}
+
+	ch := l.group.DoChan("", func() (interface{}, error) {
+		return l.reload(ctx)
+	})
+	select {
+	case res := <-ch:
+		v, _ := res.Val.(T)
+		return v, res.Err
+	case <-ctx.Done():
+		return v, ctx.Err()
+	}
+}
+
+func (l *Loader[T]) reload(ctx context.Context) (T, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), Timeout)
+	defer cancel()
+	v, err := l.load(ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.backoff = max(min(2*l.backoff, l.interval), MinBackoff)
+		l.err = err
+		l.expires = time.Now().Add(l.backoff)
+		return l.value, err
+	}
+
+	l.value, l.err, l.backoff = v, nil, 0
+	l.expires = time.Now().Add(l.interval)
+	return v, nil
+}
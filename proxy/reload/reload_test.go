@@ -0,0 +1,137 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoaderCaches(t *testing.T) {
+	var loads atomic.Int32
+	l := New(time.Hour, func(context.Context) (int32, error) {
+		return loads.Add(1), nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if v, err := l.Get(context.Background()); v != 1 || err != nil {
+			t.Fatalf("Get() = %d, %v; want 1, nil", v, err)
+		}
+	}
+
+	l.expires = time.Time{}
+	if v, err := l.Get(context.Background()); v != 2 || err != nil {
+		t.Fatalf("Get() after expiry = %d, %v; want 2, nil", v, err)
+	}
+}
+
+func TestLoaderSharesLoad(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	l := New(time.Hour, func(context.Context) (string, error) {
+		loads.Add(1)
+		<-release
+		return "loaded", nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := l.Get(context.Background()); v != "loaded" || err != nil {
+				t.Errorf("Get() = %q, %v; want %q, nil", v, err, "loaded")
+			}
+		}()
+	}
+	// Let the callers pile up behind the first load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loaded %d times, want 1", n)
+	}
+}
+
+func TestLoaderCanceledCaller(t *testing.T) {
+	release := make(chan struct{})
+	l := New(time.Hour, func(ctx context.Context) (string, error) {
+		select {
+		case <-release:
+			return "loaded", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.Get(ctx)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Get() with canceled context = %v, want %v", err, context.Canceled)
+	}
+
+	// The load goes on for callers that are still waiting
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	if v, err := l.Get(context.Background()); v != "loaded" || err != nil {
+		t.Errorf("Get() = %q, %v; want %q, nil", v, err, "loaded")
+	}
+}
+
+func TestLoaderKeepsValueOnError(t *testing.T) {
+	errLoad := errors.New("unavailable")
+	var loads atomic.Int32
+	var fail atomic.Bool
+	l := New(time.Hour, func(context.Context) (string, error) {
+		loads.Add(1)
+		if fail.Load() {
+			return "", errLoad
+		}
+		return "loaded", nil
+	})
+
+	if _, err := l.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	fail.Store(true)
+	l.expires = time.Time{}
+	for i := 0; i < 3; i++ {
+		if v, err := l.Get(context.Background()); v != "loaded" || !errors.Is(err, errLoad) {
+			t.Fatalf("Get() = %q, %v; want %q, %v", v, err, "loaded", errLoad)
+		}
+	}
+	if n := loads.Load(); n != 2 {
+		t.Errorf("loaded %d times during backoff, want 2", n)
+	}
+	if l.backoff != MinBackoff {
+		t.Errorf("backoff = %v, want %v", l.backoff, MinBackoff)
+	}
+
+	// Failures back off further, up to the interval
+	l.expires = time.Time{}
+	l.Get(context.Background())
+	if l.backoff != 2*MinBackoff {
+		t.Errorf("backoff after another failure = %v, want %v", l.backoff, 2*MinBackoff)
+	}
+
+	fail.Store(false)
+	l.expires = time.Time{}
+	if v, err := l.Get(context.Background()); v != "loaded" || err != nil {
+		t.Errorf("Get() after recovery = %q, %v; want %q, nil", v, err, "loaded")
+	}
+	if l.backoff != 0 {
+		t.Errorf("backoff after recovery = %v, want 0", l.backoff)
+	}
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"fmt"
+	pathpkg "path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RegexpPatternPrefix marks a hide pattern as a regular expression, rather
+// than a glob.
+const RegexpPatternPrefix = "re:"
+
+// Keys by which directory listings can be sorted.
+const (
+	SortByName    = "name"
+	SortBySize    = "size"
+	SortByModTime = "mtime"
+)
+
+// ListingRules decide which entries appear in directory listings, and in what
+// order. They are shared by all backends.
+type ListingRules struct {
+	hide        []hidePattern
+	hideDotfile bool
+	dirsFirst   bool
+
+	sortBy string
+	desc   bool
+}
+
+// hidePattern matches names of entries. Patterns ending in a slash only match
+// directories.
+type hidePattern struct {
+	glob    string
+	re      *regexp.Regexp
+	dirOnly bool
+}
+
+// NewListingRules compiles the listing rules in opts.
+func NewListingRules(opts Options) (*ListingRules, error) {
+	lr := &ListingRules{
+		hideDotfile: opts.AutoindexHideDotfiles != nil && *opts.AutoindexHideDotfiles,
+		dirsFirst:   opts.AutoindexDirsFirst == nil || *opts.AutoindexDirsFirst,
+		sortBy:      SortByName,
+	}
+
+	for _, p := range opts.AutoindexHide {
+		hp := hidePattern{}
+		if expr, ok := strings.CutPrefix(p, RegexpPatternPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hide pattern %q: %w", p, err)
+			}
+			hp.re = re
+		} else {
+			hp.glob, hp.dirOnly = strings.CutSuffix(p, "/")
+			if _, err := pathpkg.Match(hp.glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid hide pattern %q: %w", p, err)
+			}
+		}
+		lr.hide = append(lr.hide, hp)
+	}
+
+	if opts.AutoindexSort != "" {
+		if !validSortKey(opts.AutoindexSort) {
+			return nil, fmt.Errorf("invalid autoindex sort key %q", opts.AutoindexSort)
+		}
+		lr.sortBy = opts.AutoindexSort
+	}
+	switch opts.AutoindexOrder {
+	case "", "asc":
+	case "desc":
+		lr.desc = true
+	default:
+		return nil, fmt.Errorf("invalid autoindex sort order %q", opts.AutoindexOrder)
+	}
+	return lr, nil
+}
+
+func validSortKey(k string) bool {
+	return k == SortByName || k == SortBySize || k == SortByModTime
+}
+
+// Hidden reports whether the entry at key should be left out of listings.
+// The key is the path requested by the client, without its leading slash, and
+// directories end in a slash. Hide patterns match against the final component
+// of the key, unless they contain a slash themselves, in which case they match
+// the whole key.
+func (lr *ListingRules) Hidden(key string) bool {
+	isDir := strings.HasSuffix(key, "/")
+	trimmed := strings.TrimSuffix(key, "/")
+	name := pathpkg.Base(trimmed)
+
+	if lr.hideDotfile && strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, hp := range lr.hide {
+		if hp.re != nil {
+			if hp.re.MatchString(key) {
+				return true
+			}
+			continue
+		}
+		if hp.dirOnly && !isDir {
+			continue
+		}
+
+		subject := name
+		if strings.Contains(hp.glob, "/") {
+			subject = trimmed
+		}
+		if ok, _ := pathpkg.Match(hp.glob, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply filters and sorts the entries of listing in place, then fills in its
+// combined list of entries. The sort key and order may be overridden by the
+// "sort" and "order" query parameters, passed as sortBy and order.
+func (lr *ListingRules) Apply(listing *DirectoryListing, sortBy, order string) {
+	var entries []DirectoryEntry
+	for _, e := range listing.Entries {
+		if !lr.Hidden(listing.Path + e.Name) {
+			entries = append(entries, e)
+		}
+	}
+	var prefixes []string
+	for _, p := range listing.Prefixes {
+		if !lr.Hidden(listing.Path + p) {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	if !validSortKey(sortBy) {
+		sortBy = lr.sortBy
+	}
+	desc := lr.desc
+	switch order {
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	}
+	listing.SortBy = sortBy
+	listing.SortDesc = desc
+
+	var all []DirectoryEntry
+	for _, p := range prefixes {
+		all = append(all, DirectoryEntry{Name: p, IsDirectory: true})
+	}
+	all = append(all, entries...)
+
+	less := entryLess(sortBy)
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if lr.dirsFirst && a.IsDirectory != b.IsDirectory {
+			return a.IsDirectory
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+
+	listing.Entries = listing.Entries[:0]
+	listing.Prefixes = listing.Prefixes[:0]
+	for _, e := range all {
+		if e.IsDirectory {
+			listing.Prefixes = append(listing.Prefixes, e.Name)
+		} else {
+			listing.Entries = append(listing.Entries, e)
+		}
+	}
+	listing.All = all
+}
+
+func entryLess(sortBy string) func(a, b DirectoryEntry) bool {
+	switch sortBy {
+	case SortBySize:
+		return func(a, b DirectoryEntry) bool {
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+			return a.Name < b.Name
+		}
+	case SortByModTime:
+		return func(a, b DirectoryEntry) bool {
+			if ta, tb := a.Unix(), b.Unix(); ta != tb {
+				return ta < tb
+			}
+			return a.Name < b.Name
+		}
+	}
+	return func(a, b DirectoryEntry) bool {
+		return a.Name < b.Name
+	}
+}
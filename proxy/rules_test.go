@@ -0,0 +1,170 @@
+package proxy_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+func TestListingRulesHidden(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name string
+		opts proxy.Options
+		key  string
+		want bool
+	}{
+		{"no rules", proxy.Options{}, "dir/.env", false},
+		{"glob", proxy.Options{AutoindexHide: []string{"*.bak"}}, "dir/a.txt.bak", true},
+		{"glob matches the final component", proxy.Options{AutoindexHide: []string{"dir*"}}, "dir/a.txt", false},
+		{"glob on a directory", proxy.Options{AutoindexHide: []string{"tmp"}}, "dir/tmp/", true},
+		{"unmatched glob", proxy.Options{AutoindexHide: []string{"*.bak"}}, "dir/a.txt", false},
+		{"dir-only on a directory", proxy.Options{AutoindexHide: []string{"node_modules/"}}, "app/node_modules/", true},
+		{"dir-only on an object", proxy.Options{AutoindexHide: []string{"node_modules/"}}, "app/node_modules", false},
+		{"slash matches the whole key", proxy.Options{AutoindexHide: []string{"dir/*.json"}}, "dir/b.json", true},
+		{"slash in another directory", proxy.Options{AutoindexHide: []string{"dir/*.json"}}, "other/dir/b.json", false},
+		{"slash and dir-only", proxy.Options{AutoindexHide: []string{"dir/sub/"}}, "dir/sub/", true},
+		{"slash and dir-only on an object", proxy.Options{AutoindexHide: []string{"dir/sub/"}}, "dir/sub", false},
+		{"regexp", proxy.Options{AutoindexHide: []string{`re:^dir/.*\.json$`}}, "dir/b.json", true},
+		{"regexp matches the whole key", proxy.Options{AutoindexHide: []string{`re:^b\.json$`}}, "dir/b.json", false},
+		{"regexp on a directory", proxy.Options{AutoindexHide: []string{`re:/sub/$`}}, "dir/sub/", true},
+		{"dotfile", proxy.Options{AutoindexHideDotfiles: &yes}, "dir/.env", true},
+		{"dot directory", proxy.Options{AutoindexHideDotfiles: &yes}, ".git/", true},
+		{"dot in a parent", proxy.Options{AutoindexHideDotfiles: &yes}, ".git/config", false},
+		{"dot inside a name", proxy.Options{AutoindexHideDotfiles: &yes}, "dir/a.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lr, err := proxy.NewListingRules(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lr.Hidden(tt.key); got != tt.want {
+				t.Errorf("Hidden(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewListingRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts proxy.Options
+	}{
+		{"invalid glob", proxy.Options{AutoindexHide: []string{"[a-"}}},
+		{"invalid regexp", proxy.Options{AutoindexHide: []string{"re:("}}},
+		{"invalid sort", proxy.Options{AutoindexSort: "color"}},
+		{"invalid order", proxy.Options{AutoindexOrder: "sideways"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := proxy.NewListingRules(tt.opts); err == nil {
+				t.Error("NewListingRules() succeeded")
+			}
+		})
+	}
+}
+
+func TestListingRulesApply(t *testing.T) {
+	yes, no := true, false
+	at := func(day int) *time.Time {
+		ts := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)
+		return &ts
+	}
+	listing := func() *proxy.DirectoryListing {
+		return &proxy.DirectoryListing{
+			Path: "dir/",
+			Entries: []proxy.DirectoryEntry{
+				{Name: "b.json", Size: 7, ModTime: at(3)},
+				{Name: "a.txt", Size: 1, ModTime: at(2)},
+				{Name: "c.bak", Size: 30, ModTime: at(1)},
+				{Name: ".env", Size: 12, ModTime: at(4)},
+			},
+			Prefixes: []string{"sub/", ".git/"},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		opts     proxy.Options
+		sortBy   string
+		order    string
+		want     []string
+		wantSort string
+		wantDesc bool
+	}{
+		{
+			name:     "defaults",
+			want:     []string{".git/", "sub/", ".env", "a.txt", "b.json", "c.bak"},
+			wantSort: proxy.SortByName,
+		},
+		{
+			name:     "hidden",
+			opts:     proxy.Options{AutoindexHide: []string{"*.bak", "sub/"}, AutoindexHideDotfiles: &yes},
+			want:     []string{"a.txt", "b.json"},
+			wantSort: proxy.SortByName,
+		},
+		{
+			name:     "mixed directories",
+			opts:     proxy.Options{AutoindexDirsFirst: &no},
+			want:     []string{".env", ".git/", "a.txt", "b.json", "c.bak", "sub/"},
+			wantSort: proxy.SortByName,
+		},
+		{
+			name:     "configured sort",
+			opts:     proxy.Options{AutoindexSort: proxy.SortBySize, AutoindexOrder: "desc", AutoindexHideDotfiles: &yes},
+			want:     []string{"sub/", "c.bak", "b.json", "a.txt"},
+			wantSort: proxy.SortBySize,
+			wantDesc: true,
+		},
+		{
+			name:     "sort overridden",
+			opts:     proxy.Options{AutoindexSort: proxy.SortBySize, AutoindexHideDotfiles: &yes},
+			sortBy:   proxy.SortByModTime,
+			want:     []string{"sub/", "c.bak", "a.txt", "b.json"},
+			wantSort: proxy.SortByModTime,
+		},
+		{
+			name:     "order overridden",
+			opts:     proxy.Options{AutoindexOrder: "desc", AutoindexHideDotfiles: &yes},
+			order:    "asc",
+			want:     []string{"sub/", "a.txt", "b.json", "c.bak"},
+			wantSort: proxy.SortByName,
+		},
+		{
+			name:     "invalid overrides",
+			opts:     proxy.Options{AutoindexSort: proxy.SortByModTime, AutoindexOrder: "desc", AutoindexHideDotfiles: &yes},
+			sortBy:   "color",
+			order:    "sideways",
+			want:     []string{"sub/", "b.json", "a.txt", "c.bak"},
+			wantSort: proxy.SortByModTime,
+			wantDesc: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lr, err := proxy.NewListingRules(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := listing()
+			lr.Apply(l, tt.sortBy, tt.order)
+
+			var got []string
+			for _, e := range l.All {
+				got = append(got, e.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+			if l.SortBy != tt.wantSort || l.SortDesc != tt.wantDesc {
+				t.Errorf("sorted by %q, descending %v, want %q, %v", l.SortBy, l.SortDesc, tt.wantSort, tt.wantDesc)
+			}
+			if len(l.Entries)+len(l.Prefixes) != len(l.All) {
+				t.Errorf("%d entries and %d prefixes, want %d in all", len(l.Entries), len(l.Prefixes), len(l.All))
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/rs/zerolog"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+type backend struct {
+	Client    Client
+	Presigner Presigner
+	Region    string
+	Bucket    string
+}
+
+// NewBackend creates a new S3 backend under the default session configuration,
+// as modified by the connection settings in c.
+func NewBackend(c Config) (proxy.Backend, error) {
+	if c.Bucket == "" {
+		return nil, errors.New("Bucket name is required")
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend{
+		Client:    client,
+		Presigner: s3.NewPresignClient(client),
+		Region:    c.Region,
+		Bucket:    c.Bucket,
+	}, nil
+}
+
+func (b *backend) UpdateLogContext(c zerolog.Context, key string) zerolog.Context {
+	return c.
+		Str("s3_region", b.Region).
+		Str("s3_bucket", b.Bucket).
+		Str("s3_key", key)
+}
+
+func (b *backend) Stat(ctx context.Context, key string) (*proxy.ObjectInfo, error) {
+	i := &s3.HeadObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	}
+	out, err := b.Client.HeadObject(ctx, i)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &proxy.ObjectInfo{
+		Key:                key,
+		Size:               aws.ToInt64(out.ContentLength),
+		ModTime:            aws.ToTime(out.LastModified),
+		CacheControl:       aws.ToString(out.CacheControl),
+		ContentDisposition: aws.ToString(out.ContentDisposition),
+		ContentEncoding:    aws.ToString(out.ContentEncoding),
+		ContentLanguage:    aws.ToString(out.ContentLanguage),
+		ContentType:        aws.ToString(out.ContentType),
+		ETag:               aws.ToString(out.ETag),
+		Expires:            aws.ToString(out.ExpiresString),
+		IsDirectory:        aws.ToString(out.ContentType) == "application/x-directory",
+		RedirectLocation:   aws.ToString(out.WebsiteRedirectLocation),
+		Header:             metaHeaders(out.VersionId, out.Metadata),
+	}, nil
+}
+
+func (b *backend) Get(ctx context.Context, key string, rng *proxy.ByteRange) (*proxy.Object, error) {
+	i := &s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+		Range:  formatRange(rng),
+	}
+	out, err := b.Client.GetObject(ctx, i)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &proxy.Object{
+		ObjectInfo: proxy.ObjectInfo{
+			Key:                key,
+			Size:               aws.ToInt64(out.ContentLength),
+			ModTime:            aws.ToTime(out.LastModified),
+			CacheControl:       aws.ToString(out.CacheControl),
+			ContentDisposition: aws.ToString(out.ContentDisposition),
+			ContentEncoding:    aws.ToString(out.ContentEncoding),
+			ContentLanguage:    aws.ToString(out.ContentLanguage),
+			ContentType:        aws.ToString(out.ContentType),
+			ETag:               aws.ToString(out.ETag),
+			Expires:            aws.ToString(out.ExpiresString),
+			IsDirectory:        aws.ToString(out.ContentType) == "application/x-directory",
+			RedirectLocation:   aws.ToString(out.WebsiteRedirectLocation),
+			Header:             metaHeaders(out.VersionId, out.Metadata),
+		},
+		Body:         out.Body,
+		Length:       aws.ToInt64(out.ContentLength),
+		ContentRange: aws.ToString(out.ContentRange),
+	}, nil
+}
+
+func (b *backend) List(ctx context.Context, prefix string, opts proxy.ListOptions) (*proxy.ListResult, error) {
+	i := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	if opts.Token != "" {
+		i.ContinuationToken = aws.String(opts.Token)
+	}
+	if opts.Limit > 0 {
+		i.MaxKeys = aws.Int32(int32(opts.Limit))
+	}
+	out, err := b.Client.ListObjectsV2(ctx, i)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	res := &proxy.ListResult{
+		IsTruncated: aws.ToBool(out.IsTruncated),
+		NextToken:   aws.ToString(out.NextContinuationToken),
+	}
+	for _, content := range out.Contents {
+		res.Objects = append(res.Objects, proxy.ObjectInfo{
+			Key:     aws.ToString(content.Key),
+			Size:    aws.ToInt64(content.Size),
+			ModTime: aws.ToTime(content.LastModified),
+			ETag:    aws.ToString(content.ETag),
+		})
+	}
+	for _, cp := range out.CommonPrefixes {
+		res.Prefixes = append(res.Prefixes, aws.ToString(cp.Prefix))
+	}
+	return res, nil
+}
+
+func (b *backend) Delete(ctx context.Context, key string) error {
+	_, err := b.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return nil
+}
+
+// Copy copies objects with a single CopyObject request, which S3 only allows
+// for objects of up to 5 GiB.
+func (b *backend) Copy(ctx context.Context, src, dst string, opts proxy.CopyOptions) (*proxy.ObjectInfo, error) {
+	in := &s3.CopyObjectInput{
+		Bucket:     aws.String(b.Bucket),
+		Key:        aws.String(dst),
+		CopySource: aws.String(b.Bucket + "/" + (&url.URL{Path: src}).EscapedPath()),
+	}
+	if opts.IfNoneMatch {
+		in.IfNoneMatch = aws.String("*")
+	}
+	out, err := b.Client.CopyObject(ctx, in)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	info := &proxy.ObjectInfo{Key: dst}
+	if r := out.CopyObjectResult; r != nil {
+		info.ETag = aws.ToString(r.ETag)
+		info.ModTime = aws.ToTime(r.LastModified)
+	}
+	return info, nil
+}
+
+// metaHeaders returns the S3-specific headers of an object.
+func metaHeaders(versionID *string, meta map[string]string) http.Header {
+	hdr := http.Header{}
+	if v := aws.ToString(versionID); v != "" {
+		hdr.Add("X-Amz-Version-ID", v)
+	}
+	for k, v := range meta {
+		hdr.Add("X-Amz-Meta-"+k, v)
+	}
+	return hdr
+}
+
+func formatRange(rng *proxy.ByteRange) *string {
+	switch {
+	case rng == nil:
+		return nil
+	case rng.Offset < 0:
+		return aws.String(fmt.Sprintf("bytes=%d", rng.Offset))
+	case rng.Length < 0:
+		return aws.String(fmt.Sprintf("bytes=%d-", rng.Offset))
+	default:
+		return aws.String(fmt.Sprintf("bytes=%d-%d", rng.Offset, rng.Offset+rng.Length-1))
+	}
+}
+
+// wrapError converts errors returned by S3 into errors understood by the proxy.
+func wrapError(err error) error {
+	var apierr smithy.APIError
+	var resperr *awshttp.ResponseError
+	if !errors.As(err, &apierr) || !errors.As(err, &resperr) {
+		return err
+	}
+
+	return &proxy.BackendError{
+		StatusCode: resperr.HTTPStatusCode(),
+		Message:    errorMessage(apierr) + " Request ID: " + resperr.ServiceRequestID(),
+		Err:        err,
+		Fields: map[string]interface{}{
+			"amz_status_code": resperr.HTTPStatusCode(),
+			"amz_code":        apierr.ErrorCode(),
+			"amz_request_id":  resperr.ServiceRequestID(),
+		},
+	}
+}
+
+// errorMessage returns the human-readable message of an S3 error, falling back
+// to its code when S3 did not provide one (e.g., responses to HEAD requests).
+func errorMessage(err smithy.APIError) string {
+	if m := err.ErrorMessage(); m != "" {
+		return m
+	}
+	return err.ErrorCode()
+}
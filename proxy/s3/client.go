@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultEndpointRegion is the region used to sign requests to a custom
+// endpoint when no region was configured. Most S3-compatible stores, such as
+// MinIO and Ceph RGW, accept any region, and expect this one by default.
+const DefaultEndpointRegion = "us-east-1"
+
+// Client is the subset of the S3 API used by the handler.
+type Client interface {
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CopyObject(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+}
+
+// Presigner is the subset of the S3 presigning API used by the handler.
+type Presigner interface {
+	PresignPostObject(context.Context, *s3.PutObjectInput, ...func(*s3.PresignPostOptions)) (*s3.PresignedPostRequest, error)
+}
+
+// Config describes how to connect to S3 or an S3-compatible store.
+type Config struct {
+	Region string
+	Bucket string
+
+	// Endpoint is the base URL of an S3-compatible service, e.g.,
+	// http://localhost:9000 for a local MinIO. Empty uses AWS.
+	Endpoint string
+	// ForcePathStyle addresses buckets as http://endpoint/bucket/key rather
+	// than http://bucket.endpoint/key.
+	ForcePathStyle bool
+
+	// CAFile is a PEM bundle of additional certificate authorities to trust.
+	CAFile string
+	// InsecureSkipVerify disables TLS certificate verification entirely.
+	InsecureSkipVerify bool
+
+	// Profile selects a named profile from the shared AWS configuration.
+	Profile string
+	// AccessKeyID and SecretAccessKey are references to static credentials,
+	// in the form "env:NAME" or "file:/path". Both must be set together.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// AssumeRoleARN is a role assumed using the credentials obtained above.
+	AssumeRoleARN         string
+	AssumeRoleExternalID  string
+	AssumeRoleSessionName string
+}
+
+func newClient(c Config) (*s3.Client, error) {
+	hc, err := newHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	lo := []func(*config.LoadOptions) error{
+		config.WithHTTPClient(hc),
+	}
+	if c.Profile != "" {
+		lo = append(lo, config.WithSharedConfigProfile(c.Profile))
+	}
+	if c.AccessKeyID != "" || c.SecretAccessKey != "" {
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return nil, errors.New("both an access key ID and a secret access key reference are required")
+		}
+		p := referenceProvider{
+			AccessKeyID:     c.AccessKeyID,
+			SecretAccessKey: c.SecretAccessKey,
+		}
+		if _, err := p.Retrieve(context.Background()); err != nil {
+			return nil, err
+		}
+		lo = append(lo, config.WithCredentialsProvider(aws.NewCredentialsCache(p)))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), lo...)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Region != "" {
+		cfg.Region = c.Region
+	}
+	if cfg.Region == "" && c.Endpoint != "" {
+		cfg.Region = DefaultEndpointRegion
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("AWS region missing: you may need to set the AWS_REGION environment variable, or refer to the documentation")
+	}
+
+	// Each handler keeps its own credentials cache, so that handlers assuming
+	// different roles are refreshed independently of each other.
+	if c.AssumeRoleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(assumeRoleProvider(cfg, c))
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+		}
+		o.UsePathStyle = c.ForcePathStyle
+	}), nil
+}
+
+func newHTTPClient(c Config) (*awshttp.BuildableClient, error) {
+	hc := awshttp.NewBuildableClient()
+	if c.CAFile == "" && !c.InsecureSkipVerify {
+		return hc, nil
+	}
+
+	tc := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", c.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	return hc.WithTransportOptions(func(tr *http.Transport) {
+		tr.TLSClientConfig = tc
+	}), nil
+}
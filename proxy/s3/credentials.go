@@ -0,0 +1,61 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// DefaultSessionName is the role session name used when assuming a role
+// without an explicitly-configured session name.
+const DefaultSessionName = "ssp"
+
+// staticRefreshInterval is how often static credentials are re-read from their
+// references, so that rotated keys are eventually picked up without a restart.
+const staticRefreshInterval = 5 * time.Minute
+
+// referenceProvider retrieves static credentials from environment variables or
+// files, as named by references of the form "env:NAME" or "file:/path".
+type referenceProvider struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+func (p referenceProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	id, err := proxy.ResolveReference(p.AccessKeyID)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("resolving access key ID: %w", err)
+	}
+	secret, err := proxy.ResolveReference(p.SecretAccessKey)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("resolving secret access key: %w", err)
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     id,
+		SecretAccessKey: secret,
+		Source:          "ssp-reference",
+		CanExpire:       true,
+		Expires:         time.Now().Add(staticRefreshInterval),
+	}, nil
+}
+
+// assumeRoleProvider wraps the base configuration's credentials in a provider
+// that assumes the configured role.
+func assumeRoleProvider(cfg aws.Config, c Config) aws.CredentialsProvider {
+	return stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), c.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = DefaultSessionName
+		if c.AssumeRoleSessionName != "" {
+			o.RoleSessionName = c.AssumeRoleSessionName
+		}
+		if c.AssumeRoleExternalID != "" {
+			o.ExternalID = aws.String(c.AssumeRoleExternalID)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+)
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReferenceProviderRefresh(t *testing.T) {
+	dir := t.TempDir()
+	id, secret := filepath.Join(dir, "id"), filepath.Join(dir, "secret")
+	rotate := func(version string) {
+		writeFile(t, id, "AKID"+version+"\n")
+		writeFile(t, secret, version+"-secret\n")
+	}
+	rotate("OLD")
+	p := referenceProvider{AccessKeyID: "file:" + id, SecretAccessKey: "file:" + secret}
+	ctx := context.Background()
+
+	start := time.Now()
+	creds, err := p.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKIDOLD" || creds.SecretAccessKey != "OLD-secret" {
+		t.Errorf("Retrieve() = %q, %q", creds.AccessKeyID, creds.SecretAccessKey)
+	}
+	if !creds.CanExpire || creds.Expires.Before(start.Add(staticRefreshInterval)) || creds.Expires.After(time.Now().Add(staticRefreshInterval)) {
+		t.Errorf("Retrieve() expires at %v, want %v after %v", creds.Expires, staticRefreshInterval, start)
+	}
+
+	// Rotated keys are not used until the cached ones expire
+	cache := aws.NewCredentialsCache(p)
+	if _, err := cache.Retrieve(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rotate("NEW")
+	if creds, err := cache.Retrieve(ctx); err != nil || creds.AccessKeyID != "AKIDOLD" {
+		t.Errorf("cached Retrieve() = %q, %v, want the previous keys", creds.AccessKeyID, err)
+	}
+
+	// An expiry window as long as the refresh interval is the same as the
+	// interval having passed, so the keys are read again every time
+	expired := aws.NewCredentialsCache(p, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = staticRefreshInterval
+	})
+	for _, version := range []string{"NEW", "NEWER"} {
+		rotate(version)
+		creds, err := expired.Retrieve(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.AccessKeyID != "AKID"+version || creds.SecretAccessKey != version+"-secret" {
+			t.Errorf("expired Retrieve() = %q, %q, want the %s keys", creds.AccessKeyID, creds.SecretAccessKey, version)
+		}
+	}
+
+	os.Remove(secret)
+	if _, err := p.Retrieve(ctx); err == nil || !strings.Contains(err.Error(), "secret access key") {
+		t.Errorf("Retrieve() without a secret error = %v", err)
+	}
+}
+
+// assumeRoleRequest is what a fake STS received in a call to AssumeRole.
+type assumeRoleRequest struct {
+	Action, RoleARN, SessionName, ExternalID, Authorization string
+}
+
+// newFakeSTS starts a fake STS that issues credentials to anyone assuming a
+// role, and points clients at it.
+func newFakeSTS(t *testing.T) *[]assumeRoleRequest {
+	t.Helper()
+
+	var reqs []assumeRoleRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reqs = append(reqs, assumeRoleRequest{
+			Action:        r.PostForm.Get("Action"),
+			RoleARN:       r.PostForm.Get("RoleArn"),
+			SessionName:   r.PostForm.Get("RoleSessionName"),
+			ExternalID:    r.PostForm.Get("ExternalId"),
+			Authorization: r.Header.Get("Authorization"),
+		})
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>role-secret</SecretAccessKey>
+      <SessionToken>role-token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/reader/ssp</Arn>
+      <AssumedRoleId>AROAREADER:ssp</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>fake-request</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(srv.Close)
+
+	// Keep the configuration and credentials of the machine running the
+	// tests out of the way
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_ENDPOINT_URL_STS", srv.URL)
+	return &reqs
+}
+
+func TestAssumeRole(t *testing.T) {
+	t.Setenv("SSP_TEST_ACCESS_KEY_ID", "AKIDBASE")
+	t.Setenv("SSP_TEST_SECRET_ACCESS_KEY", "base-secret")
+	base := Config{
+		Region:          "us-west-2",
+		AccessKeyID:     "env:SSP_TEST_ACCESS_KEY_ID",
+		SecretAccessKey: "env:SSP_TEST_SECRET_ACCESS_KEY",
+	}
+
+	tests := []struct {
+		name            string
+		sessionName     string
+		externalID      string
+		wantSessionName string
+	}{
+		{"default session name", "", "", DefaultSessionName},
+		{"session name", "nightly-sync", "", "nightly-sync"},
+		{"external id", "", "ext-42", DefaultSessionName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs := newFakeSTS(t)
+			c := base
+			c.AssumeRoleARN = "arn:aws:iam::123456789012:role/reader"
+			c.AssumeRoleSessionName = tt.sessionName
+			c.AssumeRoleExternalID = tt.externalID
+
+			client, err := newClient(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache, ok := client.Options().Credentials.(*aws.CredentialsCache)
+			if !ok || !cache.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}) {
+				t.Fatalf("credentials = %T, want a cached role", client.Options().Credentials)
+			}
+
+			creds, err := cache.Retrieve(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.AccessKeyID != "ASIAROLE" || creds.SecretAccessKey != "role-secret" || creds.SessionToken != "role-token" {
+				t.Errorf("credentials = %q, %q, %q, want those of the role", creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+			}
+
+			if len(*reqs) != 1 {
+				t.Fatalf("STS received %d requests, want 1", len(*reqs))
+			}
+			req := (*reqs)[0]
+			if req.Action != "AssumeRole" || req.RoleARN != c.AssumeRoleARN {
+				t.Errorf("STS received %s of %q", req.Action, req.RoleARN)
+			}
+			if req.SessionName != tt.wantSessionName || req.ExternalID != tt.externalID {
+				t.Errorf("session name, external ID = %q, %q, want %q, %q", req.SessionName, req.ExternalID, tt.wantSessionName, tt.externalID)
+			}
+			// The role is assumed with the configured static credentials
+			if !strings.Contains(req.Authorization, "Credential=AKIDBASE/") {
+				t.Errorf("STS request signed with %q, want the base credentials", req.Authorization)
+			}
+		})
+	}
+
+	// Without a role, the static credentials are used directly
+	client, err := newClient(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache, ok := client.Options().Credentials.(*aws.CredentialsCache); !ok || !cache.IsCredentialsProvider(referenceProvider{}) {
+		t.Errorf("credentials = %T, want cached references", client.Options().Credentials)
+	}
+}
@@ -0,0 +1,183 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/ripta/ssp/proxy"
+)
+
+// maxUploadParts is the most parts that S3 accepts in a multipart upload.
+const maxUploadParts = 10000
+
+// abortTimeout is how long aborting a failed multipart upload may take, which
+// is done even when the upload failed because its request was canceled.
+const abortTimeout = 30 * time.Second
+
+// uploadPartSize is the size of each part of a multipart upload, which is
+// held in memory while it is sent. Bodies no larger than one part are
+// uploaded with a single PutObject instead.
+var uploadPartSize int64 = 16 << 20
+
+func (b *backend) Put(ctx context.Context, key string, body io.Reader, opts proxy.PutOptions) (*proxy.ObjectInfo, error) {
+	var part bytes.Buffer
+	if _, err := io.CopyN(&part, body, uploadPartSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if int64(part.Len()) < uploadPartSize {
+		out, err := b.Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:             aws.String(b.Bucket),
+			Key:                aws.String(key),
+			Body:               bytes.NewReader(part.Bytes()),
+			ContentLength:      aws.Int64(int64(part.Len())),
+			CacheControl:       nonEmpty(opts.CacheControl),
+			ContentDisposition: nonEmpty(opts.ContentDisposition),
+			ContentEncoding:    nonEmpty(opts.ContentEncoding),
+			ContentLanguage:    nonEmpty(opts.ContentLanguage),
+			ContentType:        nonEmpty(opts.ContentType),
+			Metadata:           opts.Metadata,
+			IfNoneMatch:        ifNoneMatch(opts),
+		})
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		return &proxy.ObjectInfo{Key: key, Size: int64(part.Len()), ETag: aws.ToString(out.ETag)}, nil
+	}
+	return b.putMultipart(ctx, key, &part, body, opts)
+}
+
+// putMultipart uploads the first part, which has already been read, and the
+// rest of body, one part at a time. The upload is aborted on any error,
+// including ctx being canceled, so that its parts are not kept around.
+func (b *backend) putMultipart(ctx context.Context, key string, part *bytes.Buffer, body io.Reader, opts proxy.PutOptions) (*proxy.ObjectInfo, error) {
+	create, err := b.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(b.Bucket),
+		Key:                aws.String(key),
+		CacheControl:       nonEmpty(opts.CacheControl),
+		ContentDisposition: nonEmpty(opts.ContentDisposition),
+		ContentEncoding:    nonEmpty(opts.ContentEncoding),
+		ContentLanguage:    nonEmpty(opts.ContentLanguage),
+		ContentType:        nonEmpty(opts.ContentType),
+		Metadata:           opts.Metadata,
+		ChecksumAlgorithm:  types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	info, err := b.uploadParts(ctx, key, create.UploadId, part, body, ifNoneMatch(opts))
+	if err != nil {
+		actx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+		defer cancel()
+		_, aerr := b.Client.AbortMultipartUpload(actx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(b.Bucket),
+			Key:      aws.String(key),
+			UploadId: create.UploadId,
+		})
+		return nil, errors.Join(err, aerr)
+	}
+	return info, nil
+}
+
+func (b *backend) uploadParts(ctx context.Context, key string, uploadID *string, part *bytes.Buffer, body io.Reader, ifNoneMatch *string) (*proxy.ObjectInfo, error) {
+	var parts []types.CompletedPart
+	var size int64
+	for n := int32(1); part.Len() > 0; n++ {
+		if n > maxUploadParts {
+			return nil, errors.New("upload has too many parts")
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		out, err := b.Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(b.Bucket),
+			Key:               aws.String(key),
+			UploadId:          uploadID,
+			PartNumber:        aws.Int32(n),
+			Body:              bytes.NewReader(part.Bytes()),
+			ContentLength:     aws.Int64(int64(part.Len())),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:          out.ETag,
+			ChecksumCRC32: out.ChecksumCRC32,
+			PartNumber:    aws.Int32(n),
+		})
+		size += int64(part.Len())
+
+		part.Reset()
+		if _, err := io.CopyN(part, body, uploadPartSize); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	out, err := b.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.Bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		IfNoneMatch:     ifNoneMatch,
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &proxy.ObjectInfo{Key: key, Size: size, ETag: aws.ToString(out.ETag)}, nil
+}
+
+// SignPost signs a POST policy for objects under prefix, named after the
+// uploaded file. Browsers may also set the Content-Type of the object.
+func (b *backend) SignPost(ctx context.Context, prefix string, opts proxy.PostOptions) (*proxy.PostPolicy, error) {
+	conditions := []any{
+		[]any{"starts-with", "$key", prefix},
+		[]any{"starts-with", "$Content-Type", ""},
+		[]any{"content-length-range", 0, opts.MaxBytes},
+	}
+	if opts.RedirectURL != "" {
+		conditions = append(conditions, map[string]string{"success_action_redirect": opts.RedirectURL})
+	}
+
+	expires := time.Now().Add(opts.Expires)
+	out, err := b.Presigner.PresignPostObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(prefix + "${filename}"),
+	}, func(o *s3.PresignPostOptions) {
+		o.Expires = opts.Expires
+		o.Conditions = conditions
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := out.Values
+	if opts.RedirectURL != "" {
+		fields["success_action_redirect"] = opts.RedirectURL
+	}
+	return &proxy.PostPolicy{URL: out.URL, Fields: fields, Expires: expires}, nil
+}
+
+// ifNoneMatch returns the precondition that keeps an upload from replacing an
+// existing object, if requested, which S3 refuses with 412 Precondition Failed.
+func ifNoneMatch(opts proxy.PutOptions) *string {
+	if !opts.IfNoneMatch {
+		return nil
+	}
+	return aws.String("*")
+}
+
+// nonEmpty returns a pointer to s, or nil when it is empty.
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
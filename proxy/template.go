@@ -1,45 +1,354 @@
 package proxy
 
 import (
+	"context"
+	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/ripta/ssp/proxy/reload"
 )
 
-const directoryListingTemplateText = `
-<!doctype html>
+// BackendTemplatePrefix marks an autoindex template that is stored as an object
+// in the handler's own backend, rather than as a local file.
+const BackendTemplatePrefix = "backend:"
+
+// backendTemplateTTL is how long a template loaded from a backend is cached.
+const backendTemplateTTL = time.Minute
+
+const directoryListingTemplateText = `<!doctype html>
 <html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Index of /{{ .Path }}</title>
+	<style>
+		body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222; }
+		nav a { text-decoration: none; }
+		table { border-collapse: collapse; width: 100%; }
+		th, td { padding: 0.3em 0.6em; text-align: left; white-space: nowrap; }
+		th { border-bottom: 2px solid #ccc; cursor: pointer; user-select: none; }
+		th[aria-sort=ascending]::after { content: " \25B4"; }
+		th[aria-sort=descending]::after { content: " \25BE"; }
+		tbody tr:nth-child(even) { background: #f6f6f6; }
+		td.name { width: 100%; white-space: normal; word-break: break-all; }
+		td.size, th.size { text-align: right; }
+		.icon { display: inline-block; width: 1.5em; }
+		.pages, .archives, .upload { margin-top: 1em; }
+		.readme { margin: 1.5em 0; padding: 0 1em; border-left: 3px solid #ddd; }
+		.readme pre { overflow-x: auto; }
+	</style>
+</head>
 <body>
-	<ul>
-	{{- range $i, $prefix := .Prefixes }}
-		<li><a href="{{ $prefix }}">{{ $prefix }}</a></li>
+	<h1>Index of
+	<nav style="display: inline">
+	{{- range $i, $crumb := .Breadcrumbs }}
+		<a href="{{ $crumb.URL }}">{{ $crumb.Name }}</a>
+	{{- end }}
+	</nav>
+	</h1>
+	{{- if .Header }}
+	<header class="readme">{{ .Header }}</header>
+	{{- end }}
+	<table id="listing">
+		<thead>
+			<tr>
+				<th data-type="text"{{ if eq .SortBy "name" }} aria-sort="{{ if .SortDesc }}descending{{ else }}ascending{{ end }}"{{ end }}>Name</th>
+				<th class="size" data-type="number"{{ if eq .SortBy "size" }} aria-sort="{{ if .SortDesc }}descending{{ else }}ascending{{ end }}"{{ end }}>Size</th>
+				<th data-type="number"{{ if eq .SortBy "mtime" }} aria-sort="{{ if .SortDesc }}descending{{ else }}ascending{{ end }}"{{ end }}>Modified</th>
+			</tr>
+		</thead>
+		<tbody>
+		{{- if .ParentURL }}
+			<tr data-fixed><td class="name"><span class="icon">&#x2B11;</span><a href="{{ .ParentURL }}">Parent directory</a></td><td></td><td></td></tr>
+		{{- end }}
+		{{- range $i, $entry := .All }}
+		{{- if $entry.IsDirectory }}
+			<tr><td class="name" data-sort="0{{ $entry.Name }}"><span class="icon">{{ $entry.Icon }}</span><a href="{{ $entry.Name }}">{{ $entry.Name }}</a></td><td class="size" data-sort="-1">&mdash;</td><td data-sort="0"></td></tr>
+		{{- else }}
+			<tr><td class="name" data-sort="1{{ $entry.Name }}"><span class="icon">{{ $entry.Icon }}</span><a href="{{ $entry.Name }}">{{ $entry.Name }}</a></td><td class="size" data-sort="{{ $entry.Size }}" title="{{ $entry.Size }} bytes">{{ $entry.HumanSize }}</td><td data-sort="{{ $entry.Unix }}">{{ $entry.HumanModTime }}</td></tr>
+		{{- end }}
+		{{- end }}
+		</tbody>
+	</table>
+	{{- if or .PrevURL .NextURL }}
+	<p class="pages">
+		{{- if .PrevURL }}<a href="{{ .PrevURL }}" rel="prev">&larr; previous</a>{{ end }}
+		{{- if .NextURL }} <a href="{{ .NextURL }}" rel="next">next &rarr;</a>{{ end }}
+	</p>
+	{{- end }}
+	{{- if .ArchiveFormats }}
+	<p class="archives">Download as
+		{{- range $i, $format := .ArchiveFormats }}{{ if $i }},{{ end }} <a href="?archive={{ $format }}" download>{{ $format }}</a>{{ end }}
+	</p>
 	{{- end }}
-	{{- range $i, $entry := .Entries }}
-		<li><a href="{{ $entry.Name }}">{{ $entry.Name }}</a> <em>{{ $entry.Size }} bytes</em></li>
+	{{- with .Upload }}
+	<form class="upload" method="post" action="{{ .URL }}" enctype="multipart/form-data">
+		{{- range $name, $value := .Fields }}
+		<input type="hidden" name="{{ $name }}" value="{{ $value }}">
+		{{- end }}
+		<input type="file" name="file" required>
+		<button type="submit">Upload</button>
+	</form>
 	{{- end }}
-	</ul>
+	{{- if .Readme }}
+	<article class="readme">{{ .Readme }}</article>
+	{{- end }}
+	<script>
+	document.querySelectorAll("#listing th").forEach(function (th, col) {
+		th.addEventListener("click", function () {
+			var asc = th.getAttribute("aria-sort") !== "ascending";
+			document.querySelectorAll("#listing th").forEach(function (o) { o.removeAttribute("aria-sort"); });
+			th.setAttribute("aria-sort", asc ? "ascending" : "descending");
+			var tbody = document.querySelector("#listing tbody");
+			var rows = Array.prototype.slice.call(tbody.querySelectorAll("tr:not([data-fixed])"));
+			var num = th.dataset.type === "number";
+			rows.sort(function (a, b) {
+				var x = a.children[col].dataset.sort, y = b.children[col].dataset.sort;
+				var c = num ? parseFloat(x) - parseFloat(y) : x.localeCompare(y);
+				return asc ? c : -c;
+			});
+			rows.forEach(function (r) { tbody.appendChild(r); });
+		});
+	});
+	</script>
 </body>
 </html>
 `
 
-var directoryListingTemplate = template.Must(template.New("autoindex").Parse(directoryListingTemplateText))
+var directoryListingTemplate = template.Must(newListingTemplate(directoryListingTemplateText))
 
-// DirectoryListing is a directory or prefix and all its entries.
+// DirectoryListing is a directory or prefix and one page of its entries. It
+// is also the context in which autoindex templates are executed.
 type DirectoryListing struct {
+	// Path is the directory's path as requested by the client, without any
+	// leading slash, while Prefix is the corresponding prefix in the backend.
+	Path   string
+	Prefix string
+
+	// Host is the requested host, and Vars are the variables captured by the
+	// handler's host and path patterns, e.g., {username}.
+	Host string
+	Vars map[string]string
+
+	// Breadcrumbs link to each ancestor of the directory, starting at the root
+	// and ending with the directory itself. ParentURL links to the parent
+	// directory, and is empty at the root.
+	Breadcrumbs []Breadcrumb
+	ParentURL   string
+
+	// ArchiveFormats are the formats in which the directory can be downloaded
+	// with the "archive" query parameter, if any.
+	ArchiveFormats []string
+
+	// Upload, when set, is a signed POST policy with which the user can
+	// upload files to the directory straight from the listing.
+	Upload *PostPolicy
+
+	// Header and Readme are the sanitized HTML contents of the directory's
+	// header and readme objects, shown above and below the entries.
+	Header template.HTML
+	Readme template.HTML
+
+	// Entries are the objects in the directory, and Prefixes the names of its
+	// subdirectories. All combines both, in the order they should be shown,
+	// as sorted by SortBy.
 	Entries     []DirectoryEntry
 	Prefixes    []string
+	All         []DirectoryEntry
 	IsTruncated bool
+
+	SortBy   string
+	SortDesc bool
+
+	// Token is the continuation token of this page, and NextToken that of the
+	// next page. PageSize is the maximum number of entries in a page.
+	Token     string
+	NextToken string
+	PageSize  int
+
+	// PrevURL and NextURL link to the adjacent pages of the listing, if any.
+	PrevURL string
+	NextURL string
+}
+
+// Breadcrumb is one component of a directory's path.
+type Breadcrumb struct {
+	Name string
+	URL  string
 }
 
 // DirectoryEntry is an entry within a directory.
 type DirectoryEntry struct {
-	Name    string
-	Size    int64
-	ModTime *time.Time
+	Name        string
+	Size        int64
+	ModTime     *time.Time
+	ETag        string
+	ContentType string
+	IsDirectory bool
+}
+
+// HumanSize returns the size of the entry in binary units, e.g., "1.5 KiB".
+func (e DirectoryEntry) HumanSize() string {
+	return humanSize(e.Size)
+}
+
+// HumanModTime returns the modification time of the entry in UTC, or an empty
+// string if it is not known.
+func (e DirectoryEntry) HumanModTime() string {
+	if e.ModTime == nil || e.ModTime.IsZero() {
+		return ""
+	}
+	return e.ModTime.UTC().Format("2006-01-02 15:04")
+}
+
+// Unix returns the modification time of the entry in seconds since the epoch,
+// or zero if it is not known.
+func (e DirectoryEntry) Unix() int64 {
+	if e.ModTime == nil || e.ModTime.IsZero() {
+		return 0
+	}
+	return e.ModTime.Unix()
+}
+
+// Icon returns an icon for the entry based on its content type.
+func (e DirectoryEntry) Icon() string {
+	if e.IsDirectory {
+		return "\U0001F4C1"
+	}
+	ct := e.ContentType
+	switch {
+	case strings.HasPrefix(ct, "image/"):
+		return "\U0001F5BC"
+	case strings.HasPrefix(ct, "video/"):
+		return "\U0001F39E"
+	case strings.HasPrefix(ct, "audio/"):
+		return "\U0001F3B5"
+	case strings.Contains(ct, "zip"), strings.Contains(ct, "tar"), strings.Contains(ct, "compressed"):
+		return "\U0001F4E6"
+	case strings.HasPrefix(ct, "text/"), strings.Contains(ct, "json"), strings.Contains(ct, "xml"):
+		return "\U0001F4C4"
+	}
+	return "\U0001F4CE"
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// breadcrumbs returns the breadcrumbs of a directory path, which must either
+// be empty or end in a slash.
+func breadcrumbs(path string) []Breadcrumb {
+	crumbs := []Breadcrumb{{Name: "/", URL: "/"}}
+	url := "/"
+	for _, part := range strings.Split(strings.TrimSuffix(path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		url += part + "/"
+		crumbs = append(crumbs, Breadcrumb{Name: part + "/", URL: url})
+	}
+	return crumbs
+}
+
+var listingTemplateFuncs = template.FuncMap{
+	"humanSize": humanSize,
+	"formatTime": func(layout string, t *time.Time) string {
+		if t == nil || t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(layout)
+	},
+}
+
+func newListingTemplate(text string) (*template.Template, error) {
+	return template.New("autoindex").Funcs(listingTemplateFuncs).Parse(text)
 }
 
 // RenderDirectoryListing renders a text/template for directory listings.
 func RenderDirectoryListing(w http.ResponseWriter, listing DirectoryListing) error {
+	return renderHTMLListing(w, directoryListingTemplate, listing)
+}
+
+func renderHTMLListing(w http.ResponseWriter, t *template.Template, listing DirectoryListing) error {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	return directoryListingTemplate.Execute(w, listing)
+	return t.Execute(w, listing)
+}
+
+// templateLoader provides the autoindex template of a handler, which is either
+// the default template, a local file loaded once, or an object in the
+// handler's backend that is periodically reloaded.
+type templateLoader struct {
+	tmpl   *template.Template
+	loader *reload.Loader[*template.Template]
+}
+
+func newTemplateLoader(b Backend, source string) (*templateLoader, error) {
+	tl := &templateLoader{
+		tmpl: directoryListingTemplate,
+	}
+	if source == "" {
+		return tl, nil
+	}
+
+	if key, ok := strings.CutPrefix(source, BackendTemplatePrefix); ok {
+		key = strings.TrimPrefix(key, "/")
+		tl.loader = reload.New(backendTemplateTTL, func(ctx context.Context) (*template.Template, error) {
+			return loadTemplate(ctx, b, key)
+		})
+		return tl, nil
+	}
+
+	text, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newListingTemplate(string(text))
+	if err != nil {
+		return nil, err
+	}
+	tl.tmpl = t
+	return tl, nil
+}
+
+// Template returns the current template. When a template stored in the
+// backend cannot be loaded, the previously-loaded template is returned along
+// with the error, falling back to the default template.
+func (tl *templateLoader) Template(ctx context.Context) (*template.Template, error) {
+	if tl.loader == nil {
+		return tl.tmpl, nil
+	}
+
+	t, err := tl.loader.Get(ctx)
+	if t == nil {
+		t = tl.tmpl
+	}
+	return t, err
+}
+
+func loadTemplate(ctx context.Context, b Backend, key string) (*template.Template, error) {
+	obj, err := b.Get(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	text, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, err
+	}
+	return newListingTemplate(string(text))
 }
@@ -0,0 +1,136 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripta/ssp/proxy"
+	"github.com/ripta/ssp/proxy/proxytest"
+)
+
+// testTemplate renders the documented context of autoindex templates as
+// plain text.
+const testTemplate = `path={{ .Path }}
+{{ range .Breadcrumbs }}crumb={{ .Name }} {{ .URL }}
+{{ end }}parent={{ .ParentURL }}
+{{ range .All }}entry={{ .Name }} dir={{ .IsDirectory }} size={{ .HumanSize }} bytes={{ humanSize .Size }} modified={{ formatTime "2006-01-02" .ModTime }}
+{{ end }}`
+
+// templateObjects are the objects served while testing templates, including
+// one large enough to be sized in KiB.
+func templateObjects(extra ...proxytest.Object) []proxytest.Object {
+	return append([]proxytest.Object{
+		{Key: "docs/guides/big.bin", Body: strings.Repeat("x", 1536), ContentType: "application/octet-stream", ModTime: time.Date(2024, 6, 7, 8, 9, 10, 0, time.UTC)},
+		{Key: "docs/guides/small.txt", Body: "hi", ContentType: "text/plain", ModTime: time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC)},
+		{Key: "docs/guides/old/a.txt", Body: "a", ContentType: "text/plain"},
+	}, extra...)
+}
+
+func writeTemplate(t *testing.T, text string) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "listing.tmpl")
+	if err := os.WriteFile(name, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestHandlerTemplate(t *testing.T) {
+	want := `path=docs/guides/
+crumb=/ /
+crumb=docs/ /docs/
+crumb=guides/ /docs/guides/
+parent=../
+entry=old/ dir=true size=0 B bytes=0 B modified=
+entry=big.bin dir=false size=1.5 KiB bytes=1.5 KiB modified=2024-06-07
+entry=small.txt dir=false size=2 B bytes=2 B modified=2024-06-08
+`
+
+	tests := []struct {
+		name     string
+		objects  []proxytest.Object
+		template string
+	}{
+		{"file", templateObjects(), writeTemplate(t, testTemplate)},
+		{"backend", templateObjects(proxytest.Object{Key: "templates/listing.tmpl", Body: testTemplate}), "backend:/templates/listing.tmpl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			autoindex := true
+			h, err := proxy.NewHandler(proxytest.NewMemoryBackend(tt.objects), proxy.Options{
+				Autoindex:         &autoindex,
+				AutoindexTemplate: tt.template,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/guides/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("Content-Type = %q, want text/html", ct)
+			}
+			if body := w.Body.String(); body != want {
+				t.Errorf("body =\n%s\nwant\n%s", body, want)
+			}
+		})
+	}
+}
+
+func TestHandlerTemplateUnavailable(t *testing.T) {
+	// A template that cannot be loaded from the backend falls back to the
+	// default one
+	for name, body := range map[string]string{"missing": "", "invalid": "{{ .Path "} {
+		t.Run(name, func(t *testing.T) {
+			objects := templateObjects()
+			if body != "" {
+				objects = append(objects, proxytest.Object{Key: "templates/listing.tmpl", Body: body})
+			}
+			autoindex := true
+			h, err := proxy.NewHandler(proxytest.NewMemoryBackend(objects), proxy.Options{
+				Autoindex:         &autoindex,
+				AutoindexTemplate: "backend:templates/listing.tmpl",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/guides/", nil))
+			if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "<title>Index of /docs/guides/</title>") {
+				t.Errorf("status = %d, body =\n%s\nwant the default listing", w.Code, body)
+			}
+		})
+	}
+}
+
+func TestNewHandlerTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.tmpl")},
+		{"unterminated action", writeTemplate(t, "{{ .Path ")},
+		{"unknown function", writeTemplate(t, `{{ shout .Path }}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			autoindex := true
+			if _, err := proxy.NewHandler(proxytest.NewMemoryBackend(nil), proxy.Options{
+				Autoindex:         &autoindex,
+				AutoindexTemplate: tt.template,
+			}); err == nil {
+				t.Error("NewHandler() succeeded")
+			}
+		})
+	}
+}
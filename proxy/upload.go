@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	pathpkg "path"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// MetadataHeaderPrefix begins the names of request headers whose values are
+// stored as user metadata of an uploaded object, e.g., X-Ssp-Meta-Build.
+const MetadataHeaderPrefix = "X-Ssp-Meta-"
+
+// IsWriteMethod reports whether requests with method may change objects, which
+// is true of anything but downloads, listings and CORS preflights.
+func IsWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, MethodPropfind:
+		return false
+	}
+	return true
+}
+
+func (h *handler) serveUpload(w http.ResponseWriter, r *http.Request, key string) {
+	log := hlog.FromRequest(r)
+
+	u, ok := h.Backend.(Uploader)
+	if !ok || !h.Options.UploadEnabled() {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		http.Error(w, "Uploads require the key of an object, not a directory.", http.StatusBadRequest)
+		return
+	}
+
+	max := h.Options.UploadMaxSize()
+	if r.ContentLength > max {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	opts := putOptions(r)
+	opts.IfNoneMatch = opts.IfNoneMatch || !h.Options.OverwriteEnabled()
+	if opts.ContentType == "" {
+		opts.ContentType = mime.TypeByExtension(pathpkg.Ext(key))
+	}
+	info, err := u.Put(r.Context(), key, http.MaxBytesReader(w, r.Body, max), opts)
+	if err != nil {
+		var mbe *http.MaxBytesError
+		var be *BackendError
+		switch {
+		case errors.As(err, &mbe):
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		case opts.IfNoneMatch && IsExist(err):
+			http.Error(w, "An object already exists at this key, and may not be replaced.", http.StatusPreconditionFailed)
+		case errors.As(err, &be):
+			log.Error().Err(err).Fields(be.Fields).Msg("upload error")
+			http.Error(w, be.Message, be.StatusCode)
+		default:
+			log.Error().Err(err).Msg("generic upload error")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	copyStringHeader(w, "ETag", info.ETag)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *handler) serveDelete(w http.ResponseWriter, r *http.Request, key string) {
+	log := hlog.FromRequest(r)
+
+	d, ok := h.Backend.(Deleter)
+	if !ok || !h.Options.DeleteEnabled() {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		http.Error(w, "Deletes require the key of an object, not a directory.", http.StatusBadRequest)
+		return
+	}
+
+	if err := d.Delete(r.Context(), key); err != nil {
+		var be *BackendError
+		switch {
+		case IsNotExist(err):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case errors.As(err, &be):
+			log.Error().Err(err).Fields(be.Fields).Msg("delete error")
+			http.Error(w, be.Message, be.StatusCode)
+		default:
+			log.Error().Err(err).Msg("generic delete error")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putOptions returns the attributes of an object uploaded by r, which are
+// taken from its headers. Only "If-None-Match: *" is understood as a
+// precondition, which creates the object without replacing any other.
+func putOptions(r *http.Request) PutOptions {
+	opts := PutOptions{
+		Size:               r.ContentLength,
+		IfNoneMatch:        strings.TrimSpace(r.Header.Get("If-None-Match")) == "*",
+		CacheControl:       r.Header.Get("Cache-Control"),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		ContentEncoding:    r.Header.Get("Content-Encoding"),
+		ContentLanguage:    r.Header.Get("Content-Language"),
+		ContentType:        r.Header.Get("Content-Type"),
+	}
+	for k, vs := range r.Header {
+		name, ok := strings.CutPrefix(k, MetadataHeaderPrefix)
+		if !ok || name == "" || len(vs) == 0 {
+			continue
+		}
+		if opts.Metadata == nil {
+			opts.Metadata = map[string]string{}
+		}
+		opts.Metadata[strings.ToLower(name)] = vs[0]
+	}
+	return opts
+}
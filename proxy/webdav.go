@@ -0,0 +1,812 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Methods added to HTTP by WebDAV, as defined by RFC 4918.
+const (
+	MethodPropfind  = "PROPFIND"
+	MethodProppatch = "PROPPATCH"
+	MethodMkcol     = "MKCOL"
+	MethodCopy      = "COPY"
+	MethodMove      = "MOVE"
+	MethodLock      = "LOCK"
+	MethodUnlock    = "UNLOCK"
+)
+
+// WebDAVMethods are the methods, besides those of plain HTTP, that a handler
+// serves when WebDAV is enabled.
+var WebDAVMethods = []string{
+	http.MethodOptions,
+	MethodPropfind,
+	MethodProppatch,
+	MethodMkcol,
+	MethodCopy,
+	MethodMove,
+	MethodLock,
+	MethodUnlock,
+}
+
+// WebDAVMaxObjects is the largest number of objects in a collection that may
+// be copied, moved or deleted at once.
+const WebDAVMaxObjects = 10000
+
+// webdavLockTimeout is how long locks are granted for. Clients refresh them
+// for as long as they need them.
+const webdavLockTimeout = time.Hour
+
+// errCollectionTooLarge is returned when a collection has more objects than
+// WebDAVMaxObjects.
+var errCollectionTooLarge = errors.New("collection has too many objects")
+
+// Destination returns the path of the key that a COPY or MOVE request is to,
+// like the path of a request URL, which is taken from its Destination header.
+// It is false when the header is missing or invalid.
+func Destination(r *http.Request) (string, bool) {
+	d := r.Header.Get("Destination")
+	if d == "" {
+		return "", false
+	}
+	u, err := url.Parse(d)
+	if err != nil || u.Path == "" {
+		return "", false
+	}
+
+	// Unlike request paths, which are cleaned by the router, the destination
+	// is whatever the client sent
+	p := pathpkg.Clean("/" + u.Path)
+	if strings.HasSuffix(u.Path, "/") && p != "/" {
+		p += "/"
+	}
+	return p, true
+}
+
+// davResource is what a WebDAV request refers to: either an object, or a
+// collection of objects under a prefix.
+type davResource struct {
+	// Key is the key of the object, or the prefix of the collection.
+	Key          string
+	IsCollection bool
+	// Info describes the object, or the placeholder of the collection when
+	// there is one.
+	Info *ObjectInfo
+}
+
+// serveWebDAV serves requests with methods added or changed by WebDAV, and
+// reports whether r was one of them.
+func (h *handler) serveWebDAV(w http.ResponseWriter, r *http.Request, key string) bool {
+	switch r.Method {
+	case http.MethodOptions:
+		h.serveDAVOptions(w, r)
+	case MethodPropfind:
+		h.servePropfind(w, r, key)
+	case MethodProppatch:
+		h.serveProppatch(w, r, key)
+	case MethodMkcol:
+		h.serveMkcol(w, r, key)
+	case MethodCopy, MethodMove:
+		h.serveCopy(w, r, key)
+	case MethodLock:
+		h.serveLock(w, r, key)
+	case MethodUnlock:
+		h.serveUnlock(w, r)
+	case http.MethodDelete:
+		h.serveDAVDelete(w, r, key)
+	default:
+		return false
+	}
+	return true
+}
+
+// davAllowed returns the methods allowed by the handler, along with whether
+// locks are supported, which they are only when objects may be changed.
+func (h *handler) davAllowed() ([]string, bool) {
+	_, canUpload := h.Backend.(Uploader)
+	_, canDelete := h.Backend.(Deleter)
+	_, canCopy := h.Backend.(Copier)
+	canUpload = canUpload && h.Options.UploadEnabled()
+	canDelete = canDelete && h.Options.DeleteEnabled()
+
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodOptions, MethodPropfind, MethodProppatch}
+	if canUpload {
+		methods = append(methods, http.MethodPut, MethodMkcol, MethodLock, MethodUnlock)
+	}
+	if canDelete {
+		methods = append(methods, http.MethodDelete)
+	}
+	if canUpload && canCopy {
+		methods = append(methods, MethodCopy)
+		if canDelete {
+			methods = append(methods, MethodMove)
+		}
+	}
+	return methods, canUpload
+}
+
+func (h *handler) serveDAVOptions(w http.ResponseWriter, r *http.Request) {
+	methods, locks := h.davAllowed()
+	// Clients only mount a share writable when it claims to support locks
+	if locks {
+		w.Header().Set("DAV", "1, 2")
+	} else {
+		w.Header().Set("DAV", "1")
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+}
+
+// resolve returns the resource at key, which is an object, or a collection
+// when anything exists under key as a prefix. Collections created by MKCOL
+// exist as a placeholder object whose key ends in a slash.
+func (h *handler) resolve(ctx context.Context, key string) (*davResource, error) {
+	var dir *ObjectInfo
+	if key != "" && !strings.HasSuffix(key, "/") {
+		info, err := h.Backend.Stat(ctx, key)
+		switch {
+		case err == nil && !info.IsDirectory:
+			return &davResource{Key: key, Info: info}, nil
+		case err == nil:
+			dir = info
+		case !IsNotExist(err):
+			return nil, err
+		}
+		key += "/"
+	}
+	if key == "" {
+		return &davResource{IsCollection: true}, nil
+	}
+
+	res, err := h.Backend.List(ctx, key, ListOptions{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Objects) > 0 && res.Objects[0].Key == key {
+		dir = &res.Objects[0]
+	}
+	if dir == nil && len(res.Objects) == 0 && len(res.Prefixes) == 0 {
+		return nil, ErrNotExist
+	}
+	return &davResource{Key: key, IsCollection: true, Info: dir}, nil
+}
+
+// walkCollection returns the key of every object under prefix, descending into
+// each subdirectory, including placeholders but not private objects. It fails
+// with errCollectionTooLarge as soon as there are more than WebDAVMaxObjects.
+func (h *handler) walkCollection(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	dirs := []string{prefix}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		opts := ListOptions{Limit: MaxPageSize}
+		for {
+			res, err := h.Backend.List(ctx, dir, opts)
+			if err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, res.Prefixes...)
+			for _, obj := range res.Objects {
+				if !h.Options.Private(obj.Key) {
+					keys = append(keys, obj.Key)
+				}
+			}
+			if len(keys) > WebDAVMaxObjects {
+				return nil, errCollectionTooLarge
+			}
+
+			if !res.IsTruncated || res.NextToken == "" {
+				break
+			}
+			opts.Token = res.NextToken
+		}
+	}
+	return keys, nil
+}
+
+// davPropfind is the body of a PROPFIND request. An empty body asks for all
+// properties.
+type davPropfind struct {
+	XMLName  xml.Name      `xml:"DAV: propfind"`
+	AllProp  *struct{}     `xml:"DAV: allprop"`
+	PropName *struct{}     `xml:"DAV: propname"`
+	Prop     *davPropNames `xml:"DAV: prop"`
+}
+
+// davPropNames are the names of the properties in a prop element.
+type davPropNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// davProppatch is the body of a PROPPATCH request, whose set and remove
+// instructions are not told apart, since neither is allowed.
+type davProppatch struct {
+	XMLName      xml.Name `xml:"DAV: propertyupdate"`
+	Instructions []struct {
+		Prop davPropNames `xml:"DAV: prop"`
+	} `xml:",any"`
+}
+
+// davLockinfo is the body of a LOCK request that creates a lock.
+type davLockinfo struct {
+	XMLName xml.Name `xml:"DAV: lockinfo"`
+	Owner   struct {
+		InnerXML string `xml:",innerxml"`
+	} `xml:"DAV: owner"`
+}
+
+// davResponse describes a single resource of a multistatus response.
+type davResponse struct {
+	XMLName   xml.Name      `xml:"D:response"`
+	Href      string        `xml:"D:href"`
+	Propstats []davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	Props []davProperty `xml:",any"`
+}
+
+// davProperty is a property of a resource, whose value is already encoded.
+// The names of properties in the DAV: namespace are prefixed with "D:".
+type davProperty struct {
+	XMLName  xml.Name
+	InnerXML string `xml:",innerxml"`
+}
+
+// davName returns the name of a property as it is written in responses.
+func davName(n xml.Name) xml.Name {
+	if n.Space == "DAV:" {
+		return xml.Name{Local: "D:" + n.Local}
+	}
+	return n
+}
+
+func davText(name, value string) davProperty {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return davProperty{XMLName: xml.Name{Local: "D:" + name}, InnerXML: b.String()}
+}
+
+func davStatus(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+// davDisplayName returns the name of the resource at the request path p.
+func davDisplayName(p string) string {
+	if p == "" {
+		return ""
+	}
+	return pathpkg.Base(p)
+}
+
+// davHref returns the escaped form of the request path p.
+func davHref(p string) string {
+	return (&url.URL{Path: "/" + p}).EscapedPath()
+}
+
+// davProps returns every property of the resource described by info, which
+// may be nil for collections without a placeholder.
+func (h *handler) davProps(name string, isCollection bool, info *ObjectInfo) []davProperty {
+	props := []davProperty{davText("displayname", name)}
+	if isCollection {
+		props = append(props, davProperty{XMLName: xml.Name{Local: "D:resourcetype"}, InnerXML: "<D:collection/>"})
+	} else {
+		ct := info.ContentType
+		if ct == "" {
+			ct = mime.TypeByExtension(pathpkg.Ext(name))
+		}
+		props = append(props,
+			davProperty{XMLName: xml.Name{Local: "D:resourcetype"}},
+			davText("getcontentlength", fmt.Sprint(info.Size)),
+			davText("getcontenttype", ct),
+			davText("getetag", info.ETag),
+		)
+	}
+	if info != nil && !info.ModTime.IsZero() {
+		props = append(props, davText("getlastmodified", info.ModTime.UTC().Format(http.TimeFormat)))
+	}
+	if _, locks := h.davAllowed(); locks {
+		props = append(props,
+			davProperty{XMLName: xml.Name{Local: "D:supportedlock"}, InnerXML: "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"},
+			davProperty{XMLName: xml.Name{Local: "D:lockdiscovery"}},
+		)
+	}
+	return props
+}
+
+// response returns the properties of a resource that were asked for.
+func (pf *davPropfind) response(href string, props []davProperty) davResponse {
+	resp := davResponse{Href: href}
+	switch {
+	case pf.Prop != nil:
+		var found, missing []davProperty
+		for _, n := range pf.Prop.Names {
+			name := davName(n.XMLName)
+			i := 0
+			for i < len(props) && props[i].XMLName != name {
+				i++
+			}
+			if i < len(props) {
+				found = append(found, props[i])
+			} else {
+				missing = append(missing, davProperty{XMLName: name})
+			}
+		}
+		if len(found) > 0 {
+			resp.Propstats = append(resp.Propstats, davPropstat{Prop: davProp{found}, Status: davStatus(http.StatusOK)})
+		}
+		if len(missing) > 0 {
+			resp.Propstats = append(resp.Propstats, davPropstat{Prop: davProp{missing}, Status: davStatus(http.StatusNotFound)})
+		}
+	case pf.PropName != nil:
+		names := make([]davProperty, len(props))
+		for i, p := range props {
+			names[i] = davProperty{XMLName: p.XMLName}
+		}
+		resp.Propstats = []davPropstat{{Prop: davProp{names}, Status: davStatus(http.StatusOK)}}
+	default:
+		resp.Propstats = []davPropstat{{Prop: davProp{props}, Status: davStatus(http.StatusOK)}}
+	}
+	return resp
+}
+
+// multistatusWriter streams the responses of a 207 Multi-Status, so that large
+// collections are never held in memory all at once.
+type multistatusWriter struct {
+	w   http.ResponseWriter
+	enc *xml.Encoder
+}
+
+func (m *multistatusWriter) write(resp davResponse) error {
+	if m.enc == nil {
+		m.w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		m.w.WriteHeader(http.StatusMultiStatus)
+		if _, err := io.WriteString(m.w, xml.Header+`<D:multistatus xmlns:D="DAV:">`); err != nil {
+			return err
+		}
+		m.enc = xml.NewEncoder(m.w)
+	}
+	return m.enc.Encode(resp)
+}
+
+func (m *multistatusWriter) close() error {
+	_, err := io.WriteString(m.w, "</D:multistatus>\n")
+	return err
+}
+
+// servePropfind responds with the properties of the resource at key, and
+// those of its members when it is a collection and Depth is 1. Since listing
+// whole trees is expensive, a Depth of infinity is refused, and a missing
+// Depth is taken to be 1.
+func (h *handler) servePropfind(w http.ResponseWriter, r *http.Request, key string) {
+	log := hlog.FromRequest(r)
+
+	depth := r.Header.Get("Depth")
+	if strings.EqualFold(depth, "infinity") {
+		writeDAVError(w, http.StatusForbidden, "propfind-finite-depth")
+		return
+	}
+
+	var pf davPropfind
+	if err := xml.NewDecoder(r.Body).Decode(&pf); err != nil && err != io.EOF {
+		http.Error(w, "Could not parse the PROPFIND request.", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.resolve(r.Context(), key)
+	if err != nil {
+		h.serveBackendError(w, r, err, "propfind error")
+		return
+	}
+
+	reqPath := requestPath(r)
+	if !res.IsCollection {
+		ms := &multistatusWriter{w: w}
+		if err := ms.write(pf.response(davHref(reqPath), h.davProps(davDisplayName(reqPath), false, res.Info))); err == nil {
+			ms.close()
+		}
+		return
+	}
+
+	if reqPath != "" && !strings.HasSuffix(reqPath, "/") {
+		reqPath += "/"
+	}
+	ms := &multistatusWriter{w: w}
+	if err := ms.write(pf.response(davHref(reqPath), h.davProps(davDisplayName(reqPath), true, res.Info))); err != nil {
+		return
+	}
+	if depth != "0" {
+		if err := h.propfindMembers(r.Context(), ms, &pf, res.Key, reqPath); err != nil {
+			// The response has already been committed, so the only way to
+			// tell the client that it is incomplete is to abort it
+			log.Error().Err(err).Msg("propfind listing error")
+			panic(http.ErrAbortHandler)
+		}
+	}
+	ms.close()
+}
+
+// propfindMembers writes the properties of every object and collection
+// immediately under prefix, except those hidden from listings.
+func (h *handler) propfindMembers(ctx context.Context, ms *multistatusWriter, pf *davPropfind, prefix, reqPath string) error {
+	opts := ListOptions{Limit: MaxPageSize}
+	for {
+		res, err := h.Backend.List(ctx, prefix, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range res.Prefixes {
+			name := strings.TrimPrefix(p, prefix)
+			if h.Rules.Hidden(reqPath + name) {
+				continue
+			}
+			if err := ms.write(pf.response(davHref(reqPath+name), h.davProps(strings.TrimSuffix(name, "/"), true, nil))); err != nil {
+				return err
+			}
+		}
+		for i, obj := range res.Objects {
+			name := strings.TrimPrefix(obj.Key, prefix)
+			// Skip the placeholder of the collection itself
+			if name == "" || h.Options.Private(obj.Key) || h.Rules.Hidden(reqPath+name) {
+				continue
+			}
+			if err := ms.write(pf.response(davHref(reqPath+name), h.davProps(name, false, &res.Objects[i]))); err != nil {
+				return err
+			}
+		}
+
+		if !res.IsTruncated || res.NextToken == "" {
+			return nil
+		}
+		opts.Token = res.NextToken
+	}
+}
+
+// serveProppatch refuses to change any property, since objects only have
+// those derived from their contents.
+func (h *handler) serveProppatch(w http.ResponseWriter, r *http.Request, key string) {
+	var pp davProppatch
+	if err := xml.NewDecoder(r.Body).Decode(&pp); err != nil {
+		http.Error(w, "Could not parse the PROPPATCH request.", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.resolve(r.Context(), key); err != nil {
+		h.serveBackendError(w, r, err, "proppatch error")
+		return
+	}
+
+	var props []davProperty
+	for _, in := range pp.Instructions {
+		for _, n := range in.Prop.Names {
+			props = append(props, davProperty{XMLName: davName(n.XMLName)})
+		}
+	}
+	ms := &multistatusWriter{w: w}
+	if err := ms.write(davResponse{
+		Href:      davHref(requestPath(r)),
+		Propstats: []davPropstat{{Prop: davProp{props}, Status: davStatus(http.StatusForbidden)}},
+	}); err == nil {
+		ms.close()
+	}
+}
+
+// serveMkcol creates an empty collection at key, by uploading a placeholder.
+func (h *handler) serveMkcol(w http.ResponseWriter, r *http.Request, key string) {
+	u, ok := h.Backend.(Uploader)
+	if !ok || !h.Options.UploadEnabled() {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if r.ContentLength > 0 {
+		http.Error(w, "MKCOL requests may not have a body.", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	key = strings.TrimSuffix(key, "/")
+	_, err := h.resolve(r.Context(), key)
+	switch {
+	case err == nil:
+		http.Error(w, "A resource already exists here.", http.StatusMethodNotAllowed)
+		return
+	case !IsNotExist(err):
+		h.serveBackendError(w, r, err, "mkcol error")
+		return
+	}
+
+	if _, err := u.Put(r.Context(), key+"/", http.NoBody, PutOptions{IfNoneMatch: true}); err != nil {
+		if IsExist(err) {
+			http.Error(w, "A resource already exists here.", http.StatusMethodNotAllowed)
+			return
+		}
+		h.serveBackendError(w, r, err, "mkcol error")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveDAVDelete deletes the object at key, or every object in the collection
+// at key.
+func (h *handler) serveDAVDelete(w http.ResponseWriter, r *http.Request, key string) {
+	d, ok := h.Backend.(Deleter)
+	if !ok || !h.Options.DeleteEnabled() {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, err := h.resolve(r.Context(), key)
+	if err != nil {
+		h.serveBackendError(w, r, err, "delete error")
+		return
+	}
+	if !res.IsCollection {
+		h.serveDelete(w, r, res.Key)
+		return
+	}
+	if res.Key == "" {
+		http.Error(w, "The root collection may not be deleted.", http.StatusForbidden)
+		return
+	}
+
+	if err := h.deleteCollection(r.Context(), d, res); err != nil {
+		h.serveBackendError(w, r, err, "delete error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteCollection deletes every object in the collection res, including its
+// placeholder.
+func (h *handler) deleteCollection(ctx context.Context, d Deleter, res *davResource) error {
+	keys, err := h.walkCollection(ctx, res.Key)
+	if err != nil {
+		return err
+	}
+	if res.Info != nil && res.Info.Key != res.Key {
+		keys = append(keys, res.Info.Key)
+	}
+	for _, k := range keys {
+		if err := d.Delete(ctx, k); err != nil && !IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveCopy copies the resource at key to the key in the Destination header,
+// within the backend, and deletes it afterwards when moving it. Collections
+// are copied one object at a time.
+func (h *handler) serveCopy(w http.ResponseWriter, r *http.Request, key string) {
+	move := r.Method == MethodMove
+	c, canCopy := h.Backend.(Copier)
+	d, canDelete := h.Backend.(Deleter)
+	canDelete = canDelete && h.Options.DeleteEnabled()
+	if !canCopy || !h.Options.UploadEnabled() || move && !canDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	dst, ok := Destination(r)
+	if !ok {
+		http.Error(w, "COPY and MOVE require a Destination header.", http.StatusBadRequest)
+		return
+	}
+	dst = strings.TrimPrefix(dst, "/")
+
+	src, err := h.resolve(r.Context(), key)
+	if err != nil {
+		h.serveBackendError(w, r, err, "copy error")
+		return
+	}
+	if src.IsCollection {
+		if dst != "" && !strings.HasSuffix(dst, "/") {
+			dst += "/"
+		}
+	} else {
+		dst = strings.TrimSuffix(dst, "/")
+	}
+	if dst == "" || dst == src.Key || src.IsCollection && strings.HasPrefix(dst, src.Key) {
+		http.Error(w, "Resources may not be copied onto or into themselves.", http.StatusForbidden)
+		return
+	}
+
+	// Objects are replaced by the copy, but anything else at the destination
+	// is deleted first, as it would otherwise be merged with the copy
+	overwrite := !strings.EqualFold(r.Header.Get("Overwrite"), "F") && h.Options.OverwriteEnabled()
+	existing, err := h.resolve(r.Context(), dst)
+	switch {
+	case err == nil && !overwrite:
+		http.Error(w, "A resource already exists at the destination, and may not be replaced.", http.StatusPreconditionFailed)
+		return
+	case err == nil && (existing.IsCollection || src.IsCollection):
+		if !canDelete {
+			http.Error(w, "A resource already exists at the destination, and may not be deleted.", http.StatusForbidden)
+			return
+		}
+		if existing.IsCollection {
+			err = h.deleteCollection(r.Context(), d, existing)
+		} else {
+			err = d.Delete(r.Context(), existing.Key)
+		}
+		if err != nil {
+			h.serveBackendError(w, r, err, "copy error")
+			return
+		}
+	case err != nil && !IsNotExist(err):
+		h.serveBackendError(w, r, err, "copy error")
+		return
+	}
+
+	keys := []string{src.Key}
+	if src.IsCollection {
+		if keys, err = h.walkCollection(r.Context(), src.Key); err != nil {
+			h.serveBackendError(w, r, err, "copy error")
+			return
+		}
+		// Copying a collection with a Depth of 0 only copies the collection
+		// itself, and not its members
+		if r.Header.Get("Depth") == "0" && !move {
+			keys = nil
+			if src.Info != nil && src.Info.Key == src.Key {
+				keys = []string{src.Key}
+			} else if u, ok := h.Backend.(Uploader); ok {
+				if _, err := u.Put(r.Context(), dst, http.NoBody, PutOptions{IfNoneMatch: !overwrite}); err != nil {
+					h.serveBackendError(w, r, err, "copy error")
+					return
+				}
+			}
+		}
+	}
+	opts := CopyOptions{IfNoneMatch: !overwrite}
+	for _, k := range keys {
+		if h.Options.Private(dst + strings.TrimPrefix(k, src.Key)) {
+			http.Error(w, "The destination may not be changed.", http.StatusForbidden)
+			return
+		}
+		if _, err := c.Copy(r.Context(), k, dst+strings.TrimPrefix(k, src.Key), opts); err != nil {
+			if opts.IfNoneMatch && IsExist(err) {
+				http.Error(w, "A resource already exists at the destination, and may not be replaced.", http.StatusPreconditionFailed)
+				return
+			}
+			h.serveBackendError(w, r, err, "copy error")
+			return
+		}
+	}
+
+	if move {
+		var err error
+		if src.IsCollection {
+			err = h.deleteCollection(r.Context(), d, src)
+		} else {
+			err = d.Delete(r.Context(), src.Key)
+		}
+		if err != nil && !IsNotExist(err) {
+			h.serveBackendError(w, r, err, "move error")
+			return
+		}
+	}
+
+	if existing != nil {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// serveLock grants or refreshes a lock on the resource at key. Object stores
+// cannot enforce locks, so they are granted to anyone who may change objects,
+// and otherwise ignored, as clients expect to be able to lock what they write.
+func (h *handler) serveLock(w http.ResponseWriter, r *http.Request, key string) {
+	if _, locks := h.davAllowed(); !locks {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var li davLockinfo
+	var token string
+	switch err := xml.NewDecoder(r.Body).Decode(&li); {
+	case err == io.EOF:
+		// Refreshing a lock has no body, but names the lock in If
+		ifHeader := r.Header.Get("If")
+		start, end := strings.Index(ifHeader, "<"), strings.Index(ifHeader, ">")
+		if start < 0 || end < start {
+			http.Error(w, "Refreshing a lock requires its token in an If header.", http.StatusBadRequest)
+			return
+		}
+		token = ifHeader[start+1 : end]
+	case err != nil:
+		http.Error(w, "Could not parse the LOCK request.", http.StatusBadRequest)
+		return
+	default:
+		token = newLockToken()
+		w.Header().Set("Lock-Token", "<"+token+">")
+	}
+
+	depth := "infinity"
+	if r.Header.Get("Depth") == "0" {
+		depth = "0"
+	}
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(token))
+	escToken := b.String()
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s<D:prop xmlns:D=\"DAV:\"><D:lockdiscovery><D:activelock>"+
+		"<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>"+
+		"<D:depth>%s</D:depth><D:owner>%s</D:owner><D:timeout>Second-%d</D:timeout>"+
+		"<D:locktoken><D:href>%s</D:href></D:locktoken><D:lockroot><D:href>%s</D:href></D:lockroot>"+
+		"</D:activelock></D:lockdiscovery></D:prop>\n",
+		xml.Header, depth, li.Owner.InnerXML, int(webdavLockTimeout.Seconds()), escToken, davHref(requestPath(r)))
+}
+
+func (h *handler) serveUnlock(w http.ResponseWriter, r *http.Request) {
+	if _, locks := h.davAllowed(); !locks {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Lock-Token") == "" {
+		http.Error(w, "UNLOCK requires a Lock-Token header.", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newLockToken returns a unique lock token, as a random UUID.
+func newLockToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// writeDAVError responds with a WebDAV error body naming the precondition that
+// failed.
+func writeDAVError(w http.ResponseWriter, code int, condition string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "%s<D:error xmlns:D=\"DAV:\"><D:%s/></D:error>\n", xml.Header, condition)
+}
+
+// serveBackendError responds with the status of err, as returned by the
+// backend, and logs it with msg.
+func (h *handler) serveBackendError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	log := hlog.FromRequest(r)
+
+	var be *BackendError
+	switch {
+	case IsNotExist(err):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, errCollectionTooLarge):
+		http.Error(w, fmt.Sprintf("Collections of more than %d objects may not be copied, moved or deleted.", WebDAVMaxObjects), http.StatusForbidden)
+	case errors.As(err, &be):
+		log.Error().Err(err).Fields(be.Fields).Msg(msg)
+		http.Error(w, be.Message, be.StatusCode)
+	default:
+		log.Error().Err(err).Msg("generic " + msg)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+}